@@ -0,0 +1,266 @@
+package gotrace
+
+import (
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Accumulator holds the weighted sum of the samples traced around each pixel of an image, see Filter.
+// A rendering into an accumulator can be stopped and resumed, as new samples are added to the previous ones.
+type Accumulator struct {
+	width, height int
+	// weighted sum of the samples splatted into each pixel, and sum of their weights, in image order (top to bottom)
+	sum    []Vec3
+	weight []float64
+	// number of samples taken in each pixel
+	samples []int
+	// sum of the luminances of the samples taken in each pixel, and of their squares, from which their variance is estimated
+	lum, lumSquared []float64
+	// number of passes rendered into the accumulator, used to seed the random sources of the next pass
+	passes int
+	// auxiliary images rendered along the image, which share its numbers of samples
+	aovs []aovBuffer
+}
+
+// NewAccumulator creates an empty accumulator for an image of the given size, which also renders the given AOVs.
+// The zero value is an accumulator which is sized by the first rendering into it.
+func NewAccumulator(width, height int, aovs ...AOV) *Accumulator {
+	a := &Accumulator{
+		width:      width,
+		height:     height,
+		sum:        make([]Vec3, width*height),
+		weight:     make([]float64, width*height),
+		samples:    make([]int, width*height),
+		lum:        make([]float64, width*height),
+		lumSquared: make([]float64, width*height),
+	}
+	for _, aov := range aovs {
+		if a.aovBuffer(aov) == nil {
+			a.aovs = append(a.aovs, aovBuffer{aov: aov, sum: make([]Vec3, width*height)})
+		}
+	}
+	return a
+}
+
+// Size returns the size of the accumulated image
+func (a *Accumulator) Size() (width, height int) {
+	return a.width, a.height
+}
+
+// Passes returns the number of passes rendered into the accumulator
+func (a *Accumulator) Passes() int {
+	return a.passes
+}
+
+// Samples returns the number of samples accumulated for the pixel (x, y), the origin being the top left corner
+func (a *Accumulator) Samples(x, y int) int {
+	return a.samples[y*a.width+x]
+}
+
+// MinSamples returns the number of samples of the least sampled pixel
+func (a *Accumulator) MinSamples() int {
+	if len(a.samples) == 0 {
+		return 0
+	}
+	min := a.samples[0]
+	for _, n := range a.samples {
+		if n < min {
+			min = n
+		}
+	}
+	return min
+}
+
+// errorRadius is the radius of the neighbourhood of the pixels whose samples are used to estimate their error
+const errorRadius = 2
+
+/*
+RelativeError estimates the relative error of the pixel (x, y) as the standard error of the mean luminance of its samples,
+divided by the mean. Means below 0.01 count as 0.01, so that dark pixels don't need to be sampled until their noise,
+which can't be seen, is averaged out.
+
+The variance and the mean of the luminance are estimated from the samples of the pixels closer than errorRadius, as the
+few samples of a pixel are often all black when light is hard to reach, which would make it look converged. The variance
+is the variance of the samples around the mean of their own pixel, so that the contrast of edges isn't taken for noise.
+The error of pixels having less than two samples is infinite.
+*/
+func (a *Accumulator) RelativeError(x, y int) float64 {
+	if a.samples[y*a.width+x] < 2 {
+		return math.Inf(1)
+	}
+	// numbers of samples and of pixels, sum of the luminances and sum of their squared deviations to their pixel's mean
+	var n, pixels, lum, deviations float64
+	for j := y - errorRadius; j <= y+errorRadius; j++ {
+		for i := x - errorRadius; i <= x+errorRadius; i++ {
+			if i < 0 || j < 0 || i >= a.width || j >= a.height {
+				continue
+			}
+			k := j*a.width + i
+			if a.samples[k] == 0 {
+				continue
+			}
+			n += float64(a.samples[k])
+			pixels++
+			lum += a.lum[k]
+			deviations += math.Max(0, a.lumSquared[k]-a.lum[k]*a.lum[k]/float64(a.samples[k]))
+		}
+	}
+	variance := deviations / (n - pixels)
+	return math.Sqrt(variance/float64(a.samples[y*a.width+x])) / math.Max(lum/n, 0.01)
+}
+
+// remainingSamples returns the number of samples still needed by each pixel, which is 0 for the pixels which have all
+// their samples, and for the converged pixels of an adaptive sampling
+func (a *Accumulator) remainingSamples(opts RenderOptions) []int {
+	remaining := make([]int, len(a.samples))
+	for k, n := range a.samples {
+		if opts.TargetError > 0 && n >= opts.MinSamples && a.RelativeError(k%a.width, k/a.width) <= opts.TargetError {
+			continue
+		}
+		if n < opts.Samples {
+			remaining[k] = opts.Samples - n
+		}
+	}
+	return remaining
+}
+
+// SampleHeatmap returns an image of the number of samples of each pixel, from dark blue for no samples to dark red for
+// the most sampled pixels, which shows where adaptive sampling spent its samples
+func (a *Accumulator) SampleHeatmap() *image.RGBA {
+	max := 1
+	for _, n := range a.samples {
+		if n > max {
+			max = n
+		}
+	}
+	// colors of evenly spaced numbers of samples, the numbers in between being interpolated
+	stops := []Vec3{{0, 0, 0.5}, {0, 0.5, 1}, {0.5, 1, 0.5}, {1, 0.75, 0}, {0.6, 0, 0}}
+	img := image.NewRGBA(image.Rect(0, 0, a.width, a.height))
+	for k, n := range a.samples {
+		t := float64(n) / float64(max) * float64(len(stops)-1)
+		i := int(math.Min(t, float64(len(stops)-2)))
+		f := t - float64(i)
+		img.SetRGBA(k%a.width, k/a.width, linearColor(stops[i].Scale(1-f).Add(stops[i+1].Scale(f))))
+	}
+	return img
+}
+
+// Average returns the weighted mean of the samples of the pixel (x, y), or black if it has no samples
+func (a *Accumulator) Average(x, y int) Vec3 {
+	k := y*a.width + x
+	if a.weight[k] <= 0 {
+		return BLACK
+	}
+	return a.sum[k].Div(a.weight[k])
+}
+
+// Image returns the current image mapped by the default SRGB tone mapper, where pixels having no samples are transparent
+func (a *Accumulator) Image() *image.RGBA {
+	return a.ToneMap(SRGB{})
+}
+
+// ToneMap returns the current image mapped by the tone mapper, where pixels having no samples are transparent
+func (a *Accumulator) ToneMap(m ToneMapper) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, a.width, a.height))
+	for k, w := range a.weight {
+		if w > 0 {
+			img.SetRGBA(k%a.width, k/a.width, toneMap(m, a.sum[k].Div(w)))
+		}
+	}
+	return img
+}
+
+// Framebuffer returns the linear radiance of the pixels, where pixels having no samples are black
+func (a *Accumulator) Framebuffer() *Framebuffer {
+	fb := NewFramebuffer(a.width, a.height)
+	for k := range a.samples {
+		fb.SetPixel(k%a.width, k/a.width, a.Average(k%a.width, k/a.width))
+	}
+	return fb
+}
+
+// lineSamples returns the number of samples of the least sampled pixel of each line, from top to bottom
+func (a *Accumulator) lineSamples() []int {
+	lines := make([]int, a.height)
+	for y := range lines {
+		lines[y] = a.samples[y*a.width]
+		for x := 1; x < a.width; x++ {
+			if n := a.samples[y*a.width+x]; n < lines[y] {
+				lines[y] = n
+			}
+		}
+	}
+	return lines
+}
+
+// add counts n samples taken in the pixel (x, y), along with the sums of their luminances and of their squares, and the
+// samples of its AOVs, in the order of a.aovs. Different pixels can be added concurrently.
+func (a *Accumulator) add(x, y int, n int, lum, lumSquared float64, aovs []Vec3) {
+	k := y*a.width + x
+	a.lum[k] += lum
+	a.lumSquared[k] += lumSquared
+	for i, buffer := range a.aovs {
+		if buffer.aov == AOVActorID {
+			// IDs can't be averaged, the first sample is kept
+			if a.samples[k] == 0 {
+				buffer.sum[k] = aovs[i]
+			}
+		} else {
+			buffer.sum[k] = buffer.sum[k].Add(aovs[i])
+		}
+	}
+	a.samples[k] += n
+}
+
+// splat adds samples of the given weighted sum and sum of weights to the pixel (x, y)
+// Samples are splatted into the pixels of neighbouring tiles, so splats must not be concurrent.
+func (a *Accumulator) splat(x, y int, sum Vec3, weight float64) {
+	k := y*a.width + x
+	a.sum[k] = a.sum[k].Add(sum)
+	a.weight[k] += weight
+}
+
+// PassFunc is called after each pass of a progressive rendering, with the accumulated samples.
+// Returning an error stops the rendering.
+type PassFunc func(acc *Accumulator) error
+
+// WriteSnapshot returns a PassFunc writing the current image to file after each pass, with the given encoder.
+// The file is replaced atomically, so that it always holds a complete image. A nil encoder writes PNG images.
+func WriteSnapshot(file string, encode func(w io.Writer, acc *Accumulator) error) PassFunc {
+	if encode == nil {
+		encode = func(w io.Writer, acc *Accumulator) error {
+			return png.Encode(w, acc.Image())
+		}
+	}
+	return func(acc *Accumulator) error {
+		return writeAtomic(file, func(f *os.File) error {
+			return encode(f, acc)
+		})
+	}
+}
+
+// writeAtomic writes a file through a temporary file in the same directory, which is then renamed to the file
+func writeAtomic(file string, write func(f *os.File) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// temporary files are only readable by their owner
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
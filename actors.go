@@ -1,10 +1,5 @@
 package gotrace
 
-import (
-	"math/rand"
-	"sort"
-)
-
 // HitRecord defines the intersection of a Ray and an Actor
 type HitRecord struct {
 	Distance float64
@@ -12,21 +7,44 @@ type HitRecord struct {
 	Normal   Vec3
 	Material Material
 	U, V     float64
+	// ActorID identifies the actor which was hit, see NewScene
+	ActorID int
+	// VertexColor is the color of the vertices of a mesh interpolated at the hit, if HasVertexColor is true
+	VertexColor    Vec3
+	HasVertexColor bool
 }
 
 // Actor is an object on the scene having a shape and a material
 type Actor struct {
 	shape    Geometry
 	material Material
+	// id is given by the scene, 0 meaning that the actor isn't part of a scene
+	id int
+}
+
+// NewActor returns an actor of the given shape and material
+func NewActor(shape Geometry, material Material) Actor {
+	return Actor{shape: shape, material: material}
 }
 
 // Hit checks if the geometry is hit by the ray, and creates a HitRecord with the actor's material
 func (a Actor) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
-	if hit, record := a.shape.Hit(ray, tMin, tMax); hit {
-		record.Material = a.material
-		return true, record
+	var record HitRecord
+	if !a.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
+	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for an Actor
+func (a Actor) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
+	if !hitRecord(a.shape, ray, tMin, tMax, record) {
+		return false
 	}
-	return false, nil
+	record.Material = a.material
+	record.ActorID = a.id
+	return true
 }
 
 // Bound returns the bounding box of an actor, which is defined by its shape
@@ -44,19 +62,27 @@ func (c *Collection) Add(actors ...Actor) {
 
 // Hit returns the closest intersection of a Ray with a Collection if such an intersection exists, otherwise it returns false with a nil pointer
 func (c Collection) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	var record HitRecord
+	if !c.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
+	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for a Collection, keeping the closest hit of its actors
+func (c Collection) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
 	hitAnything := false
 	closestHit := tMax
-	var closestRecord *HitRecord = nil
 
 	for i := 0; i < len(c); i++ {
-		if hit, record := c[i].Hit(ray, tMin, closestHit); hit {
-			closestRecord = record
+		if c[i].hitRecord(ray, tMin, closestHit, record) {
 			closestHit = record.Distance
 			hitAnything = true
 		}
 	}
 
-	return hitAnything, closestRecord
+	return hitAnything
 }
 
 // Bound computes the bounding box of a Collection
@@ -83,77 +109,56 @@ func (c Collection) Bound(tMin float64, tMax float64) (bool, *Bbox) {
 	return true, &collectionBox
 }
 
-// Comparator returns a comparison function of objects in the collection along the given axis. Used for Index sorting.
-func (c Collection) Comparator(startTime, endTime float64, axis int) func(i, j int) bool {
-	return func(i, j int) bool {
-		leftBound, leftBox := c[i].Bound(startTime, endTime)
-		rightBound, rightBox := c[j].Bound(startTime, endTime)
-
-		if !leftBound || !rightBound {
-			panic("no bounding box")
-		}
-
-		return leftBox.Min.AsArray()[axis] < rightBox.Min.AsArray()[axis]
-	}
-}
-
 // Index is a binary tree forming a bounding volume hierarchy of objects satisfying the geometry interface
 type Index struct {
 	box   Bbox
 	left  Geometry
 	right Geometry
+	// unbounded actors, which can't be part of the hierarchy, are hit one after the other at its root
+	unbounded Collection
 }
 
-// NewIndex builds a bounding volume hierarchy
+// NewIndex builds a bounding volume hierarchy of the actors of the world from start to end included, with the SAHIndex strategy
 func NewIndex(world Collection, start, end int, startTime, endTime float64) *Index {
-	// chose random axis for sorting
-	comparator := world.Comparator(startTime, endTime, rand.Intn(3))
-
-	idx := Index{}
-	span := end - start + 1
-	if span == 1 {
-		idx.left = world[start]
-		idx.right = world[start]
-	} else if span == 2 {
-		if comparator(start, end) {
-			idx.left = world[start]
-			idx.right = world[start+1]
-		} else {
-			idx.left = world[start+1]
-			idx.right = world[start]
-		}
-	} else {
-		sort.Slice(world[start:end], comparator) //TODO does this sort the view or the slice ?
-		mid := start + span/2
-		idx.left = NewIndex(world, start, mid, startTime, endTime)
-		idx.right = NewIndex(world, mid, end, startTime, endTime)
-	}
-
-	_, leftBox := idx.left.Bound(startTime, endTime) // TODO error handling if no box (infinite planes)
-	_, rightBox := idx.right.Bound(startTime, endTime)
-	idx.box = leftBox.Merge(*rightBox)
-	return &idx
+	return BuildIndex(world[start:end+1], startTime, endTime, SAHIndex)
 }
 
 // Hit implements the hit interface for the Index
 func (idx *Index) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
-	if !idx.box.Hit(ray, tMin, tMax) {
+	var record HitRecord
+	if !idx.hitRecord(ray, tMin, tMax, &record) {
 		return false, nil
 	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for the Index, the record of the left child being kept if the right one
+// isn't hit
+func (idx *Index) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
+	hit := false
+	if len(idx.unbounded) > 0 && idx.unbounded.hitRecord(ray, tMin, tMax, record) {
+		hit = true
+		tMax = record.Distance
+	}
+	if idx.left == nil || !idx.box.Hit(ray, tMin, tMax) {
+		return hit
+	}
 
-	// two different records to not override by nil pointer if not in right
-	hitLeft, recordLeft := idx.left.Hit(ray, tMin, tMax)
-	if hitLeft {
-		tMax = recordLeft.Distance
+	if hitRecord(idx.left, ray, tMin, tMax, record) {
+		hit = true
+		tMax = record.Distance
 	}
-	hitRight, recordRight := idx.right.Hit(ray, tMin, tMax)
-	if hitRight {
-		return true, recordRight
+	if idx.right == nil {
+		return hit
 	}
-	return hitLeft, recordLeft
+	return hitRecord(idx.right, ray, tMin, tMax, record) || hit
 }
 
-// Bound returns the bounding box of the Index
+// Bound returns the bounding box of the Index, which has none if it is empty or contains unbounded actors
 func (idx *Index) Bound(tMin float64, tMax float64) (bool, *Bbox) {
+	if idx.left == nil || len(idx.unbounded) > 0 {
+		return false, nil
+	}
 	return true, &idx.box
 }
@@ -0,0 +1,244 @@
+package gotrace
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// AOV is an arbitrary output variable, an auxiliary image made of the first hits of camera rays, used for debugging
+// scenes and by denoisers. Pixels whose camera rays miss the scene are 0.
+type AOV int
+
+const (
+	// AOVNormal is the normal of the surfaces, in world space
+	AOVNormal AOV = iota
+	// AOVDepth is the distance from the camera to the surfaces
+	AOVDepth
+	// AOVPosition is the position of the surfaces, in world space
+	AOVPosition
+	// AOVUV is the texture coordinates of the surfaces
+	AOVUV
+	// AOVAlbedo is the color of the material of the surfaces, regardless of the lighting
+	AOVAlbedo
+	// AOVActorID is the ID of the actors, see NewScene. Unlike other AOVs, it isn't averaged over the samples of a pixel.
+	AOVActorID
+)
+
+var aovNames = map[AOV]string{
+	AOVNormal:   "normal",
+	AOVDepth:    "depth",
+	AOVPosition: "position",
+	AOVUV:       "uv",
+	AOVAlbedo:   "albedo",
+	AOVActorID:  "id",
+}
+
+// aovChannels are the names of the channels of each AOV, as written in EXR layers
+var aovChannels = map[AOV][]string{
+	AOVNormal:   {"X", "Y", "Z"},
+	AOVDepth:    {"Z"},
+	AOVPosition: {"X", "Y", "Z"},
+	AOVUV:       {"U", "V"},
+	AOVAlbedo:   {"R", "G", "B"},
+	AOVActorID:  {"X"},
+}
+
+func (a AOV) String() string {
+	if name, ok := aovNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("AOV(%d)", int(a))
+}
+
+// ParseAOV returns the AOV of the given name
+func ParseAOV(name string) (AOV, error) {
+	for aov, aovName := range aovNames {
+		if aovName == name {
+			return aov, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown AOV %q", name)
+}
+
+// ParseAOVs returns the AOVs of a comma separated list of names
+func ParseAOVs(names string) ([]AOV, error) {
+	var aovs []AOV
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		aov, err := ParseAOV(name)
+		if err != nil {
+			return nil, err
+		}
+		aovs = append(aovs, aov)
+	}
+	return aovs, nil
+}
+
+// aovValues stores the values of the AOVs at the hit of a camera ray into values
+func (s *Scene) aovValues(ray Ray, record *HitRecord, aovs []AOV, values []Vec3) {
+	for i, aov := range aovs {
+		switch aov {
+		case AOVNormal:
+			// volumes have no normal
+			if record.Normal != (Vec3{}) {
+				values[i] = record.Normal.Unit()
+			}
+		case AOVDepth:
+			d := record.Distance * ray.Direction.Norm()
+			values[i] = Vec3{d, d, d}
+		case AOVPosition:
+			values[i] = record.Position
+		case AOVUV:
+			values[i] = Vec3{X: record.U, Y: record.V}
+		case AOVAlbedo:
+			values[i] = albedo(record.Material, record)
+		case AOVActorID:
+			id := float64(record.ActorID)
+			values[i] = Vec3{id, id, id}
+		}
+	}
+}
+
+// albedo returns the color of the material at the hit point
+func albedo(material Material, record *HitRecord) Vec3 {
+	switch m := material.(type) {
+	case Lambertian:
+		return textureValue(m.albedo, *record)
+	case Metal:
+		return m.albedo
+	case Dielectric:
+		return WHITE
+	case DiffuseLight:
+		return m.Emit(record.U, record.V, record.Position)
+	case Isotropic:
+		return textureValue(m.albedo, *record)
+	}
+	return BLACK
+}
+
+// aovBuffer holds the samples of an AOV
+type aovBuffer struct {
+	aov AOV
+	// sum of the samples of each pixel, or the first sample of each pixel for IDs
+	sum []Vec3
+}
+
+// AOVs returns the AOVs rendered into the accumulator
+func (a *Accumulator) AOVs() []AOV {
+	aovs := make([]AOV, len(a.aovs))
+	for i, buffer := range a.aovs {
+		aovs[i] = buffer.aov
+	}
+	return aovs
+}
+
+// aovBuffer returns the buffer of the AOV, or nil if it isn't rendered into the accumulator
+func (a *Accumulator) aovBuffer(aov AOV) *aovBuffer {
+	for i := range a.aovs {
+		if a.aovs[i].aov == aov {
+			return &a.aovs[i]
+		}
+	}
+	return nil
+}
+
+// AOVFramebuffer returns the values of the AOV, or nil if it isn't rendered into the accumulator
+// Values having less than three components are repeated in the missing channels.
+func (a *Accumulator) AOVFramebuffer(aov AOV) *Framebuffer {
+	buffer := a.aovBuffer(aov)
+	if buffer == nil {
+		return nil
+	}
+	fb := NewFramebuffer(a.width, a.height)
+	for k, sum := range buffer.sum {
+		if a.samples[k] > 0 && aov != AOVActorID {
+			sum = sum.Div(float64(a.samples[k]))
+		}
+		fb.SetPixel(k%a.width, k/a.width, sum)
+	}
+	return fb
+}
+
+/*
+AOVImage returns an 8-bit image showing the AOV, or nil if it isn't rendered into the accumulator.
+
+Normals are mapped from [-1, 1] to [0, 1], depths and positions are scaled to the range of values of the image, texture
+coordinates are shown in the red and green channels, albedos are encoded as rendered images, and IDs are given
+random colors.
+*/
+func (a *Accumulator) AOVImage(aov AOV) *image.RGBA {
+	fb := a.AOVFramebuffer(aov)
+	if fb == nil {
+		return nil
+	}
+	min, max := Vec3{X: math.Inf(1), Y: math.Inf(1), Z: math.Inf(1)}, Vec3{X: math.Inf(-1), Y: math.Inf(-1), Z: math.Inf(-1)}
+	for y := 0; y < fb.Height; y++ {
+		for x := 0; x < fb.Width; x++ {
+			min, max = MinCoord(min, fb.Pixel(x, y)), MaxCoord(max, fb.Pixel(x, y))
+		}
+	}
+	// ranges of a single value are shown as black
+	extent := max.Sub(min)
+	extent = MaxCoord(extent, Vec3{math.SmallestNonzeroFloat64, math.SmallestNonzeroFloat64, math.SmallestNonzeroFloat64})
+
+	img := image.NewRGBA(image.Rect(0, 0, fb.Width, fb.Height))
+	for y := 0; y < fb.Height; y++ {
+		for x := 0; x < fb.Width; x++ {
+			v := fb.Pixel(x, y)
+			switch aov {
+			case AOVNormal:
+				img.Set(x, y, linearColor(v.Add(WHITE).Scale(0.5)))
+			case AOVDepth:
+				d := v.X / math.Max(max.X, math.SmallestNonzeroFloat64)
+				img.Set(x, y, linearColor(Vec3{d, d, d}))
+			case AOVPosition:
+				p := v.Sub(min)
+				img.Set(x, y, linearColor(Vec3{p.X / extent.X, p.Y / extent.Y, p.Z / extent.Z}))
+			case AOVUV:
+				img.Set(x, y, linearColor(v))
+			case AOVAlbedo:
+				img.SetRGBA(x, y, toneMap(SRGB{}, v))
+			case AOVActorID:
+				img.Set(x, y, idColor(int(v.X)))
+			}
+		}
+	}
+	return img
+}
+
+// linearColor returns the 8-bit color of a vector of [0, 1] components, without gamma correction
+func linearColor(v Vec3) color.RGBA {
+	c := func(x float64) uint8 {
+		return uint8(math.Min(math.Max(x, 0), 1) * 255)
+	}
+	return color.RGBA{c(v.X), c(v.Y), c(v.Z), 255}
+}
+
+// idColor returns a random color for each ID, the ID 0 meaning no actor being black
+func idColor(id int) color.RGBA {
+	if id == 0 {
+		return color.RGBA{A: 255}
+	}
+	h := uint64(mixSeed(int64(id)))
+	return color.RGBA{uint8(h), uint8(h >> 8), uint8(h >> 16), 255}
+}
+
+// EXRLayers returns the layers of the accumulator, the rendered image being the unnamed layer followed by the AOVs
+func (a *Accumulator) EXRLayers() []EXRLayer {
+	layers := []EXRLayer{RGBLayer("", a.Framebuffer())}
+	for _, buffer := range a.aovs {
+		fb := a.AOVFramebuffer(buffer.aov)
+		channels := aovChannels[buffer.aov]
+		pix := make([]float32, 0, len(channels)*a.width*a.height)
+		for k := 0; k < len(fb.Pix); k += 3 {
+			pix = append(pix, fb.Pix[k:k+len(channels)]...)
+		}
+		layers = append(layers, EXRLayer{Name: buffer.aov.String(), Channels: channels, Pix: pix})
+	}
+	return layers
+}
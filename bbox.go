@@ -49,3 +49,9 @@ func (b Bbox) Merge(o Bbox) Bbox {
 	}
 	return Bbox{small, big}
 }
+
+// SurfaceArea returns the area of the faces of the bounding box
+func (b Bbox) SurfaceArea() float64 {
+	d := b.Max.Sub(b.Min)
+	return 2 * (d.X*d.Y + d.Y*d.Z + d.Z*d.X)
+}
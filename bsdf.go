@@ -0,0 +1,280 @@
+package gotrace
+
+import "math"
+
+// BSDFFlags describe the lobe of a BSDF from which a direction was sampled
+type BSDFFlags int
+
+// Lobes of the BSDFs, a zero value meaning that the light is absorbed
+const (
+	// Reflection lobes scatter light on the side of the surface it comes from
+	Reflection BSDFFlags = 1 << iota
+	// Transmission lobes scatter light through the surface
+	Transmission
+	// Diffuse lobes scatter light in all directions
+	Diffuse
+	// Glossy lobes scatter light around a preferred direction
+	Glossy
+	// Specular lobes scatter light in a single direction, which is a delta distribution without a density
+	Specular
+)
+
+// IsSpecular returns true if the lobe scatters light in a single direction
+func (f BSDFFlags) IsSpecular() bool {
+	return f&Specular != 0
+}
+
+/*
+BSDF is the bidirectional scattering distribution function of a material at a hit point, which describes how the light
+coming from the direction wi is scattered towards the direction wo. Directions are unit vectors pointing away from the
+hit, wo being the opposite of the direction of the incident ray.
+
+Eval returns the BSDF times the cosine of wi with the normal, which is the attenuation of the light coming from wi.
+Pdf returns the density of Sample choosing wi, per unit solid angle. Both are 0 for specular lobes.
+
+Sample chooses wi from the random numbers drawn from u, and returns the value of Eval and the density of wi, so that
+the scattered light is attenuated by f / pdf. For specular lobes, pdf is the probability of choosing the lobe, and
+f / pdf is still the attenuation. Zero flags mean that the light is absorbed.
+*/
+type BSDF interface {
+	Eval(wo, wi Vec3) Vec3
+	Sample(wo Vec3, u Sampler) (wi Vec3, f Vec3, pdf float64, flags BSDFFlags)
+	Pdf(wo, wi Vec3) float64
+}
+
+// BSDFMaterial is a material whose BSDF is known, which lets the renderer sample lights from its surface
+type BSDFMaterial interface {
+	Material
+	// BSDF returns the BSDF of the material at the hit, nil meaning that the material doesn't scatter light
+	BSDF(hit HitRecord) BSDF
+}
+
+// materialBSDF returns the BSDF of the material hit by a ray, materials only implementing Scatter being adapted
+func materialBSDF(ray Ray, hit HitRecord) BSDF {
+	if material, ok := hit.Material.(BSDFMaterial); ok {
+		return material.BSDF(hit)
+	}
+	return scatterBSDF{hit, ray.Time}
+}
+
+// bsdfStorage holds the BSDFs of the materials of the package, which are built into it rather than allocated at each hit
+type bsdfStorage struct {
+	lambertian lambertianBSDF
+	metal      metalBSDF
+	dielectric dielectricBSDF
+	isotropic  isotropicBSDF
+}
+
+// bsdf returns the BSDF of the material hit by a ray as materialBSDF does. The BSDFs of the materials of the package are
+// stored into s, so they are only valid until the next call.
+func (s *bsdfStorage) bsdf(ray Ray, hit *HitRecord) BSDF {
+	switch m := hit.Material.(type) {
+	case Lambertian:
+		s.lambertian = m.bsdf(*hit)
+		return &s.lambertian
+	case Metal:
+		s.metal = m.bsdf(*hit)
+		return &s.metal
+	case Dielectric:
+		s.dielectric = m.bsdf(*hit)
+		return &s.dielectric
+	case Isotropic:
+		s.isotropic = m.bsdf(*hit)
+		return &s.isotropic
+	case DiffuseLight:
+		return nil
+	}
+	return materialBSDF(ray, *hit)
+}
+
+// scatter implements Scatter for materials implementing BSDFMaterial, by sampling their BSDF
+func scatter(bsdf BSDF, ray Ray, hit HitRecord) (bool, Vec3, Ray) {
+	if bsdf == nil {
+		return false, Vec3{}, Ray{}
+	}
+	wi, f, pdf, flags := bsdf.Sample(ray.Direction.Unit().Neg(), ray.Sampler)
+	if flags == 0 || pdf == 0 {
+		return false, Vec3{}, Ray{}
+	}
+	return true, f.Scale(1 / pdf), Ray{hit.Position, wi, ray.Time, ray.Sampler}
+}
+
+/*
+scatterBSDF adapts a material only implementing Scatter to the BSDF interface. Its scattered directions are treated
+as specular, as their density isn't known, so lights are found by the scattered rays only, as they were before BSDFs.
+*/
+type scatterBSDF struct {
+	hit  HitRecord
+	time float64
+}
+
+// Eval implements the BSDF interface
+func (b scatterBSDF) Eval(wo, wi Vec3) Vec3 {
+	return BLACK
+}
+
+// Sample implements the BSDF interface, calling Scatter with the incident ray
+func (b scatterBSDF) Sample(wo Vec3, u Sampler) (Vec3, Vec3, float64, BSDFFlags) {
+	ray := Ray{b.hit.Position.Add(wo), wo.Neg(), b.time, u}
+	scatters, attenuation, scattered := b.hit.Material.Scatter(ray, b.hit)
+	if !scatters || scattered.Direction == (Vec3{}) {
+		return Vec3{}, BLACK, 0, 0
+	}
+	flags := Reflection | Specular
+	if scattered.Direction.Dot(b.hit.Normal)*wo.Dot(b.hit.Normal) < 0 {
+		flags = Transmission | Specular
+	}
+	return scattered.Direction.Unit(), attenuation, 1, flags
+}
+
+// Pdf implements the BSDF interface
+func (b scatterBSDF) Pdf(wo, wi Vec3) float64 {
+	return 0
+}
+
+// lambertianBSDF reflects light uniformly, directions being chosen with a density proportional to their cosine
+type lambertianBSDF struct {
+	normal, albedo Vec3
+}
+
+// Eval implements the BSDF interface
+func (b lambertianBSDF) Eval(wo, wi Vec3) Vec3 {
+	return b.albedo.Scale(b.Pdf(wo, wi))
+}
+
+// Sample implements the BSDF interface, choosing the direction from the normal to a random point on the unit sphere
+func (b lambertianBSDF) Sample(wo Vec3, u Sampler) (Vec3, Vec3, float64, BSDFFlags) {
+	direction := b.normal.Add(SampleSphere(u.Get2D()))
+	if direction == (Vec3{}) {
+		return Vec3{}, BLACK, 0, 0
+	}
+	wi := direction.Unit()
+	pdf := b.Pdf(wo, wi)
+	return wi, b.albedo.Scale(pdf), pdf, Reflection | Diffuse
+}
+
+// Pdf implements the BSDF interface
+func (b lambertianBSDF) Pdf(wo, wi Vec3) float64 {
+	return math.Max(0, wi.Dot(b.normal)) / math.Pi
+}
+
+// metalBSDF reflects light around the mirror direction, fuzzed by a random point in a sphere of radius fuzz
+type metalBSDF struct {
+	normal, albedo Vec3
+	fuzz           float64
+}
+
+// Eval implements the BSDF interface, directions going under the surface being absorbed
+func (b metalBSDF) Eval(wo, wi Vec3) Vec3 {
+	if wi.Dot(b.normal) <= 0 {
+		return BLACK
+	}
+	return b.albedo.Scale(b.Pdf(wo, wi))
+}
+
+// Sample implements the BSDF interface
+func (b metalBSDF) Sample(wo Vec3, u Sampler) (Vec3, Vec3, float64, BSDFFlags) {
+	reflected := wo.Neg().Reflect(b.normal)
+	direction := reflected.Add(SampleSphere(u.Get2D()).Scale(b.fuzz))
+	if direction.Dot(b.normal) <= 0 {
+		return Vec3{}, BLACK, 0, 0
+	}
+	if b.fuzz == 0 {
+		return reflected, b.albedo, 1, Reflection | Specular
+	}
+	wi := direction.Unit()
+	pdf := b.Pdf(wo, wi)
+	return wi, b.albedo.Scale(pdf), pdf, Reflection | Glossy
+}
+
+/*
+Pdf implements the BSDF interface. It is the density of the directions of the points of the sphere of radius fuzz
+centered on the reflected direction, which are chosen uniformly by Sample. A direction going through the sphere at
+distances t1 and t2 has a density of (t1² + t2²) / (4π * fuzz * sqrt(Δ)), where Δ is the discriminant of the
+intersection, the roots behind the hit not counting.
+*/
+func (b metalBSDF) Pdf(wo, wi Vec3) float64 {
+	if b.fuzz == 0 {
+		return 0
+	}
+	c := wi.Dot(wo.Neg().Reflect(b.normal))
+	discriminant := c*c - 1 + b.fuzz*b.fuzz
+	if discriminant <= 0 {
+		return 0
+	}
+	root := math.Sqrt(discriminant)
+	t2 := 0.0
+	for _, t := range []float64{c - root, c + root} {
+		if t > 0 {
+			t2 += t * t
+		}
+	}
+	return t2 / (4 * math.Pi * b.fuzz * root)
+}
+
+// dielectricBSDF reflects or refracts light in a single direction, the probability of reflection being given by Schlick's approximation
+type dielectricBSDF struct {
+	normal Vec3
+	n      float64
+}
+
+// Eval implements the BSDF interface
+func (b dielectricBSDF) Eval(wo, wi Vec3) Vec3 {
+	return BLACK
+}
+
+// Sample implements the BSDF interface, choosing between the reflection and the refraction of the incident ray
+func (b dielectricBSDF) Sample(wo Vec3, u Sampler) (Vec3, Vec3, float64, BSDFFlags) {
+	var (
+		outNormal Vec3
+		nRatio    float64
+		cosTheta  float64
+	)
+	incidentDirection := wo.Neg()
+	dot := incidentDirection.Dot(b.normal)
+	if dot > 0 {
+		// ray escapes the material
+		outNormal = b.normal.Neg()
+		nRatio = b.n
+		cosTheta = math.Sqrt(1.0 - b.n*b.n*(1.0-dot*dot))
+	} else {
+		// ray enters the material
+		outNormal = b.normal
+		nRatio = 1.0 / b.n
+		cosTheta = -dot
+	}
+	wasRefracted, refracted := incidentDirection.Refract(outNormal, nRatio)
+	if wasRefracted && u.Get1D() >= shlick(cosTheta, nRatio) {
+		// refraction possible + shlick probability
+		return refracted.Unit(), WHITE, 1, Transmission | Specular
+	}
+	// reflection
+	return incidentDirection.Reflect(outNormal), WHITE, 1, Reflection | Specular
+}
+
+// Pdf implements the BSDF interface
+func (b dielectricBSDF) Pdf(wo, wi Vec3) float64 {
+	return 0
+}
+
+// isotropicBSDF is the phase function of isotropic media, scattering light uniformly in all directions
+type isotropicBSDF struct {
+	albedo Vec3
+}
+
+// Eval implements the BSDF interface
+func (b isotropicBSDF) Eval(wo, wi Vec3) Vec3 {
+	return b.albedo.Scale(b.Pdf(wo, wi))
+}
+
+// Sample implements the BSDF interface
+func (b isotropicBSDF) Sample(wo Vec3, u Sampler) (Vec3, Vec3, float64, BSDFFlags) {
+	wi := SampleSphere(u.Get2D())
+	pdf := b.Pdf(wo, wi)
+	return wi, b.albedo.Scale(pdf), pdf, Reflection | Transmission | Diffuse
+}
+
+// Pdf implements the BSDF interface
+func (b isotropicBSDF) Pdf(wo, wi Vec3) float64 {
+	return 1 / (4 * math.Pi)
+}
@@ -0,0 +1,334 @@
+package gotrace
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// IndexStrategy is the way a bounding volume hierarchy splits the actors of its nodes between their two children
+type IndexStrategy int
+
+const (
+	// SAHIndex splits actors where the surface area heuristic estimates that rays are the quickest to trace, among the
+	// boundaries of bins of their centers. Its hierarchies are the fastest to hit.
+	SAHIndex IndexStrategy = iota
+	// MedianIndex splits actors in two halves, along the axis where their centers are the most spread
+	MedianIndex
+	// HLBVHIndex sorts actors along the Morton curve of their centers, splits them in clusters of nearby actors by the bits
+	// of their Morton codes, and joins the clusters by the surface area heuristic. Its hierarchies are the fastest to build.
+	HLBVHIndex
+)
+
+var indexStrategyNames = map[IndexStrategy]string{
+	SAHIndex:    "sah",
+	MedianIndex: "median",
+	HLBVHIndex:  "hlbvh",
+}
+
+func (s IndexStrategy) String() string {
+	if name, ok := indexStrategyNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("IndexStrategy(%d)", int(s))
+}
+
+// ParseIndexStrategy returns the index strategy of the given name
+func ParseIndexStrategy(name string) (IndexStrategy, error) {
+	for strategy, strategyName := range indexStrategyNames {
+		if strategyName == name {
+			return strategy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown index strategy %q, expected sah, median or hlbvh", name)
+}
+
+const (
+	// maxLeafActors is the largest number of actors in the leaves of the hierarchies
+	maxLeafActors = 4
+	// sahBins is the number of bins of the centers of the actors, whose boundaries are the splits evaluated by SAHIndex
+	sahBins = 12
+	// sahTraversalCost is the cost of hitting the bounding box of a node, relative to the cost of hitting an actor
+	sahTraversalCost = 0.125
+	// mortonClusterBits are the most significant bits of the Morton codes shared by the actors of a cluster of HLBVHIndex
+	mortonClusterBits = 12
+)
+
+/*
+BuildIndex builds a bounding volume hierarchy of the actors of the world with the given strategy, the bounding boxes of
+moving actors covering their motion from startTime to endTime. The hierarchy only depends on the actors and their order,
+so that scenes are indexed the same way each time.
+*/
+func BuildIndex(world Collection, startTime, endTime float64, strategy IndexStrategy) *Index {
+	var (
+		items     []indexItem
+		unbounded Collection
+	)
+	for _, actor := range world {
+		bounded, box := actor.Bound(startTime, endTime)
+		if !bounded {
+			unbounded = append(unbounded, actor)
+			continue
+		}
+		items = append(items, newIndexItem(actor, *box))
+	}
+	if len(items) == 0 {
+		return &Index{unbounded: unbounded}
+	}
+
+	var root Geometry
+	switch strategy {
+	case MedianIndex:
+		root = buildMedian(items)
+	case HLBVHIndex:
+		root = buildHLBVH(items)
+	default:
+		root = buildSAH(items, maxLeafActors)
+	}
+	idx, ok := root.(*Index)
+	if !ok {
+		// a single leaf
+		box, _ := itemBounds(items)
+		idx = &Index{box: box, left: root}
+	}
+	idx.unbounded = unbounded
+	return idx
+}
+
+// indexItem is an actor, or a subtree, of a hierarchy being built
+type indexItem struct {
+	shape    Geometry
+	box      Bbox
+	centroid Vec3
+}
+
+func newIndexItem(shape Geometry, box Bbox) indexItem {
+	return indexItem{shape, box, box.Min.Add(box.Max).Scale(0.5)}
+}
+
+// itemBounds returns the bounding box of the items, and the bounding box of their centers
+func itemBounds(items []indexItem) (Bbox, Bbox) {
+	box := items[0].box
+	centroids := Bbox{items[0].centroid, items[0].centroid}
+	for _, item := range items[1:] {
+		box = box.Merge(item.box)
+		centroids.Min = MinCoord(centroids.Min, item.centroid)
+		centroids.Max = MaxCoord(centroids.Max, item.centroid)
+	}
+	return box, centroids
+}
+
+// newLeaf returns the leaf of the items, which are actors if there are several of them
+func newLeaf(items []indexItem) Geometry {
+	if len(items) == 1 {
+		return items[0].shape
+	}
+	actors := make(Collection, len(items))
+	for i, item := range items {
+		actors[i] = item.shape.(Actor)
+	}
+	return actors
+}
+
+// buildMedian splits the items in two halves until there is a single one in each leaf
+func buildMedian(items []indexItem) Geometry {
+	if len(items) == 1 {
+		return items[0].shape
+	}
+	box, centroids := itemBounds(items)
+	axis := longestAxis(centroids)
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].centroid.AsArray()[axis] < items[j].centroid.AsArray()[axis]
+	})
+	mid := len(items) / 2
+	return &Index{box: box, left: buildMedian(items[:mid]), right: buildMedian(items[mid:])}
+}
+
+/*
+buildSAH splits the items where the surface area heuristic estimates the cost of hitting the node to be the lowest, the
+probability of hitting a child being the ratio of its surface area to the area of the node. Splits are evaluated at the
+boundaries of bins of the centers of the items, along each axis. Items are kept in a leaf, if there are at most maxLeaf
+of them, when hitting them all is cheaper than splitting them.
+*/
+func buildSAH(items []indexItem, maxLeaf int) Geometry {
+	if len(items) == 1 {
+		return items[0].shape
+	}
+	box, centroids := itemBounds(items)
+	bestCost, bestAxis, bestSplit := math.Inf(1), -1, 0
+	for axis := 0; axis < 3; axis++ {
+		min, max := centroids.Min.AsArray()[axis], centroids.Max.AsArray()[axis]
+		if max <= min {
+			continue
+		}
+		var (
+			counts [sahBins]int
+			boxes  [sahBins]Bbox
+		)
+		for _, item := range items {
+			b := sahBin(item.centroid.AsArray()[axis], min, max)
+			if counts[b] == 0 {
+				boxes[b] = item.box
+			} else {
+				boxes[b] = boxes[b].Merge(item.box)
+			}
+			counts[b]++
+		}
+		// areas and counts of the items of the bins below each split, then above it
+		var (
+			belowArea  [sahBins - 1]float64
+			belowCount [sahBins - 1]int
+			below      Bbox
+			count      int
+		)
+		for split := 0; split < sahBins-1; split++ {
+			below, count = mergeBin(below, count, boxes[split], counts[split])
+			belowArea[split], belowCount[split] = below.SurfaceArea(), count
+		}
+		var above Bbox
+		count = 0
+		for split := sahBins - 2; split >= 0; split-- {
+			above, count = mergeBin(above, count, boxes[split+1], counts[split+1])
+			if belowCount[split] == 0 || count == 0 {
+				continue
+			}
+			cost := float64(belowCount[split])*belowArea[split] + float64(count)*above.SurfaceArea()
+			if cost < bestCost {
+				bestCost, bestAxis, bestSplit = cost, axis, split
+			}
+		}
+	}
+
+	if bestAxis < 0 {
+		// all centers are at the same point, so items are split in two halves
+		if len(items) <= maxLeaf {
+			return newLeaf(items)
+		}
+		mid := len(items) / 2
+		return &Index{box: box, left: buildSAH(items[:mid], maxLeaf), right: buildSAH(items[mid:], maxLeaf)}
+	}
+	if area := box.SurfaceArea(); len(items) <= maxLeaf && float64(len(items)) <= sahTraversalCost+bestCost/area {
+		return newLeaf(items)
+	}
+
+	// items of the bins below the split are moved to the start
+	min, max := centroids.Min.AsArray()[bestAxis], centroids.Max.AsArray()[bestAxis]
+	mid := 0
+	for i, item := range items {
+		if sahBin(item.centroid.AsArray()[bestAxis], min, max) <= bestSplit {
+			items[i], items[mid] = items[mid], items[i]
+			mid++
+		}
+	}
+	return &Index{box: box, left: buildSAH(items[:mid], maxLeaf), right: buildSAH(items[mid:], maxLeaf)}
+}
+
+// sahBin returns the bin of a coordinate of a center, between the min and max coordinates of the centers
+func sahBin(coord, min, max float64) int {
+	return int(math.Min(sahBins-1, sahBins*(coord-min)/(max-min)))
+}
+
+// mergeBin adds a bin of count items to the bounding box of the bins of the given count of items
+func mergeBin(box Bbox, count int, binBox Bbox, binCount int) (Bbox, int) {
+	switch {
+	case binCount == 0:
+		return box, count
+	case count == 0:
+		return binBox, binCount
+	}
+	return box.Merge(binBox), count + binCount
+}
+
+// longestAxis returns the axis along which the box is the longest
+func longestAxis(box Bbox) int {
+	size := box.Max.Sub(box.Min)
+	switch {
+	case size.X >= size.Y && size.X >= size.Z:
+		return 0
+	case size.Y >= size.Z:
+		return 1
+	}
+	return 2
+}
+
+/*
+buildHLBVH sorts the items by the Morton codes of their centers, which interleave the bits of their coordinates in the
+bounding box of the centers, so that nearby items have close codes. Items sharing the first bits of their codes form
+clusters, whose hierarchies split items by the next bits of their codes, and the clusters are joined by buildSAH.
+*/
+func buildHLBVH(items []indexItem) Geometry {
+	_, centroids := itemBounds(items)
+	size := centroids.Max.Sub(centroids.Min)
+	codes := make([]uint32, len(items))
+	order := make([]int, len(items))
+	for i, item := range items {
+		p := item.centroid.Sub(centroids.Min)
+		codes[i] = mortonCode(mortonCoord(p.X, size.X), mortonCoord(p.Y, size.Y), mortonCoord(p.Z, size.Z))
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return codes[order[i]] < codes[order[j]]
+	})
+	sortedItems := make([]indexItem, len(items))
+	sortedCodes := make([]uint32, len(items))
+	for i, o := range order {
+		sortedItems[i], sortedCodes[i] = items[o], codes[o]
+	}
+
+	var clusters []indexItem
+	clusterShift := uint(30 - mortonClusterBits)
+	for start := 0; start < len(sortedItems); {
+		end := start + 1
+		for end < len(sortedItems) && sortedCodes[end]>>clusterShift == sortedCodes[start]>>clusterShift {
+			end++
+		}
+		box, _ := itemBounds(sortedItems[start:end])
+		cluster := buildMorton(sortedItems[start:end], sortedCodes[start:end], int(clusterShift)-1)
+		clusters = append(clusters, newIndexItem(cluster, box))
+		start = end
+	}
+	// clusters aren't actors, so they can't share leaves
+	return buildSAH(clusters, 1)
+}
+
+// buildMorton splits items sorted by their Morton codes between those whose given bit is 0 and those whose bit is 1
+func buildMorton(items []indexItem, codes []uint32, bit int) Geometry {
+	if len(items) <= maxLeafActors {
+		return newLeaf(items)
+	}
+	box, _ := itemBounds(items)
+	if bit < 0 {
+		// items have the same code, so they are split in two halves
+		mid := len(items) / 2
+		return &Index{box: box, left: buildMorton(items[:mid], codes[:mid], bit), right: buildMorton(items[mid:], codes[mid:], bit)}
+	}
+	mid := sort.Search(len(codes), func(i int) bool {
+		return codes[i]>>uint(bit)&1 == 1
+	})
+	if mid == 0 || mid == len(items) {
+		return buildMorton(items, codes, bit-1)
+	}
+	return &Index{box: box, left: buildMorton(items[:mid], codes[:mid], bit-1), right: buildMorton(items[mid:], codes[mid:], bit-1)}
+}
+
+// mortonCoord quantizes a coordinate from [0, size] to 10 bits
+func mortonCoord(coord, size float64) uint32 {
+	if size <= 0 {
+		return 0
+	}
+	return uint32(math.Min(1023, coord/size*1024))
+}
+
+// mortonCode interleaves the bits of three 10-bit coordinates
+func mortonCode(x, y, z uint32) uint32 {
+	return spreadBits(x)<<2 | spreadBits(y)<<1 | spreadBits(z)
+}
+
+// spreadBits inserts two zeros between each of the 10 bits of v
+func spreadBits(v uint32) uint32 {
+	v = (v | v<<16) & 0x030000ff
+	v = (v | v<<8) & 0x0300f00f
+	v = (v | v<<4) & 0x030c30c3
+	v = (v | v<<2) & 0x09249249
+	return v
+}
@@ -0,0 +1,306 @@
+package gotrace
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// plane is the unbounded plane y = 0, which can't be part of a hierarchy
+type plane struct{}
+
+func (p plane) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	t := -ray.Origin.Y / ray.Direction.Y
+	if t <= tMin || t >= tMax {
+		return false, nil
+	}
+	return true, &HitRecord{Distance: t, Position: ray.At(t), Normal: Vec3{Y: 1}}
+}
+
+func (p plane) Bound(startTime float64, endTime float64) (bool, *Bbox) {
+	return false, nil
+}
+
+var indexStrategies = []IndexStrategy{SAHIndex, MedianIndex, HLBVHIndex}
+
+// indexActors returns the actors of the leaves of a hierarchy
+func indexActors(g Geometry) []Actor {
+	switch g := g.(type) {
+	case *Index:
+		actors := append(Collection{}, g.unbounded...)
+		if g.left != nil {
+			actors = append(actors, indexActors(g.left)...)
+		}
+		if g.right != nil {
+			actors = append(actors, indexActors(g.right)...)
+		}
+		return actors
+	case Collection:
+		return g
+	case Actor:
+		return []Actor{g}
+	}
+	panic("unexpected node of an index")
+}
+
+// randomRays returns rays going from random points of a box around the world towards random points of the world
+func randomRays(n int, box Bbox, seed int64) []Ray {
+	rnd := rand.New(rand.NewSource(seed))
+	size := box.Max.Sub(box.Min)
+	point := func(scale float64) Vec3 {
+		center := box.Min.Add(box.Max).Scale(0.5)
+		return center.Add(Vec3{rnd.Float64() - 0.5, rnd.Float64() - 0.5, rnd.Float64() - 0.5}.Mul(size).Scale(scale))
+	}
+	rays := make([]Ray, n)
+	for i := range rays {
+		origin := point(2)
+		rays[i] = Ray{Origin: origin, Direction: point(1).Sub(origin), Sampler: NewIndependentSampler(seed + int64(i))}
+	}
+	return rays
+}
+
+func TestBuildIndex(t *testing.T) {
+	world := BookScene().objects
+	_, box := world.Bound(0, 1)
+	rays := randomRays(1000, *box, 1)
+	for _, strategy := range indexStrategies {
+		t.Run(strategy.String(), func(t *testing.T) {
+			idx := BuildIndex(world, 0, 1, strategy)
+			actors := indexActors(idx)
+			ids := make([]int, len(actors))
+			for i, actor := range actors {
+				ids[i] = actor.id
+			}
+			sort.Ints(ids)
+			for i, id := range ids {
+				if id != i+1 {
+					t.Fatalf("got actor %d at position %d of the sorted actors of the index, want each actor once", id, i)
+				}
+			}
+
+			// the index finds the same hits as the collection
+			for i, ray := range rays {
+				want, wantRecord := world.Hit(ray, 0.001, math.MaxFloat64)
+				hit, record := idx.Hit(ray, 0.001, math.MaxFloat64)
+				if hit != want || hit && (record.ActorID != wantRecord.ActorID || record.Distance != wantRecord.Distance) {
+					t.Fatalf("ray %d: got hit %v %+v, want %v %+v", i, hit, record, want, wantRecord)
+				}
+			}
+
+			// hierarchies only depend on the actors
+			if again := BuildIndex(world, 0, 1, strategy); !sameIndex(idx, again) {
+				t.Error("building the index twice gave different hierarchies")
+			}
+		})
+	}
+}
+
+// sameIndex returns true if the hierarchies have the same boxes and the same actors in the same leaves
+func sameIndex(a, b Geometry) bool {
+	switch a := a.(type) {
+	case *Index:
+		b, ok := b.(*Index)
+		return ok && a.box == b.box && len(a.unbounded) == len(b.unbounded) &&
+			(a.left == nil) == (b.left == nil) && (a.left == nil || sameIndex(a.left, b.left)) &&
+			(a.right == nil) == (b.right == nil) && (a.right == nil || sameIndex(a.right, b.right))
+	case Collection:
+		b, ok := b.(Collection)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i].id != b[i].id {
+				return false
+			}
+		}
+		return true
+	case Actor:
+		b, ok := b.(Actor)
+		return ok && a.id == b.id
+	}
+	return false
+}
+
+func TestBuildIndexEmpty(t *testing.T) {
+	ray := Ray{Origin: Vec3{0, 1, 0}, Direction: Vec3{0, -1, 0}}
+	for _, strategy := range indexStrategies {
+		idx := BuildIndex(Collection{}, 0, 1, strategy)
+		if hit, _ := idx.Hit(ray, 0.001, math.MaxFloat64); hit {
+			t.Errorf("%s: the empty index was hit", strategy)
+		}
+		if bounded, _ := idx.Bound(0, 1); bounded {
+			t.Errorf("%s: the empty index has a bounding box", strategy)
+		}
+		flat := NewFlatIndex(Collection{}, 0, 1, strategy)
+		if hit, _ := flat.Hit(ray, 0.001, math.MaxFloat64); hit {
+			t.Errorf("%s: the empty flat index was hit", strategy)
+		}
+	}
+}
+
+func TestBuildIndexUnbounded(t *testing.T) {
+	sphere := Actor{shape: Sphere{Center: Vec3{0, 1, 0}, Radius: 0.5}, id: 2}
+	tests := []struct {
+		name  string
+		world Collection
+		// hits of rays going down from y = 2 at x = 0 and x = 2
+		want [2]int
+	}{
+		{"unbounded only", Collection{{shape: plane{}, id: 1}}, [2]int{1, 1}},
+		{"unbounded and bounded", Collection{{shape: plane{}, id: 1}, sphere}, [2]int{2, 1}},
+	}
+	for _, test := range tests {
+		for _, strategy := range indexStrategies {
+			idx := BuildIndex(test.world, 0, 1, strategy)
+			if bounded, _ := idx.Bound(0, 1); bounded {
+				t.Errorf("%s, %s: the index has a bounding box", test.name, strategy)
+			}
+			flat := NewFlatIndex(test.world, 0, 1, strategy)
+			for i, x := range []float64{0, 2} {
+				ray := Ray{Origin: Vec3{x, 2, 0}, Direction: Vec3{0, -1, 0}}
+				for name, index := range map[string]Geometry{"index": idx, "flat index": flat} {
+					if hit, record := index.Hit(ray, 0.001, math.MaxFloat64); !hit || record.ActorID != test.want[i] {
+						t.Errorf("%s, %s: the %s hit %v, want actor %d", test.name, strategy, name, record, test.want[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestBuildIndexSameCentroids(t *testing.T) {
+	// nested spheres, which can't be split by their centers
+	var world Collection
+	for i := 1; i <= 50; i++ {
+		world.Add(Actor{shape: Sphere{Radius: float64(i)}, id: i})
+	}
+	ray := Ray{Origin: Vec3{0, 0, 100}, Direction: Vec3{0, 0, -1}}
+	for _, strategy := range indexStrategies {
+		idx := BuildIndex(world, 0, 1, strategy)
+		if actors := indexActors(idx); len(actors) != len(world) {
+			t.Errorf("%s: got %d actors in the index, want %d", strategy, len(actors), len(world))
+		}
+		if hit, record := idx.Hit(ray, 0.001, math.MaxFloat64); !hit || record.ActorID != 50 || record.Distance != 50 {
+			t.Errorf("%s: got hit %+v, want the largest sphere at distance 50", strategy, record)
+		}
+	}
+}
+
+/*
+legacyIndex is the hierarchy built by NewIndex before index strategies, kept as the baseline of the benchmarks: the
+actors of each node are sorted along a random axis by the minimum of their boxes, and split at the middle, the middle
+actor being in both children. The random axes come from rnd, so that benchmarks are reproducible.
+*/
+type legacyIndex struct {
+	box         Bbox
+	left, right Geometry
+}
+
+func newLegacyIndex(world Collection, start, end int, startTime, endTime float64, rnd *rand.Rand) *legacyIndex {
+	axis := rnd.Intn(3)
+	comparator := func(i, j int) bool {
+		_, leftBox := world[i].Bound(startTime, endTime)
+		_, rightBox := world[j].Bound(startTime, endTime)
+		return leftBox.Min.AsArray()[axis] < rightBox.Min.AsArray()[axis]
+	}
+
+	idx := legacyIndex{}
+	span := end - start + 1
+	if span == 1 {
+		idx.left = world[start]
+		idx.right = world[start]
+	} else if span == 2 {
+		if comparator(start, end) {
+			idx.left = world[start]
+			idx.right = world[start+1]
+		} else {
+			idx.left = world[start+1]
+			idx.right = world[start]
+		}
+	} else {
+		sort.Slice(world[start:end], comparator)
+		mid := start + span/2
+		idx.left = newLegacyIndex(world, start, mid, startTime, endTime, rnd)
+		idx.right = newLegacyIndex(world, mid, end, startTime, endTime, rnd)
+	}
+
+	_, leftBox := idx.left.Bound(startTime, endTime)
+	_, rightBox := idx.right.Bound(startTime, endTime)
+	idx.box = leftBox.Merge(*rightBox)
+	return &idx
+}
+
+func (idx *legacyIndex) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	if !idx.box.Hit(ray, tMin, tMax) {
+		return false, nil
+	}
+	hitLeft, recordLeft := idx.left.Hit(ray, tMin, tMax)
+	if hitLeft {
+		tMax = recordLeft.Distance
+	}
+	hitRight, recordRight := idx.right.Hit(ray, tMin, tMax)
+	if hitRight {
+		return true, recordRight
+	}
+	return hitLeft, recordLeft
+}
+
+func (idx *legacyIndex) Bound(tMin float64, tMax float64) (bool, *Bbox) {
+	return true, &idx.box
+}
+
+// benchmarkWorlds are the worlds of the index benchmarks, which have no unbounded actors for the legacy index
+var benchmarkWorlds = []struct {
+	name  string
+	scene func() *Scene
+}{
+	{"book", BookScene},
+	{"final", FinalScene},
+}
+
+func BenchmarkBuildIndex(b *testing.B) {
+	for _, world := range benchmarkWorlds {
+		objects := world.scene().objects
+		b.Run(world.name+"/legacy", func(b *testing.B) {
+			rnd := rand.New(rand.NewSource(1))
+			actors := make(Collection, len(objects))
+			for i := 0; i < b.N; i++ {
+				copy(actors, objects)
+				newLegacyIndex(actors, 0, len(actors)-1, 0, 1, rnd)
+			}
+		})
+		for _, strategy := range indexStrategies {
+			b.Run(world.name+"/"+strategy.String(), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					BuildIndex(objects, 0, 1, strategy)
+				}
+			})
+		}
+	}
+}
+
+// benchmarkHits hits the index with the rays, an operation being a ray
+func benchmarkHits(b *testing.B, index Geometry, rays []Ray) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Hit(rays[i%len(rays)], 0.001, math.MaxFloat64)
+	}
+}
+
+func BenchmarkHitIndex(b *testing.B) {
+	for _, world := range benchmarkWorlds {
+		scene := world.scene()
+		_, box := scene.objects.Bound(0, 1)
+		rays := randomRays(1<<12, *box, 1)
+		b.Run(world.name+"/legacy", func(b *testing.B) {
+			actors := append(Collection{}, scene.objects...)
+			benchmarkHits(b, newLegacyIndex(actors, 0, len(actors)-1, 0, 1, rand.New(rand.NewSource(1))), rays)
+		})
+		for _, strategy := range indexStrategies {
+			b.Run(world.name+"/"+strategy.String(), func(b *testing.B) {
+				benchmarkHits(b, BuildIndex(scene.objects, 0, 1, strategy), rays)
+			})
+		}
+	}
+}
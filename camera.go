@@ -1,9 +1,6 @@
 package gotrace
 
-import (
-	"math"
-	"math/rand"
-)
+import "math"
 
 // A Camera is the eye through which the the scene is observed
 type Camera struct {
@@ -15,6 +12,10 @@ type Camera struct {
 	lensRadius    float64
 	tStart, tStop float64
 	AspectRatio   float64
+	// parameters the camera was created with, kept for serialization
+	lookAt, up     Vec3
+	fov, focusDist float64
+	aperture       float64
 }
 
 // NewCamera creates a camera
@@ -44,20 +45,25 @@ func NewCamera(lookFrom, lookAt, up Vec3, verticalFOV, aspectRatio, aperture, fo
 		tStart:      tStart,
 		tStop:       tStop,
 		AspectRatio: aspectRatio,
+		lookAt:      lookAt,
+		up:          up,
+		fov:         verticalFOV,
+		focusDist:   focusDist,
+		aperture:    aperture,
 	}
 }
 
 // RayTo casts a Ray from the camera to the given (u, v) coordinates
 // the Ray is cast at a random time during the camera lens' opening
-func (c Camera) RayTo(s float64, t float64, rnd *rand.Rand) Ray {
-	rd := RandDisk(rnd).Scale(c.lensRadius)
+func (c Camera) RayTo(s float64, t float64, sampler Sampler) Ray {
+	rd := SampleDisk(sampler.Get2D()).Scale(c.lensRadius)
 	offset := c.u.Scale(rd.X).Add(c.v.Scale(rd.Y))
 	hOffset := c.horizontal.Scale(s)
 	vOffset := c.vertical.Scale(t)
 	return Ray{
-		Origin:     c.origin.Add(offset),
-		Direction:  c.corner.Add(hOffset).Add(vOffset).Sub(c.origin).Sub(offset),
-		Time:       rnd.Float64()*(c.tStop-c.tStart) + c.tStart,
-		RandSource: rnd,
+		Origin:    c.origin.Add(offset),
+		Direction: c.corner.Add(hOffset).Add(vOffset).Sub(c.origin).Sub(offset),
+		Time:      sampler.Get1D()*(c.tStop-c.tStart) + c.tStart,
+		Sampler:   sampler,
 	}
 }
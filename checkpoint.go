@@ -0,0 +1,338 @@
+package gotrace
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// checkpointMagic starts checkpoint files, the last byte being the version of the format
+const checkpointMagic = "gotrace-checkpoint\x05"
+
+/*
+Checkpoint is the saved state of a progressive rendering, from which the rendering can be resumed by another process.
+
+Besides the accumulated samples, it records what the samples depend on: the scene, the seed of the random sources, the
+maximum number of bounces, the sampler and the reconstruction filter. A rendering can only be resumed with the same scene
+and options.
+*/
+type Checkpoint struct {
+	// SceneHash identifies the rendered scene, see Scene.Hash
+	SceneHash string
+	// Seed and MaxScatter are the options of the rendering
+	Seed       int64
+	MaxScatter int
+	// Sampler and Filter describe the sampler and the filter of the rendering
+	Sampler, Filter string
+	// Acc holds the samples rendered so far
+	Acc *Accumulator
+}
+
+// Hash returns a hash of the description of the scene, which changes when anything in the scene changes
+func (s *Scene) Hash() (string, error) {
+	data, err := MarshalScene(s)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// NewCheckpoint creates the checkpoint of a rendering of the scene into acc
+func (s *Scene) NewCheckpoint(acc *Accumulator, opts RenderOptions) (*Checkpoint, error) {
+	hash, err := s.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("scene can't be checkpointed: %v", err)
+	}
+	opts, err = s.withDefaults(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Checkpoint{
+		SceneHash:  hash,
+		Seed:       opts.Seed,
+		MaxScatter: opts.MaxScatter,
+		Sampler:    describeSampler(opts.Sampler),
+		Filter:     describeFilter(opts.Filter),
+		Acc:        acc,
+	}, nil
+}
+
+// describeSampler returns the name of the samplers of the package, and the type of other samplers
+func describeSampler(s Sampler) string {
+	switch s.(type) {
+	case *IndependentSampler:
+		return "independent"
+	case *StratifiedSampler:
+		return "stratified"
+	case *HaltonSampler:
+		return "halton"
+	case *SobolSampler:
+		return "sobol"
+	case *BlueNoiseSampler:
+		return "bluenoise"
+	}
+	return fmt.Sprintf("%T", s)
+}
+
+// describeFilter returns the type and the parameters of a filter, such as gotrace.BoxFilter{R:0.5}
+func describeFilter(f Filter) string {
+	return fmt.Sprintf("%T%+v", f, f)
+}
+
+/*
+Resume continues the rendering saved in the checkpoint, with RenderProgressive.
+
+It fails without rendering if the scene, the image size, the maximum number of bounces, the sampler or the filter differ
+from the ones of the checkpoint, or if the options have AOVs which the checkpoint doesn't render. A zero width or height in
+the options means that of the checkpoint, nil AOVs mean those of the checkpoint, and the seed of the checkpoint is always
+used.
+*/
+func (s *Scene) Resume(ctx context.Context, c *Checkpoint, opts RenderOptions) error {
+	hash, err := s.Hash()
+	if err != nil {
+		return err
+	}
+	if hash != c.SceneHash {
+		return errors.New("the scene changed since the checkpoint")
+	}
+	width, height := c.Acc.Size()
+	if opts.Width == 0 {
+		opts.Width = width
+	}
+	if opts.Height == 0 {
+		opts.Height = height
+	}
+	if opts.Width != width || opts.Height != height {
+		return fmt.Errorf("the checkpoint is a %dx%d image, not %dx%d", width, height, opts.Width, opts.Height)
+	}
+	if opts.MaxScatter == 0 {
+		opts.MaxScatter = c.MaxScatter
+	}
+	if opts.MaxScatter != c.MaxScatter {
+		return fmt.Errorf("the checkpoint was rendered with %d bounces, not %d", c.MaxScatter, opts.MaxScatter)
+	}
+	opts.Seed = c.Seed
+	// the default sampler and filter are the ones of the rendering
+	defaults, err := s.withDefaults(opts)
+	if err != nil {
+		return err
+	}
+	if sampler := describeSampler(defaults.Sampler); sampler != c.Sampler {
+		return fmt.Errorf("the checkpoint was rendered with the %s sampler, not %s", c.Sampler, sampler)
+	}
+	if filter := describeFilter(defaults.Filter); filter != c.Filter {
+		return fmt.Errorf("the checkpoint was rendered with the filter %s, not %s", c.Filter, filter)
+	}
+	// the AOVs of the accumulator can't change, as their previous samples are missing
+	for _, aov := range opts.AOVs {
+		if c.Acc.aovBuffer(aov) == nil {
+			return fmt.Errorf("the checkpoint has no %s AOV, only %v", aov, c.Acc.AOVs())
+		}
+	}
+	return s.RenderProgressive(ctx, c.Acc, opts)
+}
+
+// checkpointHeader is the fixed size part of a checkpoint file, which is followed by the samples of the pixels
+type checkpointHeader struct {
+	SceneHash     [sha256.Size]byte
+	Seed          int64
+	MaxScatter    int64
+	Width, Height int64
+	Passes        int64
+	// number of AOVs, whose kind and sums follow the pixels
+	AOVs int64
+	// lengths of the descriptions of the sampler and the filter, which follow the header
+	SamplerLength, FilterLength int64
+}
+
+// maxDescriptionLength is the largest length of the descriptions of the sampler and the filter of a checkpoint
+const maxDescriptionLength = 1 << 10
+
+// pixelSize is the size of a pixel in a checkpoint file, its number of samples and the sum of their weights followed by
+// their weighted sum, and the sums of their luminances and of their squares. AOVs only store the sums of their pixels.
+const pixelSize = 7 * 8
+
+// putVec writes the components of a vector in 24 bytes
+func putVec(b []byte, v Vec3) {
+	binary.LittleEndian.PutUint64(b[0:], math.Float64bits(v.X))
+	binary.LittleEndian.PutUint64(b[8:], math.Float64bits(v.Y))
+	binary.LittleEndian.PutUint64(b[16:], math.Float64bits(v.Z))
+}
+
+// getVec reads a vector written by putVec
+func getVec(b []byte) Vec3 {
+	return Vec3{
+		X: math.Float64frombits(binary.LittleEndian.Uint64(b[0:])),
+		Y: math.Float64frombits(binary.LittleEndian.Uint64(b[8:])),
+		Z: math.Float64frombits(binary.LittleEndian.Uint64(b[16:])),
+	}
+}
+
+// WriteCheckpoint writes the checkpoint in a binary format, which can be read back by ReadCheckpoint
+func WriteCheckpoint(w io.Writer, c *Checkpoint) error {
+	hash, err := hex.DecodeString(c.SceneHash)
+	if err != nil || len(hash) != sha256.Size {
+		return fmt.Errorf("invalid scene hash %q", c.SceneHash)
+	}
+	if len(c.Sampler) > maxDescriptionLength || len(c.Filter) > maxDescriptionLength {
+		return errors.New("descriptions of the sampler and the filter are too long")
+	}
+	header := checkpointHeader{
+		Seed:          c.Seed,
+		MaxScatter:    int64(c.MaxScatter),
+		Width:         int64(c.Acc.width),
+		Height:        int64(c.Acc.height),
+		Passes:        int64(c.Acc.passes),
+		AOVs:          int64(len(c.Acc.aovs)),
+		SamplerLength: int64(len(c.Sampler)),
+		FilterLength:  int64(len(c.Filter)),
+	}
+	copy(header.SceneHash[:], hash)
+
+	bw := bufio.NewWriter(w)
+	if _, err := io.WriteString(bw, checkpointMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(bw, c.Sampler+c.Filter); err != nil {
+		return err
+	}
+	var pixel [pixelSize]byte
+	for k, sum := range c.Acc.sum {
+		binary.LittleEndian.PutUint64(pixel[0:], uint64(c.Acc.samples[k]))
+		binary.LittleEndian.PutUint64(pixel[8:], math.Float64bits(c.Acc.weight[k]))
+		putVec(pixel[16:], sum)
+		binary.LittleEndian.PutUint64(pixel[40:], math.Float64bits(c.Acc.lum[k]))
+		binary.LittleEndian.PutUint64(pixel[48:], math.Float64bits(c.Acc.lumSquared[k]))
+		if _, err := bw.Write(pixel[:]); err != nil {
+			return err
+		}
+	}
+	for _, buffer := range c.Acc.aovs {
+		if err := binary.Write(bw, binary.LittleEndian, int64(buffer.aov)); err != nil {
+			return err
+		}
+		for _, sum := range buffer.sum {
+			putVec(pixel[:], sum)
+			if _, err := bw.Write(pixel[:24]); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadCheckpoint reads a checkpoint written by WriteCheckpoint
+func ReadCheckpoint(r io.Reader) (*Checkpoint, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(checkpointMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != checkpointMagic {
+		return nil, errors.New("not a checkpoint file")
+	}
+	var header checkpointHeader
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("truncated checkpoint: %v", err)
+	}
+	if header.Width <= 0 || header.Height <= 0 || header.Width*header.Height > 1<<30 {
+		return nil, fmt.Errorf("invalid checkpoint image size %dx%d", header.Width, header.Height)
+	}
+
+	if header.AOVs < 0 || header.AOVs > int64(len(aovNames)) {
+		return nil, fmt.Errorf("invalid number of AOVs %d", header.AOVs)
+	}
+	if header.SamplerLength < 0 || header.SamplerLength > maxDescriptionLength || header.FilterLength < 0 || header.FilterLength > maxDescriptionLength {
+		return nil, fmt.Errorf("invalid lengths of the sampler and filter %d and %d", header.SamplerLength, header.FilterLength)
+	}
+	descriptions := make([]byte, header.SamplerLength+header.FilterLength)
+	if _, err := io.ReadFull(br, descriptions); err != nil {
+		return nil, fmt.Errorf("truncated checkpoint: %v", err)
+	}
+
+	acc := NewAccumulator(int(header.Width), int(header.Height))
+	acc.passes = int(header.Passes)
+	var pixel [pixelSize]byte
+	for k := range acc.sum {
+		if _, err := io.ReadFull(br, pixel[:]); err != nil {
+			return nil, fmt.Errorf("truncated checkpoint: %v", err)
+		}
+		acc.samples[k] = int(binary.LittleEndian.Uint64(pixel[0:]))
+		acc.weight[k] = math.Float64frombits(binary.LittleEndian.Uint64(pixel[8:]))
+		acc.sum[k] = getVec(pixel[16:])
+		acc.lum[k] = math.Float64frombits(binary.LittleEndian.Uint64(pixel[40:]))
+		acc.lumSquared[k] = math.Float64frombits(binary.LittleEndian.Uint64(pixel[48:]))
+	}
+	for i := int64(0); i < header.AOVs; i++ {
+		var aov int64
+		if err := binary.Read(br, binary.LittleEndian, &aov); err != nil {
+			return nil, fmt.Errorf("truncated checkpoint: %v", err)
+		}
+		if _, ok := aovNames[AOV(aov)]; !ok {
+			return nil, fmt.Errorf("unknown AOV %d", aov)
+		}
+		for _, buffer := range acc.aovs {
+			if buffer.aov == AOV(aov) {
+				return nil, fmt.Errorf("duplicate AOV %s", buffer.aov)
+			}
+		}
+		buffer := aovBuffer{aov: AOV(aov), sum: make([]Vec3, len(acc.sum))}
+		for k := range buffer.sum {
+			if _, err := io.ReadFull(br, pixel[:24]); err != nil {
+				return nil, fmt.Errorf("truncated checkpoint: %v", err)
+			}
+			buffer.sum[k] = getVec(pixel[:])
+		}
+		acc.aovs = append(acc.aovs, buffer)
+	}
+	return &Checkpoint{
+		SceneHash:  hex.EncodeToString(header.SceneHash[:]),
+		Seed:       header.Seed,
+		MaxScatter: int(header.MaxScatter),
+		Sampler:    string(descriptions[:header.SamplerLength]),
+		Filter:     string(descriptions[header.SamplerLength:]),
+		Acc:        acc,
+	}, nil
+}
+
+// LoadCheckpoint reads the checkpoint saved in a file
+func LoadCheckpoint(file string) (*Checkpoint, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := ReadCheckpoint(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return c, nil
+}
+
+// Save writes the checkpoint to a file, which is replaced atomically so that a crash never leaves a broken checkpoint
+func (c *Checkpoint) Save(file string) error {
+	return writeAtomic(file, func(f *os.File) error {
+		return WriteCheckpoint(f, c)
+	})
+}
+
+// SaveCheckpoints returns a PassFunc saving the checkpoint to file after a pass, when interval elapsed since the last save
+func SaveCheckpoints(file string, c *Checkpoint, interval time.Duration) PassFunc {
+	last := time.Now()
+	return func(acc *Accumulator) error {
+		if time.Since(last) < interval {
+			return nil
+		}
+		last = time.Now()
+		return c.Save(file)
+	}
+}
@@ -0,0 +1,140 @@
+package gotrace
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// checkpointOptions are the options of a small rendering of the cornell box, with AOVs, a filter and a sampler
+func checkpointOptions() RenderOptions {
+	return RenderOptions{
+		Width:       16,
+		Height:      16,
+		Samples:     4,
+		PassSamples: 2,
+		MaxScatter:  5,
+		Seed:        7,
+		AOVs:        []AOV{AOVNormal, AOVDepth},
+		Filter:      TentFilter{1},
+		Sampler:     NewHaltonSampler(7),
+	}
+}
+
+// renderCheckpoint renders the first pass of the cornell box, and returns its checkpoint
+func renderCheckpoint(t *testing.T) (*Scene, *Checkpoint) {
+	t.Helper()
+	scene := CornellBox()
+	opts := checkpointOptions()
+	opts.Samples = opts.PassSamples
+	acc := &Accumulator{}
+	if err := scene.RenderProgressive(context.Background(), acc, opts); err != nil {
+		t.Fatal(err)
+	}
+	c, err := scene.NewCheckpoint(acc, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scene, c
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	_, c := renderCheckpoint(t)
+	if c.Sampler != "halton" || c.Filter != "gotrace.TentFilter{R:1}" {
+		t.Errorf("got sampler %q and filter %q, want halton and gotrace.TentFilter{R:1}", c.Sampler, c.Filter)
+	}
+	var b bytes.Buffer
+	if err := WriteCheckpoint(&b, c); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadCheckpoint(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, c) {
+		t.Error("the checkpoint read back differs from the written one")
+	}
+
+	// truncated checkpoints are refused wherever they are cut
+	for _, size := range []int{0, 10, 100, b.Len() / 2, b.Len() - 1} {
+		if _, err := ReadCheckpoint(bytes.NewReader(b.Bytes()[:size])); err == nil {
+			t.Errorf("a checkpoint truncated to %d bytes was read", size)
+		}
+	}
+}
+
+func TestReadCheckpointAOVs(t *testing.T) {
+	_, c := renderCheckpoint(t)
+	var b bytes.Buffer
+	if err := WriteCheckpoint(&b, c); err != nil {
+		t.Fatal(err)
+	}
+	data := b.Bytes()
+	// the kind of the first AOV follows the header, the descriptions and the pixels
+	width, height := c.Acc.Size()
+	offset := len(checkpointMagic) + binary.Size(checkpointHeader{}) + len(c.Sampler) + len(c.Filter) + width*height*pixelSize
+	for _, test := range []struct {
+		aov  int64
+		want string
+	}{
+		{-1, "unknown AOV -1"},
+		{100, "unknown AOV 100"},
+		{int64(AOVDepth), "duplicate AOV depth"},
+	} {
+		broken := append([]byte{}, data...)
+		binary.LittleEndian.PutUint64(broken[offset:], uint64(test.aov))
+		if _, err := ReadCheckpoint(bytes.NewReader(broken)); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("got error %v, want %q", err, test.want)
+		}
+	}
+}
+
+func TestResumeChecks(t *testing.T) {
+	scene, c := renderCheckpoint(t)
+	tests := []struct {
+		name   string
+		change func(opts *RenderOptions)
+		want   string
+	}{
+		{"size", func(opts *RenderOptions) { opts.Width = 32 }, "16x16 image, not 32x16"},
+		{"bounces", func(opts *RenderOptions) { opts.MaxScatter = 6 }, "5 bounces, not 6"},
+		{"sampler", func(opts *RenderOptions) { opts.Sampler = NewSobolSampler(7) }, "halton sampler, not sobol"},
+		{"default sampler", func(opts *RenderOptions) { opts.Sampler = nil }, "halton sampler, not independent"},
+		{"filter", func(opts *RenderOptions) { opts.Filter = TentFilter{2} }, "filter gotrace.TentFilter{R:1}, not gotrace.TentFilter{R:2}"},
+		{"default filter", func(opts *RenderOptions) { opts.Filter = nil }, "not gotrace.BoxFilter{R:0.5}"},
+		{"AOVs", func(opts *RenderOptions) { opts.AOVs = []AOV{AOVDepth, AOVAlbedo} }, "no albedo AOV, only [normal depth]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := checkpointOptions()
+			test.change(&opts)
+			passes := c.Acc.Passes()
+			err := scene.Resume(context.Background(), c, opts)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want %q", err, test.want)
+			}
+			if c.Acc.Passes() != passes {
+				t.Error("the refused checkpoint was rendered into")
+			}
+		})
+	}
+
+	// the same options resume the rendering
+	if err := scene.Resume(context.Background(), c, checkpointOptions()); err != nil {
+		t.Fatal(err)
+	}
+	if samples := c.Acc.MinSamples(); samples != 4 {
+		t.Errorf("got %d samples per pixel after resuming, want 4", samples)
+	}
+
+	// scenes are told apart by their hashes, which don't change between calls
+	if hash, err := CornellBox().Hash(); err != nil || hash != c.SceneHash {
+		t.Errorf("got hash %s (%v) for another cornell box, want %s", hash, err, c.SceneHash)
+	}
+	if hash, _ := BookScene().Hash(); hash == c.SceneHash {
+		t.Error("the book scene has the hash of the cornell box")
+	}
+}
@@ -1,50 +1,417 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	_ "image/jpeg"
+	"image"
+	"image/jpeg"
 	"image/png"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/teobouvard/gotrace"
 )
 
 var (
-	// TODO add quality options and scene parsing from file
-	cpuProfile  = flag.String("profile", "perf", "write cpu profile to file")
-	outputImage = flag.String("output", "render.png", "output rendered image to file")
+	cpuProfile     = flag.String("profile", "", "write cpu profile to file")
+	outputImage    = flag.String("output", "render.png", "output rendered image to file")
+	format         = flag.String("format", "", "format of the output image, png, jpeg, or hdr, pfm and exr for linear radiance (default: deduced from the output file extension)")
+	exrCompression = flag.String("exr-compression", "zip", "compression of exr images, none or zip")
+	toneMapping    = flag.String("tonemap", "", "tone mapping of 8-bit images, one of srgb, reinhard, aces or hable (default: tone mapping of the scene file, or srgb)")
+	exposure       = flag.Float64("exposure", 0, "exposure of the -tonemap tone mapping, in stops")
+	white          = flag.Float64("white", 0, "radiance mapped to white by the reinhard and hable -tonemap tone mappings (default: operator's default)")
+	filterName     = flag.String("filter", "box", "pixel reconstruction filter, one of box, tent, gaussian, mitchell or lanczos")
+	filterRadius   = flag.Float64("filter-radius", 0, "radius of the filter in pixels (default: 0.5 for box, 1 for tent, 1.5 for gaussian, 2 for mitchell and 3 for lanczos)")
+	samplerName    = flag.String("sampler", "independent", "sampler of the random values of the samples, one of independent, stratified, halton, sobol or bluenoise, which should not change when resuming")
+	force          = flag.Bool("force", false, "overwrite the output files, such as the image, its aovs, the heatmap or the checkpoint, if they already exist")
+	sceneName      = flag.String("scene", "final", "name of a built-in scene, or path to a scene file")
+	width          = flag.Int("width", 0, "width of the image (default: size given by the scene file, or 400)")
+	height         = flag.Int("height", 0, "height of the image (default: deduced from the aspect ratio)")
+	samples        = flag.Int("samples", 50, "number of samples per pixel")
+	targetError    = flag.Float64("target-error", 0, "render adaptively, sampling pixels until their relative error is below this value, such as 0.01, or until they have -samples samples")
+	minSamples     = flag.Int("min-samples", 16, "number of samples of every pixel before adaptive sampling stops sampling converged pixels")
+	heatmap        = flag.String("heatmap", "", "output an image of the number of samples of each pixel to this png file")
+	maxScatter     = flag.Int("depth", 50, "maximum number of ray bounces")
+	rouletteDepth  = flag.Int("roulette-depth", 3, "number of ray bounces after which dark paths are randomly terminated by Russian roulette, which is disabled if it is at least -depth")
+	workers        = flag.Int("workers", 0, "number of rendering workers (default: number of CPUs)")
+	tileSize       = flag.Int("tile-size", 32, "side of the square tiles rendered by workers, in pixels")
+	tileOrder      = flag.String("tile-order", "spiral", "order in which tiles are rendered, one of spiral, scanline or hilbert")
+	bvh            = flag.String("bvh", "sah", "strategy building the bounding volume hierarchy of the scene, one of sah, median or hlbvh")
+	seed           = flag.Int64("seed", 0, "seed of the random sources used for rendering")
+	timeout        = flag.Duration("timeout", 0, "stop rendering after this duration and save the partially rendered image")
+	progress       = flag.String("progress", "bar", "progress reporting, one of bar, log or none")
+	passSamples    = flag.Int("pass-samples", 0, "render progressively by passes of this many samples per pixel, saving the image after each pass")
+	checkpoint     = flag.String("checkpoint", "", "periodically save the state of the rendering to this file, so that it can be resumed")
+	interval       = flag.Duration("checkpoint-interval", 5*time.Minute, "minimum duration between two checkpoints")
+	aovNames       = flag.String("aovs", "", "comma separated auxiliary images to render among normal, depth, position, uv, albedo and id, written as layers of exr images or next to the output")
+	resume         = flag.Bool("resume", false, "resume the rendering saved in the -checkpoint file, overwriting the output")
 )
 
+func usage() {
+	names := make([]string, 0, len(gotrace.Scenes))
+	for name := range gotrace.Scenes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(flag.CommandLine.Output(), "\nBuilt-in scenes: %s\n", strings.Join(names, ", "))
+}
+
+// loadScene returns the built-in scene of the given name, or reads the scene description of the file
+func loadScene(name string) (*gotrace.Scene, error) {
+	if builtin, ok := gotrace.Scenes[name]; ok {
+		return builtin(), nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s is neither a built-in scene nor a scene file", name)
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scene, err := gotrace.LoadScene(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return scene, nil
+}
+
+// reporter returns the progress reporter of the given name
+func reporter(name string) (gotrace.ProgressReporter, error) {
+	switch name {
+	case "bar":
+		return &gotrace.BarReporter{}, nil
+	case "log":
+		return &gotrace.LogReporter{Interval: 10 * time.Second}, nil
+	case "none":
+		return gotrace.NopReporter{}, nil
+	}
+	return nil, fmt.Errorf("unknown progress reporter %q", name)
+}
+
+var exrCompressions = map[string]gotrace.EXRCompression{
+	"none": gotrace.EXRNoCompression,
+	"zip":  gotrace.EXRZIPCompression,
+}
+
+// outputFormat returns the format of the output image, which is deduced from the file extension if not given
+func outputFormat(format, file string) string {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(file)), ".")
+	}
+	return format
+}
+
+// encoder returns the encoder of the rendered image in the given format, or of the AOV if it isn't nil
+// 8-bit images are mapped by the tone mapper, and EXR images hold the AOVs as layers of the rendered image.
+func encoder(format string, aov *gotrace.AOV, toneMapper gotrace.ToneMapper) (func(w io.Writer, acc *gotrace.Accumulator) error, error) {
+	// AOVs which weren't rendered into the accumulator are an error, rather than an empty image
+	ldr := func(acc *gotrace.Accumulator) (image.Image, error) {
+		if aov != nil {
+			img := acc.AOVImage(*aov)
+			if img == nil {
+				return nil, fmt.Errorf("the %s AOV wasn't rendered", *aov)
+			}
+			return img, nil
+		}
+		return acc.ToneMap(toneMapper), nil
+	}
+	hdr := func(acc *gotrace.Accumulator) (*gotrace.Framebuffer, error) {
+		if aov != nil {
+			fb := acc.AOVFramebuffer(*aov)
+			if fb == nil {
+				return nil, fmt.Errorf("the %s AOV wasn't rendered", *aov)
+			}
+			return fb, nil
+		}
+		return acc.Framebuffer(), nil
+	}
+	switch format {
+	case "png":
+		return func(w io.Writer, acc *gotrace.Accumulator) error {
+			img, err := ldr(acc)
+			if err != nil {
+				return err
+			}
+			return png.Encode(w, img)
+		}, nil
+	case "jpeg", "jpg":
+		return func(w io.Writer, acc *gotrace.Accumulator) error {
+			img, err := ldr(acc)
+			if err != nil {
+				return err
+			}
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: 95})
+		}, nil
+	case "hdr":
+		return func(w io.Writer, acc *gotrace.Accumulator) error {
+			fb, err := hdr(acc)
+			if err != nil {
+				return err
+			}
+			return gotrace.WriteHDR(w, fb)
+		}, nil
+	case "pfm":
+		return func(w io.Writer, acc *gotrace.Accumulator) error {
+			fb, err := hdr(acc)
+			if err != nil {
+				return err
+			}
+			return gotrace.WritePFM(w, fb)
+		}, nil
+	case "exr":
+		compression, ok := exrCompressions[*exrCompression]
+		if !ok {
+			return nil, fmt.Errorf("unknown EXR compression %q", *exrCompression)
+		}
+		return func(w io.Writer, acc *gotrace.Accumulator) error {
+			width, height := acc.Size()
+			return gotrace.WriteEXR(w, width, height, acc.EXRLayers(), compression)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported output format %q", format)
+}
+
+// aovFile returns the file of an AOV, which is named after the output file
+func aovFile(output string, aov gotrace.AOV) string {
+	ext := filepath.Ext(output)
+	return strings.TrimSuffix(output, ext) + "." + aov.String() + ext
+}
+
+// all returns a PassFunc calling all the given ones, until one of them fails
+func all(funcs ...gotrace.PassFunc) gotrace.PassFunc {
+	return func(acc *gotrace.Accumulator) error {
+		for _, f := range funcs {
+			if err := f(acc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 
+	outFormat := outputFormat(*format, *outputImage)
+	if _, err := encoder(outFormat, nil, nil); err != nil {
+		log.Fatal(err)
+	}
+	aovs, err := gotrace.ParseAOVs(*aovNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter, err := gotrace.NewFilter(*filterName, *filterRadius)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sampler, err := gotrace.NewSampler(*samplerName, *seed, *samples)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var toneMapper gotrace.ToneMapper
+	if *toneMapping != "" {
+		if toneMapper, err = gotrace.NewToneMapper(*toneMapping, *exposure, *white); err != nil {
+			log.Fatal(err)
+		}
+	}
+	progressReporter, err := reporter(*progress)
+	if err != nil {
+		log.Fatal(err)
+	}
+	order, err := gotrace.ParseTileOrder(*tileOrder)
+	if err != nil {
+		log.Fatal(err)
+	}
+	strategy, err := gotrace.ParseIndexStrategy(*bvh)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var saved *gotrace.Checkpoint
+	if *resume {
+		if *checkpoint == "" {
+			log.Fatal("-resume needs a -checkpoint file")
+		}
+		if saved, err = gotrace.LoadCheckpoint(*checkpoint); err != nil {
+			log.Fatal(err)
+		}
+		// the rendering continues with the seed of the checkpoint
+		sampler, _ = gotrace.NewSampler(*samplerName, saved.Seed, *samples)
+	}
+
+	// all the files written by the rendering
+	outputs := []string{*outputImage}
+	if outFormat != "exr" {
+		for _, aov := range aovs {
+			outputs = append(outputs, aovFile(*outputImage, aov))
+		}
+	}
+	for _, file := range []string{*heatmap, *checkpoint, *cpuProfile} {
+		if file != "" {
+			outputs = append(outputs, file)
+		}
+	}
+
+	// create the outputs before rendering, so that we don't render for nothing
+	// a resumed rendering overwrites the files of the previous run
+	var created []string
+	// removes the empty outputs on failure, existing outputs being kept as they were
+	discard := func() {
+		for _, file := range created {
+			os.Remove(file)
+		}
+	}
+	if !*force && !*resume {
+		for _, file := range outputs {
+			f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if err != nil {
+				discard()
+				if os.IsExist(err) {
+					log.Fatalf("%s already exists, use -force to overwrite it", file)
+				}
+				log.Fatal(err)
+			}
+			f.Close()
+			created = append(created, file)
+		}
+	}
+
+	scene, err := loadScene(*sceneName)
+	if err != nil {
+		discard()
+		log.Fatal(err)
+	}
+	if strategy != gotrace.SAHIndex {
+		scene.Reindex(strategy)
+	}
+	if toneMapper == nil {
+		toneMapper = scene.ToneMapper()
+	}
+
+	// images are written atomically, so that the outputs always hold complete images
+	encode, _ := encoder(outFormat, nil, toneMapper)
+	saves := []gotrace.PassFunc{gotrace.WriteSnapshot(*outputImage, encode)}
+	if outFormat != "exr" {
+		for i := range aovs {
+			encodeAOV, _ := encoder(outFormat, &aovs[i], toneMapper)
+			saves = append(saves, gotrace.WriteSnapshot(outputs[1+i], encodeAOV))
+		}
+	}
+	if *heatmap != "" {
+		saves = append(saves, gotrace.WriteSnapshot(*heatmap, func(w io.Writer, acc *gotrace.Accumulator) error {
+			return png.Encode(w, acc.SampleHeatmap())
+		}))
+	}
+	save := all(saves...)
+
 	// execution profiling
 	// use go tool pprof perf, and web/topX
 	if *cpuProfile != "" {
-		f, err := os.Create(*cpuProfile)
+		prof, err := os.Create(*cpuProfile)
 		if err != nil {
 			log.Fatal(err)
 		}
-		pprof.StartCPUProfile(f)
+		pprof.StartCPUProfile(prof)
 		defer pprof.StopCPUProfile()
 	}
 
-	if *outputImage != "" {
-		if _, err := os.Stat(*outputImage); os.IsExist(err) {
-			fmt.Println("Output file already exists")
-		} else {
-			f, err := os.Create(*outputImage)
-			if err != nil {
+	// an interrupt stops the rendering, and the partially rendered image is saved
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, *timeout)
+		defer stop()
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+		// a second interrupt kills the program
+		signal.Stop(interrupt)
+	}()
+
+	opts := gotrace.RenderOptions{
+		Width:      *width,
+		Height:     *height,
+		Samples:    *samples,
+		MaxScatter: *maxScatter,
+		Workers:    *workers,
+		TileSize:   *tileSize,
+		TileOrder:  order,
+		Seed:       *seed,
+		Progress:   progressReporter,
+		// without progressive rendering, all samples are rendered in a single pass
+		PassSamples: *samples,
+		AOVs:        aovs,
+		Filter:      filter,
+		Sampler:     sampler,
+		TargetError: *targetError,
+		MinSamples:  *minSamples,
+		// the roulette doesn't bias the image, so it can change when resuming
+		RouletteDepth: *rouletteDepth,
+	}
+	var onPass []gotrace.PassFunc
+	if *targetError > 0 && *passSamples <= 0 {
+		// adaptive sampling checks which pixels are converged between passes, which have the default number of samples
+		opts.PassSamples = 0
+	}
+	if *passSamples > 0 {
+		opts.PassSamples = *passSamples
+		onPass = append(onPass, save)
+	}
+
+	acc := &gotrace.Accumulator{}
+	if saved != nil {
+		acc = saved.Acc
+	}
+	if *checkpoint != "" {
+		if saved == nil {
+			if saved, err = scene.NewCheckpoint(acc, opts); err != nil {
+				discard()
 				log.Fatal(err)
 			}
-			scene := gotrace.FinalScene()
-			img := scene.Render(2000, -1, 5000, 100)
-			//img := scene.Render(300, -1, 50, 50)
-			png.Encode(f, img)
 		}
+		if *passSamples <= 0 {
+			// checkpoints are saved between passes, which have the default number of samples
+			opts.PassSamples = 0
+		}
+		onPass = append(onPass, gotrace.SaveCheckpoints(*checkpoint, saved, *interval))
 	}
+	opts.OnPass = all(onPass...)
 
+	passes := acc.Passes()
+	if *resume {
+		err = scene.Resume(ctx, saved, opts)
+	} else {
+		err = scene.RenderProgressive(ctx, acc, opts)
+	}
+	if err != nil && acc.Passes() == passes {
+		discard()
+		log.Fatal(err)
+	}
+	if err != nil {
+		log.Printf("rendering stopped (%v), pixels have at least %d samples", err, acc.MinSamples())
+	}
+	if err := save(acc); err != nil {
+		log.Fatal(err)
+	}
+	if *checkpoint != "" {
+		if err := saved.Save(*checkpoint); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
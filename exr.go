@@ -0,0 +1,209 @@
+package gotrace
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// EXRCompression is the compression of the pixels of an OpenEXR file
+type EXRCompression int
+
+const (
+	// EXRNoCompression stores the pixels as they are
+	EXRNoCompression EXRCompression = 0
+	// EXRZIPCompression compresses the pixels with zlib by blocks of 16 lines, which is lossless
+	EXRZIPCompression EXRCompression = 3
+)
+
+// EXRLayer is an image stored in some of the channels of an OpenEXR file
+type EXRLayer struct {
+	// Name of the layer, which prefixes the names of its channels. The main image of a file has no name.
+	Name string
+	// Channels are the names of the channels of the layer, such as R, G and B
+	Channels []string
+	// Pix holds the values of the channels of each pixel, line by line from top to bottom
+	Pix []float32
+}
+
+// RGBLayer returns a layer of the given name holding the R, G and B channels of the framebuffer
+func RGBLayer(name string, f *Framebuffer) EXRLayer {
+	return EXRLayer{Name: name, Channels: []string{"R", "G", "B"}, Pix: f.Pix}
+}
+
+// exrChannel is a channel of an OpenEXR file, with the layer it comes from
+type exrChannel struct {
+	name  string
+	layer EXRLayer
+	// index of the channel in the layer
+	index int
+}
+
+/*
+WriteEXR writes layers of the given size to a scanline OpenEXR file, with 32-bit float channels.
+
+The channels of a layer are named after the layer and the channel, separated by a dot, such as albedo.R, which is how
+compositors such as Nuke and Blender group channels into layers. The channels of a layer without name are named after the
+channel only, so that the first layer is seen as the main image by any viewer.
+*/
+func WriteEXR(w io.Writer, width, height int, layers []EXRLayer, compression EXRCompression) error {
+	var linesPerBlock int
+	switch compression {
+	case EXRNoCompression:
+		linesPerBlock = 1
+	case EXRZIPCompression:
+		linesPerBlock = 16
+	default:
+		return fmt.Errorf("unsupported EXR compression %d", compression)
+	}
+	if width <= 0 || height <= 0 {
+		return errors.New("empty EXR image")
+	}
+
+	var channels []exrChannel
+	names := make(map[string]bool)
+	for _, layer := range layers {
+		if len(layer.Pix) != len(layer.Channels)*width*height {
+			return fmt.Errorf("EXR layer %q doesn't have %d channels of %dx%d pixels", layer.Name, len(layer.Channels), width, height)
+		}
+		for i, channel := range layer.Channels {
+			name := channel
+			if layer.Name != "" {
+				name = layer.Name + "." + channel
+			}
+			if names[name] {
+				return fmt.Errorf("duplicate EXR channel %q", name)
+			}
+			names[name] = true
+			channels = append(channels, exrChannel{name, layer, i})
+		}
+	}
+	// channels are stored in alphabetical order
+	sort.Slice(channels, func(i, j int) bool { return channels[i].name < channels[j].name })
+
+	header := exrHeader(width, height, channels, compression)
+
+	// the file starts with the header and the offsets of the blocks, so the blocks are encoded first
+	var blocks [][]byte
+	line := make([]byte, 4*width*len(channels))
+	for y0 := 0; y0 < height; y0 += linesPerBlock {
+		var raw bytes.Buffer
+		for y := y0; y < y0+linesPerBlock && y < height; y++ {
+			// the values of a line are stored channel by channel
+			k := 0
+			for _, c := range channels {
+				stride := len(c.layer.Channels)
+				for x := 0; x < width; x++ {
+					v := c.layer.Pix[stride*(y*width+x)+c.index]
+					binary.LittleEndian.PutUint32(line[k:], math.Float32bits(v))
+					k += 4
+				}
+			}
+			raw.Write(line)
+		}
+		data := raw.Bytes()
+		if compression == EXRZIPCompression {
+			data = exrZip(data)
+		}
+		block := make([]byte, 8, 8+len(data))
+		binary.LittleEndian.PutUint32(block[0:], uint32(y0))
+		binary.LittleEndian.PutUint32(block[4:], uint32(len(data)))
+		blocks = append(blocks, append(block, data...))
+	}
+
+	offsets := make([]byte, 8*len(blocks))
+	offset := len(header) + len(offsets)
+	for i, block := range blocks {
+		binary.LittleEndian.PutUint64(offsets[8*i:], uint64(offset))
+		offset += len(block)
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(offsets); err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if _, err := w.Write(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exrHeader returns the magic number, the version and the header attributes of an OpenEXR file
+func exrHeader(width, height int, channels []exrChannel, compression EXRCompression) []byte {
+	var h bytes.Buffer
+	le := func(v interface{}) { binary.Write(&h, binary.LittleEndian, v) }
+
+	h.Write([]byte{0x76, 0x2f, 0x31, 0x01})
+	version := uint32(2)
+	for _, c := range channels {
+		if len(c.name) > 31 {
+			// long names flag
+			version |= 0x400
+		}
+	}
+	le(version)
+
+	attribute := func(name, kind string, value []byte) {
+		h.WriteString(name + "\x00" + kind + "\x00")
+		le(int32(len(value)))
+		h.Write(value)
+	}
+	var v bytes.Buffer
+	for _, c := range channels {
+		v.WriteString(c.name + "\x00")
+		// 32-bit float values, not perceptually linear, no subsampling
+		binary.Write(&v, binary.LittleEndian, []int32{2, 0, 1, 1})
+	}
+	v.WriteByte(0)
+	attribute("channels", "chlist", v.Bytes())
+	attribute("compression", "compression", []byte{byte(compression)})
+	window := make([]byte, 16)
+	binary.LittleEndian.PutUint32(window[8:], uint32(width-1))
+	binary.LittleEndian.PutUint32(window[12:], uint32(height-1))
+	attribute("dataWindow", "box2i", window)
+	attribute("displayWindow", "box2i", window)
+	// increasing y
+	attribute("lineOrder", "lineOrder", []byte{0})
+	one := make([]byte, 4)
+	binary.LittleEndian.PutUint32(one, math.Float32bits(1))
+	attribute("pixelAspectRatio", "float", one)
+	attribute("screenWindowCenter", "v2f", make([]byte, 8))
+	attribute("screenWindowWidth", "float", one)
+	h.WriteByte(0)
+	return h.Bytes()
+}
+
+// exrZip compresses the data of a block as OpenEXR ZIP compression, or returns it as it is if it is smaller
+func exrZip(data []byte) []byte {
+	// the bytes of even and odd indices are split into two halves, and each byte is replaced by its difference with
+	// the previous one, which makes the bytes of float values more compressible
+	tmp := make([]byte, len(data))
+	half := (len(data) + 1) / 2
+	for i, b := range data {
+		if i%2 == 0 {
+			tmp[i/2] = b
+		} else {
+			tmp[half+i/2] = b
+		}
+	}
+	for i := len(tmp) - 1; i > 0; i-- {
+		tmp[i] = byte(int(tmp[i]) - int(tmp[i-1]) + 128)
+	}
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(tmp)
+	zw.Close()
+	if z.Len() >= len(data) {
+		return data
+	}
+	return z.Bytes()
+}
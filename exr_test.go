@@ -0,0 +1,189 @@
+package gotrace
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testEXR is an OpenEXR file read back by readEXR
+type testEXR struct {
+	attributes    map[string][]byte
+	width, height int
+	// values of each channel, line by line
+	channels map[string][]float32
+	names    []string
+}
+
+// cString reads a null-terminated string from data, and returns it with the rest of data
+func cString(t *testing.T, data []byte) (string, []byte) {
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		t.Fatal("unterminated string")
+	}
+	return string(data[:end]), data[end+1:]
+}
+
+// exrUnzip undoes exrZip
+func exrUnzip(t *testing.T, data []byte, size int) []byte {
+	if len(data) == size {
+		return data
+	}
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(tmp); i++ {
+		tmp[i] = byte(int(tmp[i-1]) + int(tmp[i]) - 128)
+	}
+	raw := make([]byte, len(tmp))
+	half := (len(tmp) + 1) / 2
+	for i := range raw {
+		if i%2 == 0 {
+			raw[i] = tmp[i/2]
+		} else {
+			raw[i] = tmp[half+i/2]
+		}
+	}
+	return raw
+}
+
+// readEXR reads the scanline OpenEXR files of 32-bit float channels written by WriteEXR
+func readEXR(t *testing.T, file []byte) testEXR {
+	t.Helper()
+	if !bytes.HasPrefix(file, []byte{0x76, 0x2f, 0x31, 0x01}) {
+		t.Fatal("bad magic number")
+	}
+	if version := binary.LittleEndian.Uint32(file[4:]); version&0xff != 2 {
+		t.Fatalf("got version %d, want 2", version&0xff)
+	}
+	exr := testEXR{attributes: make(map[string][]byte), channels: make(map[string][]float32)}
+	data := file[8:]
+	for data[0] != 0 {
+		var name string
+		name, data = cString(t, data)
+		_, data = cString(t, data)
+		size := int(binary.LittleEndian.Uint32(data))
+		exr.attributes[name] = data[4 : 4+size]
+		data = data[4+size:]
+	}
+	data = data[1:]
+
+	window := exr.attributes["dataWindow"]
+	exr.width = int(binary.LittleEndian.Uint32(window[8:])) + 1
+	exr.height = int(binary.LittleEndian.Uint32(window[12:])) + 1
+	for list := exr.attributes["channels"]; list[0] != 0; list = list[16:] {
+		var name string
+		name, list = cString(t, list)
+		if kind := binary.LittleEndian.Uint32(list); kind != 2 {
+			t.Fatalf("got pixel type %d for channel %s, want float", kind, name)
+		}
+		exr.names = append(exr.names, name)
+	}
+
+	linesPerBlock := 1
+	if exr.attributes["compression"][0] == byte(EXRZIPCompression) {
+		linesPerBlock = 16
+	}
+	blocks := (exr.height + linesPerBlock - 1) / linesPerBlock
+	for i := 0; i < blocks; i++ {
+		block := file[binary.LittleEndian.Uint64(data[8*i:]):]
+		y0 := int(binary.LittleEndian.Uint32(block))
+		size := int(binary.LittleEndian.Uint32(block[4:]))
+		lines := linesPerBlock
+		if y0+lines > exr.height {
+			lines = exr.height - y0
+		}
+		raw := exrUnzip(t, block[8:8+size], 4*exr.width*len(exr.names)*lines)
+		for y := 0; y < lines; y++ {
+			for _, name := range exr.names {
+				for x := 0; x < exr.width; x++ {
+					exr.channels[name] = append(exr.channels[name], math.Float32frombits(binary.LittleEndian.Uint32(raw)))
+					raw = raw[4:]
+				}
+			}
+		}
+	}
+	return exr
+}
+
+func TestWriteEXR(t *testing.T) {
+	// a gradient, which the zip compression makes smaller, and a depth layer
+	width, height := 7, 20
+	beauty := NewFramebuffer(width, height)
+	depth := make([]float32, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			beauty.SetPixel(x, y, Vec3{float64(x), float64(y), 20})
+			depth[y*width+x] = float32(x * y)
+		}
+	}
+	layers := []EXRLayer{
+		RGBLayer("", beauty),
+		{Name: "depth", Channels: []string{"Z"}, Pix: depth},
+	}
+	for _, compression := range []EXRCompression{EXRNoCompression, EXRZIPCompression} {
+		var b bytes.Buffer
+		if err := WriteEXR(&b, width, height, layers, compression); err != nil {
+			t.Fatal(err)
+		}
+		exr := readEXR(t, b.Bytes())
+		if exr.width != width || exr.height != height {
+			t.Errorf("compression %d: got a %dx%d image, want %dx%d", compression, exr.width, exr.height, width, height)
+		}
+		if want := []string{"B", "G", "R", "depth.Z"}; !reflect.DeepEqual(exr.names, want) {
+			t.Errorf("compression %d: got channels %v, want %v", compression, exr.names, want)
+		}
+		for i, name := range []string{"R", "G", "B"} {
+			for k, v := range exr.channels[name] {
+				if v != beauty.Pix[3*k+i] {
+					t.Fatalf("compression %d: got %v for pixel %d of channel %s, want %v", compression, v, k, name, beauty.Pix[3*k+i])
+				}
+			}
+		}
+		if !reflect.DeepEqual(exr.channels["depth.Z"], depth) {
+			t.Errorf("compression %d: the depth layer read back differs", compression)
+		}
+	}
+
+	var raw, zipped bytes.Buffer
+	WriteEXR(&raw, width, height, layers, EXRNoCompression)
+	WriteEXR(&zipped, width, height, layers, EXRZIPCompression)
+	if zipped.Len() >= raw.Len() {
+		t.Errorf("got %d bytes with the zip compression, want less than the %d uncompressed bytes", zipped.Len(), raw.Len())
+	}
+}
+
+func TestWriteEXRErrors(t *testing.T) {
+	beauty := RGBLayer("", NewFramebuffer(2, 2))
+	tests := []struct {
+		name        string
+		layers      []EXRLayer
+		compression EXRCompression
+		want        string
+	}{
+		{"compression", []EXRLayer{beauty}, 4, "unsupported EXR compression 4"},
+		{"size", []EXRLayer{beauty, {Name: "depth", Channels: []string{"Z"}, Pix: make([]float32, 3)}}, EXRNoCompression, `EXR layer "depth" doesn't have 1 channels of 2x2 pixels`},
+		{"duplicate channel", []EXRLayer{beauty, RGBLayer("", NewFramebuffer(2, 2))}, EXRNoCompression, `duplicate EXR channel "R"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := WriteEXR(ioutil.Discard, 2, 2, test.layers, test.compression)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want %q", err, test.want)
+			}
+		})
+	}
+	if err := WriteEXR(ioutil.Discard, 0, 2, nil, EXRNoCompression); err == nil {
+		t.Error("an empty image was written")
+	}
+}
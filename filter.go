@@ -0,0 +1,204 @@
+package gotrace
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+/*
+Filter is a pixel reconstruction filter. Each sample is splatted into the pixels whose centers are closer than Radius
+along both axes, weighted by Eval of its offset from their centers, and pixels are the weighted mean of their samples.
+
+Filters wider than a pixel blur the image a little, but remove the aliasing of the edges.
+*/
+type Filter interface {
+	Radius() float64
+	Eval(x, y float64) float64
+}
+
+// BoxFilter weights all samples of the pixel equally, which is the mean of the samples falling in the pixel for a radius of 0.5
+type BoxFilter struct {
+	R float64
+}
+
+// Radius implements the Filter interface
+func (f BoxFilter) Radius() float64 {
+	return f.R
+}
+
+// Eval implements the Filter interface
+func (f BoxFilter) Eval(x, y float64) float64 {
+	// the interval is half open, so that a sample on the border of two pixels isn't counted twice
+	if -f.R < x && x <= f.R && -f.R < y && y <= f.R {
+		return 1
+	}
+	return 0
+}
+
+// TentFilter weights samples linearly decreasing with their distance to the pixel center, along both axes
+type TentFilter struct {
+	R float64
+}
+
+// Radius implements the Filter interface
+func (f TentFilter) Radius() float64 {
+	return f.R
+}
+
+// Eval implements the Filter interface
+func (f TentFilter) Eval(x, y float64) float64 {
+	return math.Max(0, f.R-math.Abs(x)) * math.Max(0, f.R-math.Abs(y))
+}
+
+// GaussianFilter weights samples by a gaussian of standard deviation Sigma, shifted so that it reaches 0 at the radius
+type GaussianFilter struct {
+	R, Sigma float64
+}
+
+// Radius implements the Filter interface
+func (f GaussianFilter) Radius() float64 {
+	return f.R
+}
+
+// Eval implements the Filter interface
+func (f GaussianFilter) Eval(x, y float64) float64 {
+	g := func(x float64) float64 {
+		return math.Max(0, math.Exp(-x*x/(2*f.Sigma*f.Sigma))-math.Exp(-f.R*f.R/(2*f.Sigma*f.Sigma)))
+	}
+	return g(x) * g(y)
+}
+
+// MitchellFilter is the Mitchell-Netravali cubic filter, B and C being 1/3 for the filter recommended by its authors
+// Its negative lobes sharpen the image.
+type MitchellFilter struct {
+	R, B, C float64
+}
+
+// Radius implements the Filter interface
+func (f MitchellFilter) Radius() float64 {
+	return f.R
+}
+
+// Eval implements the Filter interface
+func (f MitchellFilter) Eval(x, y float64) float64 {
+	return f.cubic(2*x/f.R) * f.cubic(2*y/f.R)
+}
+
+// cubic is the filter over [-2, 2]
+func (f MitchellFilter) cubic(x float64) float64 {
+	b, c := f.B, f.C
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+	case x < 2:
+		return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+	}
+	return 0
+}
+
+// LanczosFilter is a sinc filter windowed by a wider sinc, whose number of lobes is its radius
+type LanczosFilter struct {
+	R float64
+}
+
+// Radius implements the Filter interface
+func (f LanczosFilter) Radius() float64 {
+	return f.R
+}
+
+// Eval implements the Filter interface
+func (f LanczosFilter) Eval(x, y float64) float64 {
+	l := func(x float64) float64 {
+		if math.Abs(x) >= f.R {
+			return 0
+		}
+		return sinc(x) * sinc(x/f.R)
+	}
+	return l(x) * l(y)
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// FilterNames are the names of the filters, as used on the command line
+var FilterNames = []string{"box", "tent", "gaussian", "mitchell", "lanczos"}
+
+// NewFilter returns the filter of the given name and radius, with its usual settings
+// A radius of 0 or less gives the usual radius of the filter.
+func NewFilter(name string, radius float64) (Filter, error) {
+	defaults := map[string]float64{"box": 0.5, "tent": 1, "gaussian": 1.5, "mitchell": 2, "lanczos": 3}
+	if radius <= 0 {
+		radius = defaults[name]
+	}
+	switch name {
+	case "box":
+		return BoxFilter{radius}, nil
+	case "tent":
+		return TentFilter{radius}, nil
+	case "gaussian":
+		return GaussianFilter{radius, radius / 3}, nil
+	case "mitchell":
+		return MitchellFilter{radius, 1.0 / 3, 1.0 / 3}, nil
+	case "lanczos":
+		return LanczosFilter{radius}, nil
+	}
+	return nil, fmt.Errorf("unknown filter %q", name)
+}
+
+// film collects the samples of a tile, which are splatted into the pixels of the tile and of its neighbours
+type film struct {
+	filter Filter
+	// bounds of the pixels reached by the samples of the tile
+	bounds image.Rectangle
+	sum    []Vec3
+	weight []float64
+}
+
+// newFilm creates the film of a tile of an image of the given size
+func newFilm(tile image.Rectangle, filter Filter, width, height int) *film {
+	margin := int(math.Ceil(filter.Radius() - 0.5))
+	if margin < 0 {
+		margin = 0
+	}
+	bounds := tile.Inset(-margin).Intersect(image.Rect(0, 0, width, height))
+	return &film{
+		filter: filter,
+		bounds: bounds,
+		sum:    make([]Vec3, bounds.Dx()*bounds.Dy()),
+		weight: make([]float64, bounds.Dx()*bounds.Dy()),
+	}
+}
+
+// add splats a sample taken at the position (x, y) of the image
+func (f *film) add(x, y float64, c Vec3) {
+	r := f.filter.Radius()
+	// pixels whose centers are within the radius of the sample
+	x0 := int(math.Max(math.Ceil(x-0.5-r), float64(f.bounds.Min.X)))
+	x1 := int(math.Min(math.Floor(x-0.5+r), float64(f.bounds.Max.X-1)))
+	y0 := int(math.Max(math.Ceil(y-0.5-r), float64(f.bounds.Min.Y)))
+	y1 := int(math.Min(math.Floor(y-0.5+r), float64(f.bounds.Max.Y-1)))
+	for py := y0; py <= y1; py++ {
+		for px := x0; px <= x1; px++ {
+			w := f.filter.Eval(float64(px)+0.5-x, float64(py)+0.5-y)
+			if w == 0 {
+				continue
+			}
+			k := (py-f.bounds.Min.Y)*f.bounds.Dx() + px - f.bounds.Min.X
+			f.sum[k] = f.sum[k].Add(c.Scale(w))
+			f.weight[k] += w
+		}
+	}
+}
+
+// splat adds the samples of the film to the accumulator
+func (f *film) splat(acc *Accumulator) {
+	for k, sum := range f.sum {
+		acc.splat(f.bounds.Min.X+k%f.bounds.Dx(), f.bounds.Min.Y+k/f.bounds.Dx(), sum, f.weight[k])
+	}
+}
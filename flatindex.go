@@ -0,0 +1,172 @@
+package gotrace
+
+import "math"
+
+/*
+FlatIndex is a bounding volume hierarchy laid out in a contiguous array of nodes, which is quicker to traverse than the
+tree of an Index: nodes are visited in a loop rather than by recursive calls through the geometry interface, the first
+child of a node follows it in the array, and the actors of the leaves are stored next to each other.
+
+The children of each node are ordered along the axis separating them the most, so that rays visit the nearest child first
+and skip the other one when it is farther than their closest hit.
+*/
+type FlatIndex struct {
+	nodes  []flatNode
+	actors Collection
+	// unbounded actors, which can't be part of the hierarchy, are hit one after the other before it
+	unbounded Collection
+}
+
+// flatNode is a node of a FlatIndex, padded to the size of a cache line so that nodes don't straddle two lines
+type flatNode struct {
+	box Bbox
+	// first actor of leaves, or second child of interior nodes
+	offset int32
+	// number of actors of leaves, 0 for interior nodes
+	count int32
+	// axis along which the first child of interior nodes is before the second one
+	axis int32
+	_    int32
+}
+
+// NewFlatIndex builds the hierarchy of the actors of the world with the given strategy, as BuildIndex, and flattens it
+func NewFlatIndex(world Collection, startTime, endTime float64, strategy IndexStrategy) *FlatIndex {
+	idx := BuildIndex(world, startTime, endTime, strategy)
+	f := &FlatIndex{unbounded: idx.unbounded}
+	if idx.left != nil {
+		f.flatten(idx, startTime, endTime)
+	}
+	return f
+}
+
+// flatten appends the nodes of a subtree in depth-first order, and returns the position of its root
+func (f *FlatIndex) flatten(g Geometry, startTime, endTime float64) int32 {
+	position := int32(len(f.nodes))
+	f.nodes = append(f.nodes, flatNode{})
+	node := flatNode{offset: int32(len(f.actors))}
+	switch g := g.(type) {
+	case *Index:
+		if g.right == nil {
+			// a root with a single leaf
+			f.nodes = f.nodes[:position]
+			return f.flatten(g.left, startTime, endTime)
+		}
+		node.box = g.box
+		first, second := g.left, g.right
+		_, firstBox := first.Bound(startTime, endTime)
+		_, secondBox := second.Bound(startTime, endTime)
+		// twice the distance between the centers of the children
+		separation := secondBox.Min.Add(secondBox.Max).Sub(firstBox.Min.Add(firstBox.Max))
+		node.axis = int32(longestAxis(Bbox{Max: Vec3{math.Abs(separation.X), math.Abs(separation.Y), math.Abs(separation.Z)}}))
+		if separation.AsArray()[node.axis] < 0 {
+			first, second = second, first
+		}
+		f.flatten(first, startTime, endTime)
+		node.offset = f.flatten(second, startTime, endTime)
+	case Collection:
+		_, box := g.Bound(startTime, endTime)
+		node.box = *box
+		node.count = int32(len(g))
+		f.actors = append(f.actors, g...)
+	case Actor:
+		_, box := g.Bound(startTime, endTime)
+		node.box = *box
+		node.count = 1
+		f.actors = append(f.actors, g)
+	default:
+		panic("unexpected node of an index")
+	}
+	f.nodes[position] = node
+	return position
+}
+
+// Hit implements the hit interface for the FlatIndex
+func (f *FlatIndex) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	var record HitRecord
+	if !f.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
+	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for the FlatIndex
+func (f *FlatIndex) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
+	hit := false
+	if len(f.unbounded) > 0 && f.unbounded.hitRecord(ray, tMin, tMax, record) {
+		hit = true
+		tMax = record.Distance
+	}
+	if len(f.nodes) == 0 {
+		return hit
+	}
+
+	// the inverse of the direction is shared by the tests of all bounding boxes
+	inv := Vec3{1 / ray.Direction.X, 1 / ray.Direction.Y, 1 / ray.Direction.Z}
+	negative := [3]bool{inv.X < 0, inv.Y < 0, inv.Z < 0}
+	// nodes left to visit, which are few for the balanced hierarchies of the builders
+	var buffer [64]int32
+	stack := buffer[:0]
+	current := int32(0)
+	for {
+		node := &f.nodes[current]
+		if node.box.hitInverse(ray.Origin, inv, tMin, tMax) {
+			if node.count == 0 {
+				// the nearest child is visited first
+				if negative[node.axis] {
+					stack = append(stack, current+1)
+					current = node.offset
+				} else {
+					stack = append(stack, node.offset)
+					current++
+				}
+				continue
+			}
+			for _, actor := range f.actors[node.offset : node.offset+node.count] {
+				if actor.hitRecord(ray, tMin, tMax, record) {
+					hit = true
+					tMax = record.Distance
+				}
+			}
+		}
+		if len(stack) == 0 {
+			return hit
+		}
+		current = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+	}
+}
+
+// Bound returns the bounding box of the FlatIndex, which has none if it is empty or contains unbounded actors
+func (f *FlatIndex) Bound(tMin float64, tMax float64) (bool, *Bbox) {
+	if len(f.nodes) == 0 || len(f.unbounded) > 0 {
+		return false, nil
+	}
+	box := f.nodes[0].box
+	return true, &box
+}
+
+// hitInverse is like Hit, for a ray of the given origin and inverse direction
+func (b *Bbox) hitInverse(origin, inv Vec3, tMin, tMax float64) bool {
+	tMin, tMax = slab(b.Min.X, b.Max.X, origin.X, inv.X, tMin, tMax)
+	tMin, tMax = slab(b.Min.Y, b.Max.Y, origin.Y, inv.Y, tMin, tMax)
+	tMin, tMax = slab(b.Min.Z, b.Max.Z, origin.Z, inv.Z, tMin, tMax)
+	return tMin < tMax
+}
+
+// slab narrows the interval of distances from tMin to tMax to the part of the ray between min and max along an axis.
+// Distances are NaN for rays starting at min or max whose direction is zero along the axis, which leave it unchanged.
+func slab(min, max, origin, inv, tMin, tMax float64) (float64, float64) {
+	t0 := (min - origin) * inv
+	t1 := (max - origin) * inv
+	if inv < 0 {
+		t0, t1 = t1, t0
+	}
+	if t0 > tMin {
+		tMin = t0
+	}
+	if t1 < tMax {
+		tMax = t1
+	}
+	return tMin, tMax
+}
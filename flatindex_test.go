@@ -0,0 +1,96 @@
+package gotrace
+
+import (
+	_ "image/jpeg"
+	"math"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// finalSceneWithoutFog returns the actors of the final scene, without the fog whose hits are random
+func finalSceneWithoutFog() (*Scene, Collection) {
+	scene := FinalScene()
+	var world Collection
+	for _, actor := range scene.objects {
+		if _, ok := actor.shape.(Fog); !ok {
+			world.Add(actor)
+		}
+	}
+	return scene, world
+}
+
+func TestFlatIndex(t *testing.T) {
+	scene, world := finalSceneWithoutFog()
+	_, box := world.Bound(0, 1)
+	rays := randomRays(2000, *box, 1)
+	// camera rays, most of which hit the ground or the spheres
+	sampler := NewIndependentSampler(1)
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			rays = append(rays, scene.camera.RayTo(float64(x)/20, float64(y)/20, sampler))
+		}
+	}
+	for _, strategy := range indexStrategies {
+		t.Run(strategy.String(), func(t *testing.T) {
+			idx := BuildIndex(world, 0, 1, strategy)
+			flat := NewFlatIndex(world, 0, 1, strategy)
+			if _, box := idx.Bound(0, 1); flat.nodes[0].box != *box {
+				t.Errorf("got bounding box %v for the flat index, want %v", flat.nodes[0].box, *box)
+			}
+			hits, ties := 0, 0
+			for i, ray := range rays {
+				want, wantRecord := idx.Hit(ray, 0.001, math.MaxFloat64)
+				hit, record := flat.Hit(ray, 0.001, math.MaxFloat64)
+				if hit != want || hit && record.Distance != wantRecord.Distance {
+					t.Fatalf("ray %d: got hit %v %+v, want %v %+v", i, hit, record, want, wantRecord)
+				}
+				if !hit {
+					continue
+				}
+				hits++
+				if record.ActorID != wantRecord.ActorID {
+					// actors hit at the same distance, such as the common faces of the boxes of the ground, are found
+					// in the order the indexes visit them
+					ties++
+				}
+			}
+			if hits == 0 || ties > hits/100 {
+				t.Errorf("got %d hits and %d ties for %d rays, want hits and few ties", hits, ties, len(rays))
+			}
+		})
+	}
+}
+
+// benchmarkTraversal hits the index with the rays through the renderer's records, and reports the rays per second
+func TestFlatNodeSize(t *testing.T) {
+	if size := unsafe.Sizeof(flatNode{}); size != 64 {
+		t.Errorf("nodes are %d bytes, not a cache line", size)
+	}
+}
+
+func benchmarkTraversal(b *testing.B, index recordHitter, rays []Ray) {
+	var record HitRecord
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		index.hitRecord(rays[i%len(rays)], 0.001, math.MaxFloat64, &record)
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "rays/s")
+}
+
+// BenchmarkFlatIndex compares the traversals of the flat and pointer indexes of the final scene, with rays going
+// through its cluster of 1000 spheres
+func BenchmarkFlatIndex(b *testing.B) {
+	_, world := finalSceneWithoutFog()
+	_, cluster := world[len(world)-1000:].Bound(0, 1)
+	rays := randomRays(1<<12, *cluster, 1)
+	for _, strategy := range indexStrategies {
+		b.Run("index/"+strategy.String(), func(b *testing.B) {
+			benchmarkTraversal(b, BuildIndex(world, 0, 1, strategy), rays)
+		})
+		b.Run("flat/"+strategy.String(), func(b *testing.B) {
+			benchmarkTraversal(b, NewFlatIndex(world, 0, 1, strategy), rays)
+		})
+	}
+}
@@ -33,6 +33,27 @@ type Geometry interface {
 	Bound(startTime float64, endTime float64) (bool, *Bbox)
 }
 
+/*
+recordHitter is implemented by the geometries of the package, which fill the record of their hits rather than allocating
+it, so that the renderer traces rays without allocating. The record is only changed if the geometry is hit, so the
+record of the closest hit so far can be passed to the next geometries, closer hits replacing it.
+*/
+type recordHitter interface {
+	hitRecord(ray Ray, tMin, tMax float64, record *HitRecord) bool
+}
+
+// hitRecord fills the record with the hit of the ray with the geometry, calling Hit for geometries of other packages
+func hitRecord(g Geometry, ray Ray, tMin, tMax float64, record *HitRecord) bool {
+	if g, ok := g.(recordHitter); ok {
+		return g.hitRecord(ray, tMin, tMax, record)
+	}
+	hit, r := g.Hit(ray, tMin, tMax)
+	if hit {
+		*record = *r
+	}
+	return hit
+}
+
 // Sphere geometry
 type Sphere struct {
 	Center Vec3
@@ -50,6 +71,16 @@ func (s Sphere) pixelHit(pos Vec3) (u, v float64) {
 
 // Hit implements the geomtry interface for checking the intersection of a Ray and a Sphere
 func (s Sphere) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	var record HitRecord
+	if !s.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
+	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for a Sphere
+func (s Sphere) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
 	oc := ray.Origin.Sub(s.Center)
 	a := ray.Direction.SquareNorm()
 	b := oc.Dot(ray.Direction)
@@ -68,7 +99,8 @@ func (s Sphere) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
 			*/
 			n := pos.Sub(s.Center).Div(s.Radius)
 			u, v := s.pixelHit(n)
-			return true, &HitRecord{Distance: t, Position: pos, Normal: n, U: u, V: v}
+			*record = HitRecord{Distance: t, Position: pos, Normal: n, U: u, V: v}
+			return true
 		}
 		// second solution, farthest from camera
 		t = (-b + root) / a
@@ -76,11 +108,12 @@ func (s Sphere) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
 			pos := ray.At(t)
 			n := pos.Sub(s.Center).Div(s.Radius)
 			u, v := s.pixelHit(n)
-			return true, &HitRecord{Distance: t, Position: pos, Normal: n, U: u, V: v}
+			*record = HitRecord{Distance: t, Position: pos, Normal: n, U: u, V: v}
+			return true
 		}
 	}
 
-	return false, nil
+	return false
 }
 
 // Bound returns the bounding box of the Sphere
@@ -109,6 +142,16 @@ func (s MovingSphere) centerAt(time float64) Vec3 {
 
 // Hit implements the geomtry interface for checking the intersection of a Ray and a MovingSphere
 func (s MovingSphere) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	var record HitRecord
+	if !s.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
+	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for a MovingSphere
+func (s MovingSphere) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
 	center := s.centerAt(ray.Time)
 	oc := ray.Origin.Sub(center)
 	a := ray.Direction.SquareNorm()
@@ -123,18 +166,20 @@ func (s MovingSphere) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord
 		if t < tMax && t > tMin {
 			pos := ray.At(t)
 			n := pos.Sub(center).Div(s.Radius)
-			return true, &HitRecord{Distance: t, Position: pos, Normal: n}
+			*record = HitRecord{Distance: t, Position: pos, Normal: n}
+			return true
 		}
 		// second solution, farthest from camera
 		t = (-b + root) / a
 		if t < tMax && t > tMin {
 			pos := ray.At(t)
 			n := pos.Sub(center).Div(s.Radius)
-			return true, &HitRecord{Distance: t, Position: pos, Normal: n}
+			*record = HitRecord{Distance: t, Position: pos, Normal: n}
+			return true
 		}
 	}
 
-	return false, nil
+	return false
 }
 
 // Bound returns the bounding box of the MovingSphere
@@ -161,20 +206,31 @@ type RectXY struct {
 
 // Hit implements the geometry interface for RectXY
 func (r RectXY) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	var record HitRecord
+	if !r.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
+	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for RectXY
+func (r RectXY) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
 	t := (r.k - ray.Origin.Z) / ray.Direction.Z
 	if t < tMin || t > tMax {
-		return false, nil
+		return false
 	}
 	x := ray.Origin.X + t*ray.Direction.X
 	y := ray.Origin.Y + t*ray.Direction.Y
 	if x < r.x0 || x > r.x1 || y < r.y0 || y > r.y1 {
-		return false, nil
+		return false
 	}
 	u := (x - r.x0) / (r.x1 - r.x0)
 	v := (y - r.y0) / (r.y1 - r.y0)
 
 	// TODO don't forget to check for normal direction in scatter
-	return true, &HitRecord{Distance: t, Position: ray.At(t), U: u, V: v, Normal: Vec3{Z: 1}}
+	*record = HitRecord{Distance: t, Position: ray.At(t), U: u, V: v, Normal: Vec3{Z: 1}}
+	return true
 }
 
 // Bound returns the bounding box of a RectXY
@@ -191,20 +247,31 @@ type RectXZ struct {
 
 // Hit implements the geometry interface for RectXY
 func (r RectXZ) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	var record HitRecord
+	if !r.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
+	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for RectXZ
+func (r RectXZ) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
 	t := (r.k - ray.Origin.Y) / ray.Direction.Y
 	if t < tMin || t > tMax {
-		return false, nil
+		return false
 	}
 	x := ray.Origin.X + t*ray.Direction.X
 	z := ray.Origin.Z + t*ray.Direction.Z
 	if x < r.x0 || x > r.x1 || z < r.z0 || z > r.z1 {
-		return false, nil
+		return false
 	}
 	u := (x - r.x0) / (r.x1 - r.x0)
 	v := (z - r.z0) / (r.z1 - r.z0)
 
 	// TODO don't forget to check for normal direction in scatter
-	return true, &HitRecord{Distance: t, Position: ray.At(t), U: u, V: v, Normal: Vec3{Y: 1}}
+	*record = HitRecord{Distance: t, Position: ray.At(t), U: u, V: v, Normal: Vec3{Y: 1}}
+	return true
 }
 
 // Bound returns the bounding box of a RectXZ
@@ -221,20 +288,31 @@ type RectYZ struct {
 
 // Hit implements the geometry interface for RectYZ
 func (r RectYZ) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	var record HitRecord
+	if !r.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
+	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for RectYZ
+func (r RectYZ) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
 	t := (r.k - ray.Origin.X) / ray.Direction.X
 	if t < tMin || t > tMax {
-		return false, nil
+		return false
 	}
 	y := ray.Origin.Y + t*ray.Direction.Y
 	z := ray.Origin.Z + t*ray.Direction.Z
 	if y < r.y0 || y > r.y1 || z < r.z0 || z > r.z1 {
-		return false, nil
+		return false
 	}
 	u := (y - r.y0) / (r.y1 - r.y0)
 	v := (z - r.z0) / (r.z1 - r.z0)
 
 	// TODO don't forget to check for normal direction in scatter
-	return true, &HitRecord{Distance: t, Position: ray.At(t), U: u, V: v, Normal: Vec3{X: 1}}
+	*record = HitRecord{Distance: t, Position: ray.At(t), U: u, V: v, Normal: Vec3{X: 1}}
+	return true
 }
 
 // Bound returns the bounding box of a RectXZ
@@ -249,11 +327,21 @@ type FlipFace struct {
 
 // Hit returns the hit of the inital geometry, but with the opposed record normal
 func (f FlipFace) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
-	hit, rec := f.reversed.Hit(ray, tMin, tMax)
-	if rec != nil {
-		rec.Normal = rec.Normal.Scale(-1)
+	var record HitRecord
+	if !f.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
+	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for a FlipFace
+func (f FlipFace) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
+	if !hitRecord(f.reversed, ray, tMin, tMax, record) {
+		return false
 	}
-	return hit, rec
+	record.Normal = record.Normal.Scale(-1)
+	return true
 }
 
 // Bound returns the bounding box of a the initial geometry
@@ -288,19 +376,27 @@ func NewBox(p0, p1 Vec3) Box {
 
 // Hit implements the geometry interface for a Box
 func (b Box) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	var record HitRecord
+	if !b.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
+	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for a Box, keeping the closest hit of its sides
+func (b Box) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
 	hitAnything := false
 	closestHit := tMax
-	var closestRecord *HitRecord = nil
 
 	for _, side := range b.sides {
-		if hit, record := side.Hit(ray, tMin, closestHit); hit {
-			closestRecord = record
+		if hitRecord(side, ray, tMin, closestHit, record) {
 			closestHit = record.Distance
 			hitAnything = true
 		}
 	}
 
-	return hitAnything, closestRecord
+	return hitAnything
 }
 
 // Bound returns the bounding box of the Box
@@ -317,12 +413,22 @@ type Translate struct {
 // Hit implements the geometry interface for a Translated object
 // It does so by offsetting the ray rather than the wrapped object
 func (t Translate) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
-	movedRay := Ray{ray.Origin.Sub(t.offset), ray.Direction, ray.Time, ray.RandSource}
-	if hit, record := t.shape.Hit(movedRay, tMin, tMax); hit {
-		record.Position = record.Position.Add(t.offset)
-		return true, record
+	var record HitRecord
+	if !t.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
 	}
-	return false, nil
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for a Translated object
+func (t Translate) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
+	movedRay := Ray{ray.Origin.Sub(t.offset), ray.Direction, ray.Time, ray.Sampler}
+	if !hitRecord(t.shape, movedRay, tMin, tMax, record) {
+		return false
+	}
+	record.Position = record.Position.Add(t.offset)
+	return true
 }
 
 // Bound returns the bounding box of a translated geometry
@@ -336,6 +442,7 @@ func (t Translate) Bound(startTime float64, endTime float64) (bool, *Bbox) {
 // RotateY is a wrapper around a geometry, which is rotated around the Y axis
 type RotateY struct {
 	shape    Geometry
+	angle    float64
 	sinTheta float64
 	cosTheta float64
 	bbox     Bbox
@@ -364,13 +471,14 @@ func NewRotateY(shape Geometry, angle float64) Geometry {
 
 				tmpvec := Vec3{tmpx, y, tmpz}
 				minPoint = MinCoord(minPoint, tmpvec)
-				maxPoint = MinCoord(maxPoint, tmpvec)
+				maxPoint = MaxCoord(maxPoint, tmpvec)
 			}
 		}
 	}
 
 	return RotateY{
 		shape:    shape,
+		angle:    angle,
 		sinTheta: sinTheta,
 		cosTheta: cosTheta,
 		hasBox:   hasBox,
@@ -380,6 +488,16 @@ func NewRotateY(shape Geometry, angle float64) Geometry {
 
 // Hit implements the geometry interface for a Rotated object (around Y axis)
 func (r RotateY) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	var record HitRecord
+	if !r.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
+	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for a Rotated object
+func (r RotateY) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
 	origin := ray.Origin
 	direction := ray.Direction
 
@@ -389,21 +507,24 @@ func (r RotateY) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
 	direction.X = r.cosTheta*ray.Direction.X - r.sinTheta*ray.Direction.Z
 	direction.Z = r.sinTheta*ray.Direction.X + r.cosTheta*ray.Direction.Z
 
-	rotatedRay := Ray{origin, direction, ray.Time, ray.RandSource}
+	rotatedRay := Ray{origin, direction, ray.Time, ray.Sampler}
 
-	if hit, record := r.shape.Hit(rotatedRay, tMin, tMax); hit {
+	if hitRecord(r.shape, rotatedRay, tMin, tMax, record) {
 		pos := record.Position
 		n := record.Normal
 
-		pos.X = r.cosTheta*record.Position.X - r.sinTheta*record.Position.Z
+		// the hit is rotated back into the world, the opposite of the rotation of the ray
+		pos.X = r.cosTheta*record.Position.X + r.sinTheta*record.Position.Z
 		pos.Z = -r.sinTheta*record.Position.X + r.cosTheta*record.Position.Z
 
-		n.X = r.cosTheta*record.Normal.X - r.sinTheta*record.Normal.Z
+		n.X = r.cosTheta*record.Normal.X + r.sinTheta*record.Normal.Z
 		n.Z = -r.sinTheta*record.Normal.X + r.cosTheta*record.Normal.Z
 
-		return true, &HitRecord{Distance: record.Distance, Position: pos, Normal: n} // TODO recompute distance ?
+		// the distance along the ray doesn't change, as rotations keep the length of its direction
+		record.Position, record.Normal = pos, n
+		return true
 	}
-	return false, nil
+	return false
 }
 
 // Bound returns the bounding box of a rotated geometry
@@ -419,50 +540,63 @@ type Fog struct {
 
 // Hit implements the geometry interface for volumetric medium
 func (f Fog) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
-	var (
-		hit       bool
-		firstHit  *HitRecord
-		secondHit *HitRecord
-	)
-
-	if hit, firstHit = f.boundary.Hit(ray, -math.MaxFloat64, math.MaxFloat64); !hit {
+	var record HitRecord
+	if !f.hitRecord(ray, tMin, tMax, &record) {
 		return false, nil
 	}
+	hit := record
+	return true, &hit
+}
 
-	if hit, secondHit = f.boundary.Hit(ray, firstHit.Distance+0.0001, math.MaxFloat64); !hit {
-		return false, nil
+// hitRecord implements the recordHitter interface for volumetric medium. The hits of the boundary are found in the record,
+// which is restored if the medium isn't hit.
+func (f Fog) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
+	closest := *record
+
+	if !hitRecord(f.boundary, ray, -math.MaxFloat64, math.MaxFloat64, record) {
+		return false
 	}
+	firstHit := record.Distance
 
-	if firstHit.Distance < tMin {
-		firstHit.Distance = tMin
+	if !hitRecord(f.boundary, ray, firstHit+0.0001, math.MaxFloat64, record) {
+		*record = closest
+		return false
 	}
+	secondHit := record.Distance
 
-	if secondHit.Distance > tMax {
-		secondHit.Distance = tMax
+	if firstHit < tMin {
+		firstHit = tMin
 	}
 
-	if firstHit.Distance >= secondHit.Distance {
-		return false, nil
+	if secondHit > tMax {
+		secondHit = tMax
 	}
 
-	if firstHit.Distance < 0 {
-		firstHit.Distance = 0
+	if firstHit >= secondHit {
+		*record = closest
+		return false
+	}
+
+	if firstHit < 0 {
+		firstHit = 0
 	}
 
 	rayLength := ray.Direction.Norm()
-	distanceInsideBoundary := (secondHit.Distance - firstHit.Distance) * rayLength
-	hitDistance := -math.Log(ray.RandSource.Float64()) / f.density
+	distanceInsideBoundary := (secondHit - firstHit) * rayLength
+	hitDistance := -math.Log(ray.Sampler.Get1D()) / f.density
 
 	if hitDistance > distanceInsideBoundary {
-		return false, nil
+		*record = closest
+		return false
 	}
 
-	t := firstHit.Distance + hitDistance/rayLength
+	t := firstHit + hitDistance/rayLength
 	p := ray.At(t)
 
 	// we dont bother computing a normal at the hit because materials used with
 	// fog scatter in random direction
-	return true, &HitRecord{Distance: t, Position: p}
+	*record = HitRecord{Distance: t, Position: p}
+	return true
 }
 
 // Bound returns the bounding box of the volumetric medium
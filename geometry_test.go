@@ -0,0 +1,54 @@
+package gotrace
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRotateY(t *testing.T) {
+	// turning the box by 90 degrees takes its x axis to -z and its z axis to x
+	box := NewRotateY(NewBox(Vec3{0, 0, 0}, Vec3{2, 1, 1}), 90)
+	if _, bound := box.Bound(0, 1); bound.Min.Sub(Vec3{0, 0, -2}).Norm() > 1e-9 || bound.Max.Sub(Vec3{1, 1, 0}).Norm() > 1e-9 {
+		t.Errorf("got bounding box %v, want [0 0 -2] to [1 1 0]", bound)
+	}
+	tests := []struct {
+		name     string
+		ray      Ray
+		hit      bool
+		position Vec3
+		normal   Vec3
+	}{
+		{"front", Ray{Origin: Vec3{0.5, 0.5, 5}, Direction: Vec3{0, 0, -1}}, true, Vec3{0.5, 0.5, 0}, Vec3{0, 0, 1}},
+		{"side", Ray{Origin: Vec3{5, 0.5, -1.5}, Direction: Vec3{-1, 0, 0}}, true, Vec3{1, 0.5, -1.5}, Vec3{1, 0, 0}},
+		{"top", Ray{Origin: Vec3{0.25, 3, -1}, Direction: Vec3{0, -1, 0}}, true, Vec3{0.25, 1, -1}, Vec3{0, 1, 0}},
+		{"where the box was", Ray{Origin: Vec3{1.5, 0.5, 5}, Direction: Vec3{0, 0, -1}}, false, Vec3{}, Vec3{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hit, record := box.Hit(test.ray, 0.001, math.Inf(1))
+			if hit != test.hit {
+				t.Fatalf("got hit %v, want %v", hit, test.hit)
+			}
+			if hit && (record.Position.Sub(test.position).Norm() > 1e-9 || record.Normal.Sub(test.normal).Norm() > 1e-9) {
+				t.Errorf("got hit at %v with normal %v, want %v with normal %v", record.Position, record.Normal, test.position, test.normal)
+			}
+		})
+	}
+
+	// whatever the angle, hits are on the ray, and the boxes are found through an index of their bounding boxes
+	for _, angle := range []float64{15, -18, 45, 200} {
+		rotated := NewRotateY(NewBox(Vec3{0, 0, 0}, Vec3{165, 330, 165}), angle)
+		world := NewIndex(Collection{Actor{shape: rotated, material: Lambertian{ConstantTexture{WHITE}}}}, 0, 0, 0, 1)
+		ray := Ray{Origin: Vec3{-500, 100, -400}, Direction: Vec3{500, 0, 400}}
+		hit, record := world.Hit(ray, 0.001, math.Inf(1))
+		if !hit {
+			t.Fatalf("the box turned by %v degrees wasn't hit", angle)
+		}
+		if d := record.Position.Sub(ray.At(record.Distance)).Norm(); d > 1e-9 {
+			t.Errorf("got hit at %v for the box turned by %v degrees, %v away from the ray", record.Position, angle, d)
+		}
+		if record.Normal.Dot(ray.Direction) >= 0 {
+			t.Errorf("got normal %v for the box turned by %v degrees, want a normal facing the ray", record.Normal, angle)
+		}
+	}
+}
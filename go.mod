@@ -5,5 +5,5 @@ go 1.14
 require (
 	github.com/cheggaaa/pb/v3 v3.0.4
 	github.com/ojrac/opensimplex-go v1.0.1
-	golang.org/x/sync v0.0.0-20200317015054-43a5402ce75a
+	gopkg.in/yaml.v3 v3.0.1
 )
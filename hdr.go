@@ -0,0 +1,102 @@
+package gotrace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// Framebuffer is an image of the linear radiance of the pixels. Unlike 8-bit images, it keeps the values above 1.
+type Framebuffer struct {
+	Width, Height int
+	// Pix holds the red, green and blue values of the pixels, line by line from top to bottom
+	Pix []float32
+}
+
+// NewFramebuffer creates a black framebuffer of the given size
+func NewFramebuffer(width, height int) *Framebuffer {
+	return &Framebuffer{
+		Width:  width,
+		Height: height,
+		Pix:    make([]float32, 3*width*height),
+	}
+}
+
+// Pixel returns the radiance of the pixel (x, y), the origin being the top left corner
+func (f *Framebuffer) Pixel(x, y int) Vec3 {
+	p := f.Pix[3*(y*f.Width+x):]
+	return Vec3{float64(p[0]), float64(p[1]), float64(p[2])}
+}
+
+// SetPixel sets the radiance of the pixel (x, y)
+func (f *Framebuffer) SetPixel(x, y int, c Vec3) {
+	p := f.Pix[3*(y*f.Width+x):]
+	p[0], p[1], p[2] = float32(c.X), float32(c.Y), float32(c.Z)
+}
+
+// Image returns the 8-bit image of the framebuffer, mapped by the default SRGB tone mapper
+func (f *Framebuffer) Image() *image.RGBA {
+	return f.ToneMap(SRGB{})
+}
+
+/*
+WriteHDR writes the framebuffer as a Radiance RGBE image (.hdr), which stores each pixel as three 8-bit mantissas
+sharing an 8-bit exponent. Scanlines are written uncompressed, which all readers of the format support.
+*/
+func WriteHDR(w io.Writer, f *Framebuffer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", f.Height, f.Width)
+	for k := 0; k < len(f.Pix); k += 3 {
+		rgbe := toRGBE(f.Pix[k], f.Pix[k+1], f.Pix[k+2])
+		if _, err := bw.Write(rgbe[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// maxRGBE is the largest value which can be encoded in RGBE, its exponent being 127
+var maxRGBE = math.Ldexp(255.0/256, 127)
+
+// toRGBE encodes a color as mantissas and a shared exponent, negative and NaN values being written as 0
+func toRGBE(r, g, b float32) [4]byte {
+	c := [3]float64{float64(r), float64(g), float64(b)}
+	max := 0.0
+	for i, v := range c {
+		if !(v > 0) {
+			c[i] = 0
+		} else if v > maxRGBE {
+			c[i] = maxRGBE
+		}
+		max = math.Max(max, c[i])
+	}
+	if max < 1e-32 {
+		return [4]byte{}
+	}
+	frac, exp := math.Frexp(max)
+	scale := frac * 256 / max
+	return [4]byte{byte(c[0] * scale), byte(c[1] * scale), byte(c[2] * scale), byte(exp + 128)}
+}
+
+/*
+WritePFM writes the framebuffer as a Portable Float Map (.pfm), which stores each channel as a 32-bit float.
+As required by the format, lines are written from bottom to top.
+*/
+func WritePFM(w io.Writer, f *Framebuffer) error {
+	bw := bufio.NewWriter(w)
+	// a negative scale means little endian values
+	fmt.Fprintf(bw, "PF\n%d %d\n-1.0\n", f.Width, f.Height)
+	line := make([]byte, 4*3*f.Width)
+	for y := f.Height - 1; y >= 0; y-- {
+		for i, v := range f.Pix[3*y*f.Width : 3*(y+1)*f.Width] {
+			binary.LittleEndian.PutUint32(line[4*i:], math.Float32bits(v))
+		}
+		if _, err := bw.Write(line); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
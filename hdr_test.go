@@ -0,0 +1,133 @@
+package gotrace
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// fromRGBE decodes a pixel written by toRGBE, at the middle of the interval of the values of each mantissa
+func fromRGBE(rgbe [4]byte) [3]float64 {
+	if rgbe[3] == 0 {
+		return [3]float64{}
+	}
+	var c [3]float64
+	for i := range c {
+		c[i] = math.Ldexp((float64(rgbe[i])+0.5)/256, int(rgbe[3])-128)
+	}
+	return c
+}
+
+func TestToRGBE(t *testing.T) {
+	tests := []struct {
+		name    string
+		r, g, b float32
+		want    [4]byte
+	}{
+		{"black", 0, 0, 0, [4]byte{}},
+		{"powers of two", 1, 0.5, 0.25, [4]byte{128, 64, 32, 129}},
+		{"bright", 15, 15, 15, [4]byte{240, 240, 240, 132}},
+		{"negative and NaN", -1, float32(math.NaN()), 2, [4]byte{0, 0, 128, 130}},
+		{"too small", 1e-40, 0, 0, [4]byte{}},
+		{"infinite", float32(math.Inf(1)), 0, 0, [4]byte{255, 0, 0, 255}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := toRGBE(test.r, test.g, test.b); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	// the largest channel keeps 8 bits of precision
+	for _, v := range []float32{0.001, 0.3, 1, 7.5, 1234.5, 1e20} {
+		decoded := fromRGBE(toRGBE(v, v/3, 0))
+		if math.Abs(decoded[0]-float64(v)) > float64(v)/256 {
+			t.Errorf("%v was decoded as %v", v, decoded[0])
+		}
+	}
+}
+
+// testFramebuffer returns a 2x2 framebuffer whose pixels have distinct colors, some of them above 1
+func testFramebuffer() *Framebuffer {
+	f := NewFramebuffer(2, 2)
+	f.SetPixel(0, 0, Vec3{1, 0.5, 0.25})
+	f.SetPixel(1, 0, Vec3{15, 15, 15})
+	f.SetPixel(0, 1, Vec3{0, 0, 2})
+	f.SetPixel(1, 1, Vec3{0.1, 0.2, 0.3})
+	return f
+}
+
+func TestWriteHDR(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteHDR(&b, testFramebuffer()); err != nil {
+		t.Fatal(err)
+	}
+	header := "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y 2 +X 2\n"
+	if !bytes.HasPrefix(b.Bytes(), []byte(header)) {
+		t.Fatalf("got header %q, want %q", b.Bytes()[:len(header)], header)
+	}
+	pixels := b.Bytes()[len(header):]
+	if len(pixels) != 16 {
+		t.Fatalf("got %d bytes of pixels, want 16", len(pixels))
+	}
+	// lines go from top to bottom
+	for i, want := range [][4]byte{{128, 64, 32, 129}, {240, 240, 240, 132}, {0, 0, 128, 130}, toRGBE(0.1, 0.2, 0.3)} {
+		var got [4]byte
+		copy(got[:], pixels[4*i:])
+		if got != want {
+			t.Errorf("got %v for pixel %d, want %v", got, i, want)
+		}
+	}
+}
+
+func TestWritePFM(t *testing.T) {
+	f := testFramebuffer()
+	var b bytes.Buffer
+	if err := WritePFM(&b, f); err != nil {
+		t.Fatal(err)
+	}
+	header := "PF\n2 2\n-1.0\n"
+	if !bytes.HasPrefix(b.Bytes(), []byte(header)) {
+		t.Fatalf("got header %q, want %q", b.Bytes()[:len(header)], header)
+	}
+	values := make([]float32, 12)
+	if err := binary.Read(bytes.NewReader(b.Bytes()[len(header):]), binary.LittleEndian, values); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != len(header)+4*len(values) {
+		t.Fatalf("got %d bytes, want %d", b.Len(), len(header)+4*len(values))
+	}
+	// lines go from bottom to top, and values are kept exactly
+	for i := 0; i < 4; i++ {
+		x, y := i%2, 1-i/2
+		got := Vec3{float64(values[3*i]), float64(values[3*i+1]), float64(values[3*i+2])}
+		want := f.Pixel(x, y)
+		if got != want {
+			t.Errorf("got %v for pixel (%d, %d), want %v", got, x, y, want)
+		}
+	}
+}
+
+func TestRenderHDR(t *testing.T) {
+	f, _, err := CornellBox().RenderHDR(context.Background(), RenderOptions{Width: 32, Height: 32, Samples: 4, Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Width != 32 || f.Height != 32 || len(f.Pix) != 3*32*32 {
+		t.Fatalf("got a %dx%d framebuffer of %d values, want 32x32", f.Width, f.Height, len(f.Pix))
+	}
+	// the light of the cornell box is 15 times brighter than white, which 8-bit images clip
+	brightest := 0.0
+	for y := 0; y < f.Height; y++ {
+		for x := 0; x < f.Width; x++ {
+			p := f.Pixel(x, y)
+			brightest = math.Max(brightest, math.Max(p.X, math.Max(p.Y, p.Z)))
+		}
+	}
+	if brightest <= 1 {
+		t.Errorf("got a brightest value of %v, want the light above 1", brightest)
+	}
+}
@@ -0,0 +1,223 @@
+package gotrace
+
+import "math"
+
+/*
+LightGeometry is a geometry whose points can be sampled as seen from another point. Actors of such a geometry with a
+DiffuseLight material are lights of the scene, which are sampled at each bounce rather than waiting for scattered rays
+to hit them, which removes most of the noise of small lights.
+*/
+type LightGeometry interface {
+	Geometry
+	// SampleLight returns a point of the geometry chosen from two values of [0, 1), and the probability density of the
+	// direction from origin to this point, per unit solid angle. A zero density means that no point can be chosen.
+	SampleLight(origin Vec3, u, v float64) (point Vec3, pdf float64)
+	// LightPdf returns the density of SampleLight choosing the point of the geometry from origin, per unit solid angle
+	LightPdf(origin, point Vec3) float64
+}
+
+// SampleLight implements the LightGeometry interface, choosing points in the cone of the directions hitting the sphere
+func (s Sphere) SampleLight(origin Vec3, u, v float64) (Vec3, float64) {
+	radius := math.Abs(s.Radius)
+	toCenter := s.Center.Sub(origin)
+	d2 := toCenter.SquareNorm()
+	if d2 <= radius*radius {
+		// the whole sphere is seen from inside, points are chosen uniformly on its surface
+		point := s.Center.Add(SampleSphere(u, v).Scale(radius))
+		return point, areaToSolidAngle(origin, point, point.Sub(s.Center), 4*math.Pi*radius*radius)
+	}
+	// 1 - cos(thetaMax), written so that it doesn't vanish for small or distant spheres
+	sin2Max := radius * radius / d2
+	oneMinusCosMax := sin2Max / (1 + math.Sqrt(1-sin2Max))
+	cosTheta := 1 - u*oneMinusCosMax
+	sinTheta := math.Sqrt(math.Max(0, 1-cosTheta*cosTheta))
+	phi := 2 * math.Pi * v
+
+	w := toCenter.Div(math.Sqrt(d2))
+	a, b := basis(w)
+	direction := a.Scale(sinTheta * math.Cos(phi)).Add(b.Scale(sinTheta * math.Sin(phi))).Add(w.Scale(cosTheta))
+	// closest intersection of the direction with the sphere
+	d := math.Sqrt(d2)
+	t := d*cosTheta - math.Sqrt(math.Max(0, radius*radius-d2*sinTheta*sinTheta))
+	return origin.Add(direction.Scale(t)), 1 / (2 * math.Pi * oneMinusCosMax)
+}
+
+// LightPdf implements the LightGeometry interface
+func (s Sphere) LightPdf(origin, point Vec3) float64 {
+	radius := math.Abs(s.Radius)
+	d2 := s.Center.Sub(origin).SquareNorm()
+	if d2 <= radius*radius {
+		return areaToSolidAngle(origin, point, point.Sub(s.Center), 4*math.Pi*radius*radius)
+	}
+	sin2Max := radius * radius / d2
+	return 1 / (2 * math.Pi * sin2Max / (1 + math.Sqrt(1-sin2Max)))
+}
+
+// SampleLight implements the LightGeometry interface, choosing points uniformly on the rectangle
+func (r RectXY) SampleLight(origin Vec3, u, v float64) (Vec3, float64) {
+	point := Vec3{r.x0 + u*(r.x1-r.x0), r.y0 + v*(r.y1-r.y0), r.k}
+	return point, areaToSolidAngle(origin, point, Vec3{Z: 1}, (r.x1-r.x0)*(r.y1-r.y0))
+}
+
+// LightPdf implements the LightGeometry interface
+func (r RectXY) LightPdf(origin, point Vec3) float64 {
+	return areaToSolidAngle(origin, point, Vec3{Z: 1}, (r.x1-r.x0)*(r.y1-r.y0))
+}
+
+// SampleLight implements the LightGeometry interface, choosing points uniformly on the rectangle
+func (r RectXZ) SampleLight(origin Vec3, u, v float64) (Vec3, float64) {
+	point := Vec3{r.x0 + u*(r.x1-r.x0), r.k, r.z0 + v*(r.z1-r.z0)}
+	return point, areaToSolidAngle(origin, point, Vec3{Y: 1}, (r.x1-r.x0)*(r.z1-r.z0))
+}
+
+// LightPdf implements the LightGeometry interface
+func (r RectXZ) LightPdf(origin, point Vec3) float64 {
+	return areaToSolidAngle(origin, point, Vec3{Y: 1}, (r.x1-r.x0)*(r.z1-r.z0))
+}
+
+// SampleLight implements the LightGeometry interface, choosing points uniformly on the rectangle
+func (r RectYZ) SampleLight(origin Vec3, u, v float64) (Vec3, float64) {
+	point := Vec3{r.k, r.y0 + u*(r.y1-r.y0), r.z0 + v*(r.z1-r.z0)}
+	return point, areaToSolidAngle(origin, point, Vec3{X: 1}, (r.y1-r.y0)*(r.z1-r.z0))
+}
+
+// LightPdf implements the LightGeometry interface
+func (r RectYZ) LightPdf(origin, point Vec3) float64 {
+	return areaToSolidAngle(origin, point, Vec3{X: 1}, (r.y1-r.y0)*(r.z1-r.z0))
+}
+
+// SampleLight implements the LightGeometry interface if the flipped geometry does, lights being seen from both sides
+func (f FlipFace) SampleLight(origin Vec3, u, v float64) (Vec3, float64) {
+	if light, ok := f.reversed.(LightGeometry); ok {
+		return light.SampleLight(origin, u, v)
+	}
+	return Vec3{}, 0
+}
+
+// LightPdf implements the LightGeometry interface if the flipped geometry does
+func (f FlipFace) LightPdf(origin, point Vec3) float64 {
+	if light, ok := f.reversed.(LightGeometry); ok {
+		return light.LightPdf(origin, point)
+	}
+	return 0
+}
+
+// SampleLight implements the LightGeometry interface if the translated geometry does
+func (t Translate) SampleLight(origin Vec3, u, v float64) (Vec3, float64) {
+	if light, ok := t.shape.(LightGeometry); ok {
+		point, pdf := light.SampleLight(origin.Sub(t.offset), u, v)
+		return point.Add(t.offset), pdf
+	}
+	return Vec3{}, 0
+}
+
+// LightPdf implements the LightGeometry interface if the translated geometry does
+func (t Translate) LightPdf(origin, point Vec3) float64 {
+	if light, ok := t.shape.(LightGeometry); ok {
+		return light.LightPdf(origin.Sub(t.offset), point.Sub(t.offset))
+	}
+	return 0
+}
+
+// areaToSolidAngle converts the density of a point chosen uniformly on a surface of the given area and normal to the
+// density of the direction from origin to the point
+func areaToSolidAngle(origin, point, normal Vec3, area float64) float64 {
+	direction := point.Sub(origin)
+	d2 := direction.SquareNorm()
+	cosine := math.Abs(direction.Dot(normal)) / math.Sqrt(d2*normal.SquareNorm())
+	// the cosine is NaN for a point at the origin
+	if !(cosine > 0) || area == 0 {
+		return 0
+	}
+	return d2 / (cosine * area)
+}
+
+// basis returns two vectors forming an orthonormal basis with the unit vector w
+func basis(w Vec3) (Vec3, Vec3) {
+	a := Vec3{X: 1}
+	if math.Abs(w.X) > 0.9 {
+		a = Vec3{Y: 1}
+	}
+	v := w.Cross(a).Unit()
+	return w.Cross(v), v
+}
+
+// canSampleLight returns true if points of the geometry can be sampled, which transformations can only do if the geometry
+// they transform can
+func canSampleLight(g Geometry) bool {
+	switch g := g.(type) {
+	case FlipFace:
+		return canSampleLight(g.reversed)
+	case Translate:
+		return canSampleLight(g.shape)
+	}
+	_, ok := g.(LightGeometry)
+	return ok
+}
+
+// lights returns the actors sampled as lights, and whether each actor is one of them, by ID
+func lights(objects Collection) ([]Actor, []bool) {
+	var lights []Actor
+	isLight := make([]bool, len(objects)+1)
+	for _, actor := range objects {
+		if _, ok := actor.material.(DiffuseLight); !ok {
+			continue
+		}
+		if canSampleLight(actor.shape) {
+			lights = append(lights, actor)
+			isLight[actor.id] = true
+		}
+	}
+	return lights, isLight
+}
+
+/*
+sampleLight returns the light reaching the hit of a ray directly from a point of a light, chosen at random along with the
+light, and scattered along the ray by the BSDF of the hit. A shadow ray checks that the point is visible from the hit, its
+hit being stored into shadowRecord.
+
+The light received from a direction of density pdf is weighted by Eval / pdf of the BSDF. As the scattered ray can also
+hit the light, both estimates are combined by multiple importance sampling: each one is weighted by the power heuristic
+of its density against the density of the other way of finding the same direction.
+*/
+func (s *Scene) sampleLight(ray Ray, record *HitRecord, bsdf BSDF, shadowRecord *HitRecord, rays *int64) Vec3 {
+	n := len(s.lights)
+	light := s.lights[int(math.Min(ray.Sampler.Get1D()*float64(n), float64(n-1)))]
+	u, v := ray.Sampler.Get2D()
+	point, pdf := light.shape.(LightGeometry).SampleLight(record.Position, u, v)
+	if pdf <= 0 || point == record.Position {
+		return BLACK
+	}
+	wo := ray.Direction.Unit().Neg()
+	direction := point.Sub(record.Position).Unit()
+	f := bsdf.Eval(wo, direction)
+	if f == BLACK {
+		// the material doesn't scatter the light coming from this direction, no need to cast the shadow ray
+		return BLACK
+	}
+	*rays++
+	shadow := Ray{record.Position, direction, ray.Time, ray.Sampler}
+	// the point is visible if the first actor hit by the shadow ray is the light
+	if !s.world.hitRecord(shadow, 0.001, math.MaxFloat64, shadowRecord) || shadowRecord.ActorID != light.id {
+		return BLACK
+	}
+	emitted := light.material.Emit(shadowRecord.U, shadowRecord.V, shadowRecord.Position)
+	// the light is chosen with probability 1/n
+	pdf /= float64(n)
+	weight := powerHeuristic(pdf, bsdf.Pdf(wo, direction))
+	return f.Mul(emitted).Scale(weight / pdf)
+}
+
+// lightPdf returns the density of sampleLight choosing the point of the light hit by a ray, per unit solid angle
+func (s *Scene) lightPdf(ray Ray, record *HitRecord) float64 {
+	light := s.objects[record.ActorID-1].shape.(LightGeometry)
+	return light.LightPdf(ray.Origin, record.Position) / float64(len(s.lights))
+}
+
+// powerHeuristic is the weight of an estimate of density f, combined with an estimate of density g
+func powerHeuristic(f, g float64) float64 {
+	if f == 0 {
+		return 0
+	}
+	return f * f / (f*f + g*g)
+}
@@ -0,0 +1,29 @@
+package gotrace
+
+import "testing"
+
+func TestLights(t *testing.T) {
+	light := DiffuseLight{ConstantTexture{WHITE}}
+	tests := []struct {
+		name  string
+		shape Geometry
+		want  bool
+	}{
+		{"rectangle", RectXZ{0, 1, 0, 1, 1}, true},
+		{"sphere", Sphere{Vec3{}, 1}, true},
+		{"flipped rectangle", FlipFace{RectXZ{0, 1, 0, 1, 1}}, true},
+		{"translated flipped sphere", Translate{FlipFace{Sphere{Vec3{}, 1}}, Vec3{1, 2, 3}}, true},
+		// boxes can't be sampled, so their wrappers aren't lights even though they implement LightGeometry
+		{"box", NewBox(Vec3{}, Vec3{1, 1, 1}), false},
+		{"flipped box", FlipFace{NewBox(Vec3{}, Vec3{1, 1, 1})}, false},
+		{"translated box", Translate{NewBox(Vec3{}, Vec3{1, 1, 1}), Vec3{1, 0, 0}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scene := NewScene(Camera{}, Collection{{shape: test.shape, material: light}}, BLACK)
+			if got := len(scene.lights) == 1 && scene.isLight[1]; got != test.want {
+				t.Errorf("got light %v, want %v", got, test.want)
+			}
+		})
+	}
+}
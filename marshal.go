@@ -0,0 +1,266 @@
+package gotrace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// object is a JSON object. Its keys are sorted when encoded, which keeps the output stable.
+type object map[string]interface{}
+
+/*
+MarshalScene returns a JSON description of the scene.
+
+The description uses the same schema as scene files, so it can be read back by LoadScene or UnmarshalScene.
+Actors are written in the order they were given to NewScene, and numbers are written with as many digits as
+needed to be parsed back to the same value, so marshalling the scene read from a description gives the same description.
+*/
+func MarshalScene(s *Scene) ([]byte, error) {
+	objects := make([]object, len(s.objects))
+	for i, actor := range s.objects {
+		shape, err := marshalShape(actor.shape)
+		if err != nil {
+			return nil, err
+		}
+		material, err := marshalMaterial(actor.material)
+		if err != nil {
+			return nil, err
+		}
+		shape["material"] = material
+		objects[i] = shape
+	}
+
+	c := s.camera
+	doc := object{
+		"camera": object{
+			"look_from":      vec(c.origin),
+			"look_at":        vec(c.lookAt),
+			"up":             vec(c.up),
+			"fov":            c.fov,
+			"aspect_ratio":   c.AspectRatio,
+			"focus_distance": c.focusDist,
+			"aperture":       c.aperture,
+			"start":          c.tStart,
+			"end":            c.tStop,
+		},
+		"background": vec(s.background),
+		"objects":    objects,
+	}
+	if s.toneMapper != nil {
+		toneMapper, err := marshalToneMapper(s.toneMapper)
+		if err != nil {
+			return nil, err
+		}
+		doc["tone_mapping"] = toneMapper
+	}
+	if s.width > 0 {
+		image := object{"width": s.width}
+		if s.height > 0 {
+			image["height"] = s.height
+		}
+		doc["image"] = image
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// UnmarshalScene builds the scene described by a JSON document, as produced by MarshalScene
+func UnmarshalScene(data []byte) (*Scene, error) {
+	// JSON documents are valid YAML documents
+	return LoadScene(bytes.NewReader(data))
+}
+
+func vec(v Vec3) [3]float64 {
+	return [3]float64{v.X, v.Y, v.Z}
+}
+
+func vecs(v []Vec3) [][3]float64 {
+	coords := make([][3]float64, len(v))
+	for i := range v {
+		coords[i] = vec(v[i])
+	}
+	return coords
+}
+
+// addVertexAttributes adds the normals and texture coordinates of the vertices of triangles, if they are given
+func addVertexAttributes(o object, normals, uvs []Vec3) {
+	if normals != nil {
+		o["normals"] = vecs(normals)
+	}
+	if uvs != nil {
+		coords := make([][2]float64, len(uvs))
+		for i, uv := range uvs {
+			coords[i] = [2]float64{uv.X, uv.Y}
+		}
+		o["uvs"] = coords
+	}
+}
+
+func marshalShape(shape Geometry) (object, error) {
+	switch s := shape.(type) {
+	case Sphere:
+		return object{"sphere": object{
+			"center": vec(s.Center),
+			"radius": s.Radius,
+		}}, nil
+	case MovingSphere:
+		return object{"moving_sphere": object{
+			"center_start": vec(s.CenterStart),
+			"center_stop":  vec(s.CenterStop),
+			"radius":       s.Radius,
+			"start":        s.tStart,
+			"stop":         s.tStop,
+		}}, nil
+	case RectXY:
+		return object{"rect_xy": object{"x0": s.x0, "x1": s.x1, "y0": s.y0, "y1": s.y1, "k": s.k}}, nil
+	case RectXZ:
+		return object{"rect_xz": object{"x0": s.x0, "x1": s.x1, "z0": s.z0, "z1": s.z1, "k": s.k}}, nil
+	case RectYZ:
+		return object{"rect_yz": object{"y0": s.y0, "y1": s.y1, "z0": s.z0, "z1": s.z1, "k": s.k}}, nil
+	case Box:
+		return object{"box": object{
+			"min": vec(s.minPoint),
+			"max": vec(s.maxPoint),
+		}}, nil
+	case Triangle:
+		triangle := object{"vertices": vecs(s.Vertices[:])}
+		addVertexAttributes(triangle, s.Normals, s.UVs)
+		return object{"triangle": triangle}, nil
+	case *Mesh:
+		mesh := object{
+			"vertices": vecs(s.vertices),
+			"indices":  s.indices,
+		}
+		addVertexAttributes(mesh, s.normals, s.uvs)
+		if s.colors != nil {
+			mesh["colors"] = vecs(s.colors)
+		}
+		return object{"mesh": mesh}, nil
+	case FlipFace:
+		reversed, err := marshalShape(s.reversed)
+		if err != nil {
+			return nil, err
+		}
+		return object{"flip_face": reversed}, nil
+	case Translate:
+		wrapped, err := marshalShape(s.shape)
+		if err != nil {
+			return nil, err
+		}
+		return object{"translate": object{
+			"offset": vec(s.offset),
+			"shape":  wrapped,
+		}}, nil
+	case RotateY:
+		wrapped, err := marshalShape(s.shape)
+		if err != nil {
+			return nil, err
+		}
+		return object{"rotate_y": object{
+			"angle": s.angle,
+			"shape": wrapped,
+		}}, nil
+	case Fog:
+		boundary, err := marshalShape(s.boundary)
+		if err != nil {
+			return nil, err
+		}
+		return object{"fog": object{
+			"density":  s.density,
+			"boundary": boundary,
+		}}, nil
+	}
+	return nil, fmt.Errorf("cannot marshal shape of type %T", shape)
+}
+
+func marshalMaterial(material Material) (object, error) {
+	switch m := material.(type) {
+	case Lambertian:
+		albedo, err := marshalTexture(m.albedo)
+		if err != nil {
+			return nil, err
+		}
+		return object{"lambertian": object{"albedo": albedo}}, nil
+	case Metal:
+		return object{"metal": object{
+			"albedo": vec(m.albedo),
+			"fuzz":   m.fuzz,
+		}}, nil
+	case Dielectric:
+		return object{"dielectric": object{"index": m.n}}, nil
+	case DiffuseLight:
+		emit, err := marshalTexture(m.emit)
+		if err != nil {
+			return nil, err
+		}
+		return object{"diffuse_light": object{"emit": emit}}, nil
+	case Isotropic:
+		albedo, err := marshalTexture(m.albedo)
+		if err != nil {
+			return nil, err
+		}
+		return object{"isotropic": object{"albedo": albedo}}, nil
+	}
+	return nil, fmt.Errorf("cannot marshal material of type %T", material)
+}
+
+func marshalTexture(texture Texture) (object, error) {
+	switch t := texture.(type) {
+	case ConstantTexture:
+		return object{"constant": vec(t.color)}, nil
+	case CheckerTexture:
+		odd, err := marshalTexture(t.odd)
+		if err != nil {
+			return nil, err
+		}
+		even, err := marshalTexture(t.even)
+		if err != nil {
+			return nil, err
+		}
+		return object{"checker": object{
+			"odd":       odd,
+			"even":      even,
+			"frequency": t.freq,
+		}}, nil
+	case Noise:
+		return object{"noise": object{
+			"seed":      t.seed,
+			"frequency": t.frequency,
+		}}, nil
+	case Marble:
+		return object{"marble": object{
+			"seed":       t.seed,
+			"depth":      t.depth,
+			"turbulence": t.turbulence,
+			"scale":      t.scale,
+		}}, nil
+	case VertexColors:
+		return object{"vertex_colors": object{}}, nil
+	case Image:
+		return object{"image": object{
+			"file":     t.file,
+			"x_offset": t.xoffset,
+			"y_offset": t.yoffset,
+		}}, nil
+	}
+	return nil, fmt.Errorf("cannot marshal texture of type %T", texture)
+}
+
+func marshalToneMapper(m ToneMapper) (object, error) {
+	switch m := m.(type) {
+	case SRGB:
+		return object{"srgb": object{"exposure": m.Exposure}}, nil
+	case Reinhard:
+		return object{"reinhard": object{"exposure": m.Exposure, "white": m.White}}, nil
+	case ACES:
+		return object{"aces": object{"exposure": m.Exposure}}, nil
+	case Hable:
+		return object{"hable": object{"exposure": m.Exposure, "white": m.White}}, nil
+	}
+	return nil, fmt.Errorf("cannot marshal tone mapper of type %T", m)
+}
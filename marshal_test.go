@@ -0,0 +1,80 @@
+package gotrace
+
+import (
+	"bytes"
+	_ "image/jpeg"
+	_ "image/png"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMarshalSceneRoundTrip(t *testing.T) {
+	names := make([]string, 0, len(Scenes))
+	for name := range Scenes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			scene := Scenes[name]()
+			data, err := MarshalScene(scene)
+			if err != nil {
+				t.Fatal(err)
+			}
+			read, err := UnmarshalScene(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := MarshalScene(read)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, again) {
+				t.Fatalf("the description of the scene read back differs from the written one:\n%s", firstDifference(data, again))
+			}
+			// the scene read back has the same actors, which are hit the same way
+			if len(read.objects) != len(scene.objects) || len(read.lights) != len(scene.lights) {
+				t.Errorf("got %d objects and %d lights, want %d and %d", len(read.objects), len(read.lights), len(scene.objects), len(scene.lights))
+			}
+			if !reflect.DeepEqual(read.camera, scene.camera) {
+				t.Errorf("got camera %+v, want %+v", read.camera, scene.camera)
+			}
+		})
+	}
+}
+
+// firstDifference returns the first line which differs between two descriptions
+func firstDifference(a, b []byte) string {
+	linesA, linesB := strings.Split(string(a), "\n"), strings.Split(string(b), "\n")
+	for i := range linesA {
+		if i >= len(linesB) || linesA[i] != linesB[i] {
+			if i >= len(linesB) {
+				return linesA[i] + "\n(missing)"
+			}
+			return linesA[i] + "\n" + linesB[i]
+		}
+	}
+	return "(missing)\n" + linesB[len(linesA)]
+}
+
+func TestLoadSceneMetalFuzz(t *testing.T) {
+	metal := func(fuzz string) string {
+		return strings.Replace(sceneSphere, "lambertian:\n        albedo: [0.5, 0.5, 0.5]", "metal: {albedo: [1, 1, 1], fuzz: "+fuzz+"}", 1)
+	}
+	// the fuzz is kept exactly, even above 1 as the metal sphere of the final scene
+	for _, fuzz := range []float64{0, 0.3, 10} {
+		scene, err := LoadScene(strings.NewReader(metal(strconv.FormatFloat(fuzz, 'g', -1, 64))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := scene.objects[0].material.(Metal).fuzz; got != fuzz {
+			t.Errorf("got fuzz %v for a fuzz of %v in the scene file, want it kept", got, fuzz)
+		}
+	}
+	if _, err := LoadScene(strings.NewReader(metal("-1"))); err == nil || !strings.Contains(err.Error(), "fuzz must not be negative") {
+		t.Errorf("got error %v for a negative fuzz, want fuzz must not be negative", err)
+	}
+}
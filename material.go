@@ -21,6 +21,9 @@ Scatter
 	bool : true if the material scatters the ray
 	Vec3 : the attenuation of the scattered ray
 	Ray : the scattered ray
+
+The materials of the package also implement BSDFMaterial, their Scatter sampling their BSDF. Materials only
+implementing Scatter are still rendered, but lights are then only found by their scattered rays.
 */
 type Material interface {
 	Scatter(ray Ray, hit HitRecord) (bool, Vec3, Ray)
@@ -32,12 +35,24 @@ type Lambertian struct {
 	albedo Texture
 }
 
+// NewLambertian returns a lambertian material from its albedo
+func NewLambertian(albedo Texture) Lambertian {
+	return Lambertian{albedo}
+}
+
 // Scatter defines how a lambertian material scatters a Ray
 func (l Lambertian) Scatter(ray Ray, hit HitRecord) (bool, Vec3, Ray) {
-	scatterDirection := hit.Normal.Add(RandSphere(ray.RandSource))
-	scattered := Ray{hit.Position, scatterDirection, ray.Time, ray.RandSource}
-	attenuation := l.albedo.Value(hit.U, hit.V, hit.Position)
-	return true, attenuation, scattered
+	return scatter(l.BSDF(hit), ray, hit)
+}
+
+// BSDF of a lambertian material reflects light uniformly
+func (l Lambertian) BSDF(hit HitRecord) BSDF {
+	return l.bsdf(hit)
+}
+
+// bsdf returns the BSDF of the material at the hit, without converting it to the BSDF interface
+func (l Lambertian) bsdf(hit HitRecord) lambertianBSDF {
+	return lambertianBSDF{hit.Normal, textureValue(l.albedo, hit)}
 }
 
 // Emit defines how a Lambertian emits light (it doesn't)
@@ -61,12 +76,17 @@ func NewMetal(albedo Vec3, fuzz float64) Metal {
 
 // Scatter defines the behaviour of rays when they hit Metal material
 func (m Metal) Scatter(ray Ray, record HitRecord) (bool, Vec3, Ray) {
-	reflectedDirection := ray.Direction.Unit().Reflect(record.Normal)
-	fuzziness := RandSphere(ray.RandSource).Scale(m.fuzz)
-	scattered := Ray{record.Position, reflectedDirection.Add(fuzziness), ray.Time, ray.RandSource}
-	attenuation := m.albedo
-	scatters := scattered.Direction.Dot(record.Normal) > 0
-	return scatters, attenuation, scattered
+	return scatter(m.BSDF(record), ray, record)
+}
+
+// BSDF of a metal reflects light around the mirror direction, in a single direction if it isn't fuzzy
+func (m Metal) BSDF(hit HitRecord) BSDF {
+	return m.bsdf(hit)
+}
+
+// bsdf returns the BSDF of the material at the hit, without converting it to the BSDF interface
+func (m Metal) bsdf(hit HitRecord) metalBSDF {
+	return metalBSDF{hit.Normal, m.albedo, m.fuzz}
 }
 
 // Emit defines how a Metal emits light (it doesn't)
@@ -79,6 +99,11 @@ type Dielectric struct {
 	n float64 // refraction index
 }
 
+// NewDielectric returns a dielectric material from its refraction index
+func NewDielectric(n float64) Dielectric {
+	return Dielectric{n}
+}
+
 func shlick(cosine float64, nRatio float64) float64 {
 	r0 := math.Pow((1-nRatio)/(1+nRatio), 2)
 	return r0 + (1-r0)*math.Pow(1-cosine, 5)
@@ -86,35 +111,17 @@ func shlick(cosine float64, nRatio float64) float64 {
 
 // Scatter defines the behaviour of rays when they hit Metal material
 func (d Dielectric) Scatter(ray Ray, hit HitRecord) (bool, Vec3, Ray) {
-	var (
-		outNormal Vec3
-		nRatio    float64
-		cosTheta  float64
-	)
-	dot := ray.Direction.Dot(hit.Normal)
-	if dot > 0 {
-		// ray escapes the material
-		outNormal = hit.Normal.Neg()
-		nRatio = d.n
-		cosTheta = dot / ray.Direction.Norm()
-		cosTheta = math.Sqrt(1.0 - d.n*d.n*(1.0-cosTheta*cosTheta))
-	} else {
-		// ray enters the material
-		outNormal = hit.Normal
-		nRatio = 1.0 / d.n
-		cosTheta = -dot / ray.Direction.Norm()
-	}
-	incidentDirection := ray.Direction.Unit()
-	wasRefracted, refracted := incidentDirection.Refract(outNormal, nRatio)
-	var direction Vec3
-	if wasRefracted && ray.RandSource.Float64() >= shlick(cosTheta, nRatio) {
-		// refraction possible + shlick probability
-		direction = refracted
-	} else {
-		// reflection
-		direction = incidentDirection.Reflect(outNormal)
-	}
-	return true, WHITE, Ray{hit.Position, direction, ray.Time, ray.RandSource}
+	return scatter(d.BSDF(hit), ray, hit)
+}
+
+// BSDF of a dielectric reflects or refracts light in a single direction
+func (d Dielectric) BSDF(hit HitRecord) BSDF {
+	return d.bsdf(hit)
+}
+
+// bsdf returns the BSDF of the material at the hit, without converting it to the BSDF interface
+func (d Dielectric) bsdf(hit HitRecord) dielectricBSDF {
+	return dielectricBSDF{hit.Normal, d.n}
 }
 
 // Emit defines how a lambertian emits light (it doesn't)
@@ -127,11 +134,21 @@ type DiffuseLight struct {
 	emit Texture
 }
 
+// NewDiffuseLight returns a light-emitting material from the texture of its emitted light
+func NewDiffuseLight(emit Texture) DiffuseLight {
+	return DiffuseLight{emit}
+}
+
 // Scatter implements the scatter interface for a DiffuseLight material
 func (l DiffuseLight) Scatter(ray Ray, hit HitRecord) (bool, Vec3, Ray) {
 	return false, Vec3{}, Ray{}
 }
 
+// BSDF of a DiffuseLight is nil, as it doesn't scatter light
+func (l DiffuseLight) BSDF(hit HitRecord) BSDF {
+	return nil
+}
+
 // Emit implements the emit interface for a DiffuseLight material
 func (l DiffuseLight) Emit(u, v float64, pos Vec3) Vec3 {
 	return l.emit.Value(u, v, pos)
@@ -144,9 +161,17 @@ type Isotropic struct {
 
 // Scatter of isotropic material scatters a new ray in a random direction at the hit
 func (i Isotropic) Scatter(ray Ray, hit HitRecord) (bool, Vec3, Ray) {
-	return true,
-		i.albedo.Value(hit.U, hit.V, hit.Position),
-		Ray{hit.Position, RandSphere(ray.RandSource), ray.Time, ray.RandSource}
+	return scatter(i.BSDF(hit), ray, hit)
+}
+
+// BSDF of an isotropic material is its phase function, scattering light uniformly in all directions
+func (i Isotropic) BSDF(hit HitRecord) BSDF {
+	return i.bsdf(hit)
+}
+
+// bsdf returns the BSDF of the material at the hit, without converting it to the BSDF interface
+func (i Isotropic) bsdf(hit HitRecord) isotropicBSDF {
+	return isotropicBSDF{textureValue(i.albedo, hit)}
 }
 
 // Emit defines how an isotropic material doesn't emit light
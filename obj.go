@@ -0,0 +1,563 @@
+package gotrace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+LoadOBJ reads a Wavefront OBJ file, and the MTL material libraries it references, into a collection of actors which can be
+added to any scene.
+
+Vertex positions (v), texture coordinates (vt) and normals (vn) are referenced by faces (f) with indices from 1, negative
+indices counting back from the last vertex read. Faces with more than three vertices are triangulated. The faces of each
+group (g or o) using the same material (usemtl) form a Mesh, whose vertices only have normals and texture coordinates if
+all of its faces give them. Lines, points and free-form geometry are ignored.
+
+Materials are mapped from their MTL parameters to the materials of the package:
+
+	Ke            emitting materials are a DiffuseLight
+	Ni, d, Tr     transparent materials, or of illumination model 4, 6, 7 or 9, are a Dielectric of refraction index Ni
+	Ks, Ns        materials whose specular color is brighter than their diffuse color, or of illumination model 3, are a
+	              Metal, whose fuzz decreases with the specular exponent Ns
+	Kd, map_Kd    other materials are Lambertian, of the map_Kd image texture if given
+
+Faces without a material are Lambertian of a light grey. Paths of material libraries and textures are relative to the
+file referencing them.
+*/
+func LoadOBJ(file string) (Collection, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	o := objDecoder{
+		file:      file,
+		materials: map[string]*mtlMaterial{},
+		used:      map[string]Material{},
+		textures:  map[string]Texture{},
+		meshes:    map[objMeshKey]*objMesh{},
+	}
+	if err := readStatements(f, file, o.decodeStatement); err != nil {
+		return nil, err
+	}
+
+	objects := Collection{}
+	for _, key := range o.order {
+		m := o.meshes[key]
+		mesh, err := m.build()
+		if err != nil {
+			return nil, &OBJError{File: file, Line: m.line, Msg: err.Error()}
+		}
+		objects.Add(Actor{shape: mesh, material: m.material})
+	}
+	return objects, nil
+}
+
+// OBJError is an error in an OBJ or MTL file, located by its line number
+type OBJError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *OBJError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// readStatements calls decode with the keyword and the arguments of each statement of an OBJ or MTL file, comments and
+// blank lines being skipped, and lines ending with a backslash being continued on the next one
+func readStatements(r io.Reader, file string, decode func(keyword string, args []string, line int) error) error {
+	scanner := bufio.NewScanner(r)
+	// lines of huge polygons can be longer than the default limit
+	scanner.Buffer(nil, 1<<24)
+	var statement string
+	line, start := 0, 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if statement == "" {
+			start = line
+		}
+		if strings.HasSuffix(text, "\\") {
+			statement += text[:len(text)-1] + " "
+			continue
+		}
+		statement += text
+		if i := strings.IndexByte(statement, '#'); i >= 0 {
+			statement = statement[:i]
+		}
+		fields := strings.Fields(statement)
+		statement = ""
+		if len(fields) == 0 {
+			continue
+		}
+		if err := decode(fields[0], fields[1:], start); err != nil {
+			if _, ok := err.(*OBJError); ok {
+				return err
+			}
+			return &OBJError{File: file, Line: start, Msg: err.Error()}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	return nil
+}
+
+// objDecoder holds the state needed while reading an OBJ file
+type objDecoder struct {
+	file string
+	// vertex attributes, indexed by the faces
+	positions, uvs, normals []Vec3
+	// materials of the libraries, and the ones already converted to materials of the package
+	materials map[string]*mtlMaterial
+	used      map[string]Material
+	textures  map[string]Texture
+	// current group and material name
+	group, material string
+	// meshes by group and material, in the order of their first face
+	meshes map[objMeshKey]*objMesh
+	order  []objMeshKey
+}
+
+// objMeshKey identifies the mesh of the faces of a group having the same material
+type objMeshKey struct {
+	group, material string
+}
+
+func (o *objDecoder) decodeStatement(keyword string, args []string, line int) error {
+	switch keyword {
+	case "v":
+		v, err := parseFloats(args, 3, 4)
+		if err != nil {
+			return err
+		}
+		o.positions = append(o.positions, Vec3{v[0], v[1], v[2]})
+
+	case "vt":
+		v, err := parseFloats(args, 1, 3)
+		if err != nil {
+			return err
+		}
+		uv := Vec3{X: v[0]}
+		if len(v) > 1 {
+			uv.Y = v[1]
+		}
+		o.uvs = append(o.uvs, uv)
+
+	case "vn":
+		v, err := parseFloats(args, 3, 3)
+		if err != nil {
+			return err
+		}
+		o.normals = append(o.normals, Vec3{v[0], v[1], v[2]})
+
+	case "f":
+		return o.decodeFace(args, line)
+
+	case "g", "o":
+		o.group = strings.Join(args, " ")
+
+	case "usemtl":
+		if len(args) != 1 {
+			return fmt.Errorf("usemtl expects a material name")
+		}
+		o.material = args[0]
+
+	case "mtllib":
+		if len(args) == 0 {
+			return fmt.Errorf("mtllib expects file names")
+		}
+		for _, name := range args {
+			if err := o.loadMTL(filepath.Join(filepath.Dir(o.file), name)); err != nil {
+				return err
+			}
+		}
+	}
+	// other statements, such as smoothing groups, lines or free-form geometry, don't change the triangles
+	return nil
+}
+
+// decodeFace adds the triangles of a face to the mesh of the current group and material
+func (o *objDecoder) decodeFace(args []string, line int) error {
+	if len(args) < 3 {
+		return fmt.Errorf("a face needs at least three vertices, got %d", len(args))
+	}
+	corners := make([]objCorner, len(args))
+	points := make([]Vec3, len(args))
+	for i, arg := range args {
+		var err error
+		if corners[i], err = o.parseCorner(arg); err != nil {
+			return err
+		}
+		points[i] = o.positions[corners[i].position]
+	}
+
+	key := objMeshKey{o.group, o.material}
+	mesh, ok := o.meshes[key]
+	if !ok {
+		material, err := o.useMaterial(o.material)
+		if err != nil {
+			return err
+		}
+		mesh = &objMesh{material: material, line: line, indices: map[objCorner]int{}}
+		o.meshes[key] = mesh
+		o.order = append(o.order, key)
+	}
+	for _, triangle := range triangulate(points) {
+		mesh.addTriangle(o, corners[triangle[0]], corners[triangle[1]], corners[triangle[2]])
+	}
+	return nil
+}
+
+// objCorner is a vertex of a face, given by the indices of its attributes, -1 meaning that the attribute is missing
+type objCorner struct {
+	position, uv, normal int
+}
+
+// parseCorner parses a vertex of a face, given as v, v/vt, v//vn or v/vt/vn
+func (o *objDecoder) parseCorner(arg string) (objCorner, error) {
+	parts := strings.Split(arg, "/")
+	if len(parts) > 3 {
+		return objCorner{}, fmt.Errorf("invalid face vertex %q", arg)
+	}
+	corner := objCorner{-1, -1, -1}
+	attributes := []struct {
+		index *int
+		count int
+		name  string
+	}{
+		{&corner.position, len(o.positions), "vertex"},
+		{&corner.uv, len(o.uvs), "texture coordinate"},
+		{&corner.normal, len(o.normals), "normal"},
+	}
+	for i, part := range parts {
+		if part == "" {
+			if i == 0 {
+				return objCorner{}, fmt.Errorf("invalid face vertex %q", arg)
+			}
+			continue
+		}
+		index, err := strconv.Atoi(part)
+		if err != nil {
+			return objCorner{}, fmt.Errorf("invalid %s index %q", attributes[i].name, part)
+		}
+		count := attributes[i].count
+		switch {
+		case index == 0:
+			return objCorner{}, fmt.Errorf("%s indices start at 1", attributes[i].name)
+		case index > 0 && index <= count:
+			*attributes[i].index = index - 1
+		case index < 0 && -index <= count:
+			// negative indices count back from the last attribute read
+			*attributes[i].index = count + index
+		default:
+			return objCorner{}, fmt.Errorf("%s index %d out of range, %d read so far", attributes[i].name, index, count)
+		}
+	}
+	return corner, nil
+}
+
+// objMesh gathers the triangles of the faces of a group having the same material
+type objMesh struct {
+	material Material
+	// line of the first face, where errors of the whole mesh are reported
+	line int
+	// vertices of the mesh, one for each distinct corner of the faces
+	vertices, normals, uvs []Vec3
+	indices                map[objCorner]int
+	triangles              []int
+	// whether some corners don't have normals or texture coordinates
+	missingNormals, missingUVs bool
+}
+
+// addTriangle adds a triangle to the mesh, corners being shared between the faces which have the same attributes
+func (m *objMesh) addTriangle(o *objDecoder, corners ...objCorner) {
+	for _, corner := range corners {
+		index, ok := m.indices[corner]
+		if !ok {
+			index = len(m.vertices)
+			m.indices[corner] = index
+			m.vertices = append(m.vertices, o.positions[corner.position])
+			var normal, uv Vec3
+			if corner.normal >= 0 {
+				normal = o.normals[corner.normal]
+			} else {
+				m.missingNormals = true
+			}
+			if corner.uv >= 0 {
+				uv = o.uvs[corner.uv]
+			} else {
+				m.missingUVs = true
+			}
+			m.normals = append(m.normals, normal)
+			m.uvs = append(m.uvs, uv)
+		}
+		m.triangles = append(m.triangles, index)
+	}
+}
+
+// build constructs the mesh, without normals or texture coordinates if some of its corners don't have them
+func (m *objMesh) build() (*Mesh, error) {
+	normals, uvs := m.normals, m.uvs
+	if m.missingNormals {
+		normals = nil
+	}
+	if m.missingUVs {
+		uvs = nil
+	}
+	return NewMesh(m.vertices, m.triangles, normals, uvs)
+}
+
+/*
+triangulate splits a polygon into triangles, given by the indices of their vertices in the polygon, by clipping its ears:
+triangles made of consecutive vertices which don't contain any other vertex. This works for concave polygons, which are
+projected on the plane of their largest extent, the triangles being fanned from the first vertex if no ear can be found.
+*/
+func triangulate(points []Vec3) [][3]int {
+	if len(points) == 3 {
+		return [][3]int{{0, 1, 2}}
+	}
+	// normal of the polygon by Newell's method, which is robust for non-planar polygons
+	var normal Vec3
+	for i, p := range points {
+		q := points[(i+1)%len(points)]
+		normal = normal.Add(Vec3{(p.Y - q.Y) * (p.Z + q.Z), (p.Z - q.Z) * (p.X + q.X), (p.X - q.X) * (p.Y + q.Y)})
+	}
+	// 2D coordinates in the plane of the polygon, counter-clockwise seen from the side of the normal
+	xs := make([]float64, len(points))
+	ys := make([]float64, len(points))
+	nx, ny, nz := math.Abs(normal.X), math.Abs(normal.Y), math.Abs(normal.Z)
+	for i, p := range points {
+		switch {
+		case nx >= ny && nx >= nz:
+			xs[i], ys[i] = p.Y*math.Copysign(1, normal.X), p.Z
+		case ny >= nz:
+			xs[i], ys[i] = p.Z*math.Copysign(1, normal.Y), p.X
+		default:
+			xs[i], ys[i] = p.X*math.Copysign(1, normal.Z), p.Y
+		}
+	}
+	cross := func(a, b, c int) float64 {
+		return (xs[b]-xs[a])*(ys[c]-ys[a]) - (ys[b]-ys[a])*(xs[c]-xs[a])
+	}
+
+	remaining := make([]int, len(points))
+	for i := range remaining {
+		remaining[i] = i
+	}
+	triangles := make([][3]int, 0, len(points)-2)
+	for len(remaining) > 3 {
+		n := len(remaining)
+		clipped := false
+		for i := 0; i < n && !clipped; i++ {
+			a, b, c := remaining[(i+n-1)%n], remaining[i], remaining[(i+1)%n]
+			if cross(a, b, c) <= 0 {
+				// reflex or flat vertex
+				continue
+			}
+			ear := true
+			for _, p := range remaining {
+				if p != a && p != b && p != c && cross(a, b, p) >= 0 && cross(b, c, p) >= 0 && cross(c, a, p) >= 0 {
+					ear = false
+					break
+				}
+			}
+			if ear {
+				triangles = append(triangles, [3]int{a, b, c})
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				clipped = true
+			}
+		}
+		if !clipped {
+			// degenerate polygon, fanning keeps all of its vertices
+			for i := 1; i+1 < len(remaining); i++ {
+				triangles = append(triangles, [3]int{remaining[0], remaining[i], remaining[i+1]})
+			}
+			return triangles
+		}
+	}
+	return append(triangles, [3]int{remaining[0], remaining[1], remaining[2]})
+}
+
+// parseFloats parses between min and max numbers
+func parseFloats(args []string, min, max int) ([]float64, error) {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return nil, fmt.Errorf("expected %d numbers, got %d", min, len(args))
+		}
+		return nil, fmt.Errorf("expected %d to %d numbers, got %d", min, max, len(args))
+	}
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		var err error
+		if values[i], err = strconv.ParseFloat(arg, 64); err != nil {
+			return nil, fmt.Errorf("invalid number %q", arg)
+		}
+	}
+	return values, nil
+}
+
+// mtlMaterial holds the parameters of a material of an MTL file
+type mtlMaterial struct {
+	// where the material is defined, for the errors of its texture
+	file string
+	line int
+
+	kd, ks, ke Vec3
+	ns, ni     float64
+	dissolve   float64
+	illum      int
+	mapKd      string
+}
+
+// loadMTL reads the materials of an MTL file
+func (o *objDecoder) loadMTL(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var current *mtlMaterial
+	return readStatements(f, file, func(keyword string, args []string, line int) error {
+		if keyword == "newmtl" {
+			if len(args) != 1 {
+				return fmt.Errorf("newmtl expects a material name")
+			}
+			current = &mtlMaterial{file: file, line: line, kd: Vec3{0.8, 0.8, 0.8}, ni: 1.5, dissolve: 1, illum: 2}
+			o.materials[args[0]] = current
+			return nil
+		}
+		if current == nil {
+			return fmt.Errorf("%s before the first newmtl", keyword)
+		}
+		return current.decodeStatement(keyword, args)
+	})
+}
+
+func (m *mtlMaterial) decodeStatement(keyword string, args []string) error {
+	switch keyword {
+	case "Kd", "Ks", "Ke":
+		v, err := parseFloats(args, 1, 3)
+		if err != nil {
+			return err
+		}
+		var color Vec3
+		switch len(v) {
+		case 1:
+			// a single value is a grey
+			color = Vec3{v[0], v[0], v[0]}
+		case 2:
+			return fmt.Errorf("expected 1 or 3 numbers, got 2")
+		case 3:
+			color = Vec3{v[0], v[1], v[2]}
+		}
+		switch keyword {
+		case "Kd":
+			m.kd = color
+		case "Ks":
+			m.ks = color
+		case "Ke":
+			m.ke = color
+		}
+
+	case "Ns", "Ni", "d", "Tr":
+		v, err := parseFloats(args, 1, 1)
+		if err != nil {
+			return err
+		}
+		switch keyword {
+		case "Ns":
+			m.ns = v[0]
+		case "Ni":
+			if v[0] <= 0 {
+				return fmt.Errorf("refraction index must be positive")
+			}
+			m.ni = v[0]
+		case "d":
+			m.dissolve = v[0]
+		case "Tr":
+			m.dissolve = 1 - v[0]
+		}
+
+	case "illum":
+		if len(args) != 1 {
+			return fmt.Errorf("illum expects an illumination model")
+		}
+		illum, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid illumination model %q", args[0])
+		}
+		m.illum = illum
+
+	case "map_Kd":
+		if len(args) == 0 {
+			return fmt.Errorf("map_Kd expects a file name")
+		}
+		// the file name comes after the options of the texture, which aren't supported
+		m.mapKd = filepath.Join(filepath.Dir(m.file), args[len(args)-1])
+	}
+	// other parameters, such as the ambient color or the other texture maps, have no equivalent
+	return nil
+}
+
+// useMaterial returns the material of the given name, converting it the first time it is used
+func (o *objDecoder) useMaterial(name string) (Material, error) {
+	if material, ok := o.used[name]; ok {
+		return material, nil
+	}
+	var material Material
+	if name == "" {
+		material = Lambertian{ConstantTexture{Vec3{0.8, 0.8, 0.8}}}
+	} else {
+		m, ok := o.materials[name]
+		if !ok {
+			return nil, fmt.Errorf("undefined material %q", name)
+		}
+		var err error
+		if material, err = m.convert(o.textures); err != nil {
+			return nil, &OBJError{File: m.file, Line: m.line, Msg: err.Error()}
+		}
+	}
+	o.used[name] = material
+	return material, nil
+}
+
+// convert maps the material to a material of the package, image textures being shared by path
+func (m *mtlMaterial) convert(textures map[string]Texture) (Material, error) {
+	switch {
+	case m.ke != BLACK:
+		return DiffuseLight{ConstantTexture{m.ke}}, nil
+
+	case m.dissolve < 1 || m.illum == 4 || m.illum == 6 || m.illum == 7 || m.illum == 9:
+		return Dielectric{m.ni}, nil
+
+	case m.ks != BLACK && (m.illum == 3 || m.ks.MaxComponent() > m.kd.MaxComponent()):
+		// Phong exponents go from 0 for rough surfaces to 1000 for mirrors
+		fuzz := math.Min(1, math.Sqrt(2/(math.Max(0, m.ns)+2)))
+		return Metal{m.ks, fuzz}, nil
+	}
+
+	if m.mapKd == "" {
+		return Lambertian{ConstantTexture{m.kd}}, nil
+	}
+	texture, ok := textures[m.mapKd]
+	if !ok {
+		img, err := LoadImage(m.mapKd, 0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("map_Kd: %v", err)
+		}
+		texture = img
+		textures[m.mapKd] = texture
+	}
+	return Lambertian{texture}, nil
+}
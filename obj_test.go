@@ -0,0 +1,315 @@
+package gotrace
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestFile writes the data to a file of the given name in a temporary directory, removed at the end of the test
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "gotrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// writeOBJ writes an OBJ file, and the other files it references, to a temporary directory
+func writeOBJ(t *testing.T, obj string, files map[string][]byte) string {
+	t.Helper()
+	file := writeTestFile(t, "model.obj", []byte(obj))
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(filepath.Dir(file), name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return file
+}
+
+const objModel = `# a textured quad, a concave pentagon and a triangle given by negative indices
+mtllib model.mtl
+
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+vt 0 0
+vt 1 0
+vt 1 1
+vt 0 1
+vn 0 0 1
+
+g quad
+usemtl red
+f 1/1/1 2/2/1 3/3/1 4/4/1
+
+g pentagon
+v 2 0 0
+v 4 0 0
+v 4 2 0
+v 3 1 0
+v 2 2 0
+f 5 6 7 8 9
+
+g quad
+f -9 -8 \
+  -7
+`
+
+const mtlModel = `newmtl red
+Kd 1 0 0
+`
+
+func TestLoadOBJ(t *testing.T) {
+	objects, err := LoadOBJ(writeOBJ(t, objModel, map[string][]byte{"model.mtl": []byte(mtlModel)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the faces of a group with the same material form a mesh, in the order of their first face
+	if len(objects) != 2 {
+		t.Fatalf("got %d objects, want 2", len(objects))
+	}
+	quad, pentagon := objects[0].shape.(*Mesh), objects[1].shape.(*Mesh)
+	if quad.Triangles() != 3 || pentagon.Triangles() != 3 {
+		t.Fatalf("got %d and %d triangles, want 3 and 3", quad.Triangles(), pentagon.Triangles())
+	}
+	red := Lambertian{ConstantTexture{Vec3{1, 0, 0}}}
+	if objects[0].material != red || objects[1].material != red {
+		t.Errorf("got materials %v and %v, want %v", objects[0].material, objects[1].material, red)
+	}
+
+	// the quad keeps its normals and texture coordinates, unless some of its faces don't give them
+	if quad.normals != nil || quad.uvs != nil {
+		t.Error("got normals or texture coordinates for the mesh of faces without them")
+	}
+	// corners having the same attributes are shared by the triangles, the ones of the last face differing by their
+	// missing attributes
+	if len(quad.vertices) != 7 {
+		t.Errorf("got %d vertices, want 7", len(quad.vertices))
+	}
+	if last := quad.Triangle(2); last.Vertices != [3]Vec3{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}} {
+		t.Errorf("got triangle %v for negative indices, want the first three vertices", last.Vertices)
+	}
+
+	// the concave pentagon is covered exactly by its triangles, all facing the same side
+	area := 0.0
+	for i := 0; i < pentagon.Triangles(); i++ {
+		v := pentagon.Triangle(i).Vertices
+		normal := v[1].Sub(v[0]).Cross(v[2].Sub(v[0]))
+		if normal.Z <= 0 {
+			t.Errorf("got triangle %v facing %v, want +z", v, normal)
+		}
+		area += normal.Norm() / 2
+	}
+	if math.Abs(area-3) > 1e-12 {
+		t.Errorf("got triangles of area %v, want the area 3 of the pentagon", area)
+	}
+	if hit, _ := pentagon.Hit(Ray{Origin: Vec3{3, 1.5, 1}, Direction: Vec3{0, 0, -1}}, 0, math.Inf(1)); hit {
+		t.Error("the notch of the pentagon was hit")
+	}
+}
+
+func TestLoadOBJAttributes(t *testing.T) {
+	obj := strings.Replace(objModel, "f -9 -8 \\\n  -7\n", "", 1)
+	objects, err := LoadOBJ(writeOBJ(t, obj, map[string][]byte{"model.mtl": []byte(mtlModel)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	quad := objects[0].shape.(*Mesh)
+	if len(quad.normals) != 4 || len(quad.uvs) != 4 {
+		t.Fatalf("got %d normals and %d texture coordinates, want 4 of each", len(quad.normals), len(quad.uvs))
+	}
+	hit, record := quad.Hit(Ray{Origin: Vec3{0.25, 0.75, 1}, Direction: Vec3{0, 0, -1}}, 0, math.Inf(1))
+	if !hit || record.Normal != (Vec3{0, 0, 1}) || math.Abs(record.U-0.25) > 1e-12 || math.Abs(record.V-0.75) > 1e-12 {
+		t.Errorf("got hit %+v, want the normal and the texture coordinates of the file", record)
+	}
+}
+
+func TestTriangulate(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []Vec3
+		area   float64
+	}{
+		{"triangle", []Vec3{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}}, 0.5},
+		{"square", []Vec3{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}}, 1},
+		{"clockwise square", []Vec3{{0, 1, 0}, {1, 1, 0}, {1, 0, 0}, {0, 0, 0}}, 1},
+		{"L in the yz plane", []Vec3{{0, 0, 0}, {0, 2, 0}, {0, 2, 1}, {0, 1, 1}, {0, 1, 2}, {0, 0, 2}}, 3},
+		{"comb", []Vec3{{0, 0, 0}, {5, 0, 0}, {5, 2, 0}, {4, 2, 0}, {4, 1, 0}, {3, 1, 0}, {3, 2, 0}, {2, 2, 0}, {2, 1, 0}, {1, 1, 0}, {1, 2, 0}, {0, 2, 0}}, 8},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			triangles := triangulate(test.points)
+			if len(triangles) != len(test.points)-2 {
+				t.Fatalf("got %d triangles, want %d", len(triangles), len(test.points)-2)
+			}
+			// the triangles keep the orientation of the polygon, and add up to its area
+			var sum Vec3
+			for _, triangle := range triangles {
+				a, b, c := test.points[triangle[0]], test.points[triangle[1]], test.points[triangle[2]]
+				sum = sum.Add(b.Sub(a).Cross(c.Sub(a)).Div(2))
+			}
+			if math.Abs(sum.Norm()-test.area) > 1e-12 {
+				t.Errorf("got triangles of area %v, want %v", sum.Norm(), test.area)
+			}
+		})
+	}
+}
+
+// objTriangle is an OBJ file of a triangle of the given material of model.mtl
+const objTriangle = `mtllib model.mtl
+v 0 0 0
+v 1 0 0
+v 0 1 0
+usemtl m
+f 1 2 3
+`
+
+func TestLoadOBJMaterials(t *testing.T) {
+	tests := []struct {
+		name string
+		mtl  string
+		want Material
+	}{
+		{"diffuse", "Kd 0.1 0.2 0.3\nKs 0.05", Lambertian{ConstantTexture{Vec3{0.1, 0.2, 0.3}}}},
+		{"default", "", Lambertian{ConstantTexture{Vec3{0.8, 0.8, 0.8}}}},
+		{"grey", "Kd 0.5", Lambertian{ConstantTexture{Vec3{0.5, 0.5, 0.5}}}},
+		{"light", "Kd 0.5\nKe 4 4 2", DiffuseLight{ConstantTexture{Vec3{4, 4, 2}}}},
+		{"dissolved", "d 0.5\nNi 1.3", Dielectric{1.3}},
+		{"transparent", "Tr 0.2", Dielectric{1.5}},
+		{"glass", "illum 7", Dielectric{1.5}},
+		{"mirror", "Kd 0.1\nKs 0.9\nNs 1998", Metal{Vec3{0.9, 0.9, 0.9}, math.Sqrt(0.001)}},
+		{"rough metal", "Ks 0.3\nNs 0\nillum 3", Metal{Vec3{0.3, 0.3, 0.3}, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mtl := "# material of the test\nnewmtl m\n" + test.mtl + "\n"
+			objects, err := LoadOBJ(writeOBJ(t, objTriangle, map[string][]byte{"model.mtl": []byte(mtl)}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := objects[0].material; !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got material %#v, want %#v", got, test.want)
+			}
+		})
+	}
+
+	// faces without a material are light grey
+	objects, err := LoadOBJ(writeOBJ(t, strings.Replace(objTriangle, "usemtl m\n", "", 1), map[string][]byte{"model.mtl": nil}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Lambertian{ConstantTexture{Vec3{0.8, 0.8, 0.8}}}); objects[0].material != want {
+		t.Errorf("got material %v for a face without material, want %v", objects[0].material, want)
+	}
+}
+
+func TestLoadOBJTexture(t *testing.T) {
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewRGBA(image.Rect(0, 0, 2, 3))); err != nil {
+		t.Fatal(err)
+	}
+	// both materials use the same image, which is only loaded once
+	obj := objTriangle + "usemtl n\nf 1 2 3\n"
+	mtl := "newmtl m\nmap_Kd -s 1 1 1 texture.png\nnewmtl n\nKd 1 1 1\nmap_Kd texture.png\n"
+	objects, err := LoadOBJ(writeOBJ(t, obj, map[string][]byte{"model.mtl": []byte(mtl), "texture.png": b.Bytes()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("got %d objects, want 2", len(objects))
+	}
+	m, ok := objects[0].material.(Lambertian).albedo.(Image)
+	if !ok {
+		t.Fatalf("got albedo %v, want an image", objects[0].material.(Lambertian).albedo)
+	}
+	if size := m.data.Bounds().Size(); size != image.Pt(2, 3) {
+		t.Errorf("got a %v image, want 2x3", size)
+	}
+	if n := objects[1].material.(Lambertian).albedo.(Image); n.data != m.data {
+		t.Error("the image was loaded twice")
+	}
+}
+
+func TestLoadOBJSinglePixelTexture(t *testing.T) {
+	// textures of a single color are often images of a single pixel
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := LoadOBJ(writeOBJ(t, objTriangle, map[string][]byte{"model.mtl": []byte("newmtl m\nmap_Kd red.png\n"), "red.png": b.Bytes()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	albedo := objects[0].material.(Lambertian).albedo
+	for _, uv := range [][2]float64{{0, 0}, {0.5, 0.5}, {1, 1}, {-2.5, 3.5}} {
+		if got := albedo.Value(uv[0], uv[1], Vec3{}); got != (Vec3{1, 0, 0}) {
+			t.Errorf("got %v at %v, want red", got, uv)
+		}
+	}
+}
+
+func TestLoadOBJErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  string
+		mtl  string
+		// file and line of the error
+		file string
+		line int
+		want string
+	}{
+		{"missing coordinate", "v 0 0 0\nv 1 0\n", "", "model.obj", 2, "expected 3 to 4 numbers, got 2"},
+		{"bad number", "# comment\n\nvn 0 x 1\n", "", "model.obj", 3, `invalid number "x"`},
+		{"continued line", "v 0 0 \\\n 0\nv 1 \\\n 0\n", "", "model.obj", 3, "expected 3 to 4 numbers, got 2"},
+		{"two vertices", "v 0 0 0\nv 1 0 0\nf 1 2\n", "", "model.obj", 3, "a face needs at least three vertices, got 2"},
+		{"index out of range", objTriangle + "f 1 2 4\n", "newmtl m", "model.obj", 7, "vertex index 4 out of range, 3 read so far"},
+		{"negative index out of range", objTriangle + "f 1 2 -4\n", "newmtl m", "model.obj", 7, "vertex index -4 out of range"},
+		{"zero index", objTriangle + "f 0 1 2\n", "newmtl m", "model.obj", 7, "vertex indices start at 1"},
+		{"missing normal", objTriangle + "f 1//1 2//1 3//1\n", "newmtl m", "model.obj", 7, "normal index 1 out of range, 0 read so far"},
+		{"bad texture coordinate", objTriangle + "f 1/a 2 3\n", "newmtl m", "model.obj", 7, `invalid texture coordinate index "a"`},
+		{"missing position", objTriangle + "f /1 2 3\n", "newmtl m", "model.obj", 7, `invalid face vertex "/1"`},
+		{"too many attributes", objTriangle + "f 1/1/1/1 2 3\n", "newmtl m", "model.obj", 7, `invalid face vertex "1/1/1/1"`},
+		{"undefined material", objTriangle, "newmtl n", "model.obj", 6, `undefined material "m"`},
+		{"missing library", strings.Replace(objTriangle, "model.mtl", "other.mtl", 1), "", "model.obj", 1, "other.mtl"},
+		{"material before newmtl", objTriangle, "Kd 1 1 1\nnewmtl m", "model.mtl", 1, "Kd before the first newmtl"},
+		{"bad color", objTriangle, "newmtl m\n\nKd 1 1", "model.mtl", 3, "expected 1 or 3 numbers, got 2"},
+		{"bad refraction index", objTriangle, "newmtl m\nNi 0", "model.mtl", 2, "refraction index must be positive"},
+		{"bad illumination model", objTriangle, "newmtl m\nillum glass", "model.mtl", 2, `invalid illumination model "glass"`},
+		{"missing texture", objTriangle, "# textured\nnewmtl m\nmap_Kd missing.png", "model.mtl", 2, "map_Kd"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := LoadOBJ(writeOBJ(t, test.obj, map[string][]byte{"model.mtl": []byte(test.mtl)}))
+			var objErr *OBJError
+			if !errors.As(err, &objErr) {
+				t.Fatalf("got error %v, want an OBJError", err)
+			}
+			if filepath.Base(objErr.File) != test.file || objErr.Line != test.line || !strings.Contains(objErr.Msg, test.want) {
+				t.Errorf("got error %q, want %q at %s:%d", err, test.want, test.file, test.line)
+			}
+		})
+	}
+
+	if _, err := LoadOBJ("missing.obj"); err == nil {
+		t.Error("got no error for a missing file")
+	}
+}
@@ -0,0 +1,336 @@
+package gotrace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+LoadPLY reads a triangle mesh from a PLY file, in the ASCII or binary format of either endianness.
+
+The mesh is made of the faces of the file, polygons being triangulated, whose vertices are given by the x, y and z
+properties of the vertex element, with normals if they have nx, ny and nz properties, and texture coordinates if they have
+u and v (or s and t) properties. Other elements and properties are skipped.
+
+If the vertices have red, green and blue properties, they are the colors of the vertices of the mesh, which a material
+shows with a VertexColors texture. Integer colors are scaled from the range of their type to [0, 1].
+*/
+func LoadPLY(file string) (*Mesh, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mesh, err := readPLY(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return mesh, nil
+}
+
+// plyElement is an element declared by the header of a PLY file
+type plyElement struct {
+	name       string
+	count      int
+	properties []plyProperty
+}
+
+// plyProperty is a property of an element, lists having a count type as well
+type plyProperty struct {
+	name      string
+	kind      string
+	countKind string
+	list      bool
+}
+
+// plyMaxListLength is the largest length of the lists of a PLY file, so that a corrupted length doesn't allocate
+// gigabytes before the end of the file is reached
+const plyMaxListLength = 1 << 16
+
+// plySizes are the sizes of the types of properties in binary files, by their names and aliases
+var plySizes = map[string]int{
+	"char": 1, "uchar": 1, "short": 2, "ushort": 2, "int": 4, "uint": 4, "float": 4, "double": 8,
+	"int8": 1, "uint8": 1, "int16": 2, "uint16": 2, "int32": 4, "uint32": 4, "float32": 4, "float64": 8,
+}
+
+// plyColorScales are the largest values of the integer types of properties, by which colors of these types are divided
+var plyColorScales = map[string]float64{
+	"char": math.MaxInt8, "uchar": math.MaxUint8, "short": math.MaxInt16, "ushort": math.MaxUint16, "int": math.MaxInt32, "uint": math.MaxUint32,
+	"int8": math.MaxInt8, "uint8": math.MaxUint8, "int16": math.MaxInt16, "uint16": math.MaxUint16, "int32": math.MaxInt32, "uint32": math.MaxUint32,
+}
+
+// readPLY reads the header of a PLY file, and then the vertices and faces of its body
+func readPLY(r *bufio.Reader) (*Mesh, error) {
+	line, err := r.ReadString('\n')
+	if strings.TrimSpace(line) != "ply" {
+		return nil, fmt.Errorf("not a PLY file")
+	}
+	var (
+		format   string
+		elements []*plyElement
+	)
+	for {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, fmt.Errorf("unterminated header: %v", err)
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "format":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("invalid format %q", strings.TrimSpace(line))
+			}
+			format = fields[1]
+
+		case "element":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("invalid element %q", strings.TrimSpace(line))
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("invalid count of %s elements %q", fields[1], fields[2])
+			}
+			elements = append(elements, &plyElement{name: fields[1], count: count})
+
+		case "property":
+			if len(elements) == 0 {
+				return nil, fmt.Errorf("property %q outside of an element", strings.TrimSpace(line))
+			}
+			var p plyProperty
+			switch {
+			case len(fields) == 5 && fields[1] == "list":
+				p = plyProperty{name: fields[4], kind: fields[3], countKind: fields[2], list: true}
+			case len(fields) == 3:
+				p = plyProperty{name: fields[2], kind: fields[1]}
+			default:
+				return nil, fmt.Errorf("invalid property %q", strings.TrimSpace(line))
+			}
+			for _, kind := range []string{p.kind, p.countKind} {
+				if _, ok := plySizes[kind]; !ok && kind != "" {
+					return nil, fmt.Errorf("unknown type %q of property %s", kind, p.name)
+				}
+			}
+			element := elements[len(elements)-1]
+			element.properties = append(element.properties, p)
+
+		case "end_header":
+			var values plyValues
+			switch format {
+			case "ascii":
+				scanner := bufio.NewScanner(r)
+				scanner.Split(bufio.ScanWords)
+				values = plyText{scanner}
+			case "binary_little_endian":
+				values = &plyBinary{r: r, order: binary.LittleEndian}
+			case "binary_big_endian":
+				values = &plyBinary{r: r, order: binary.BigEndian}
+			default:
+				return nil, fmt.Errorf("unknown format %q", format)
+			}
+			return readPLYBody(values, elements)
+		}
+		// comments and obj_info lines are ignored
+	}
+}
+
+// plyValues reads the values of the properties of the body of a PLY file, whatever its format
+type plyValues interface {
+	value(kind string) (float64, error)
+}
+
+// plyText reads the values of ASCII files, which are separated by spaces
+type plyText struct {
+	scanner *bufio.Scanner
+}
+
+func (t plyText) value(kind string) (float64, error) {
+	if !t.scanner.Scan() {
+		if err := t.scanner.Err(); err != nil {
+			return 0, err
+		}
+		return 0, io.ErrUnexpectedEOF
+	}
+	value, err := strconv.ParseFloat(t.scanner.Text(), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", t.scanner.Text())
+	}
+	return value, nil
+}
+
+// plyBinary reads the values of binary files, in the given byte order
+type plyBinary struct {
+	r     io.Reader
+	order binary.ByteOrder
+	buf   [8]byte
+}
+
+func (b *plyBinary) value(kind string) (float64, error) {
+	data := b.buf[:plySizes[kind]]
+	if _, err := io.ReadFull(b.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	switch kind {
+	case "char", "int8":
+		return float64(int8(data[0])), nil
+	case "uchar", "uint8":
+		return float64(data[0]), nil
+	case "short", "int16":
+		return float64(int16(b.order.Uint16(data))), nil
+	case "ushort", "uint16":
+		return float64(b.order.Uint16(data)), nil
+	case "int", "int32":
+		return float64(int32(b.order.Uint32(data))), nil
+	case "uint", "uint32":
+		return float64(b.order.Uint32(data)), nil
+	case "float", "float32":
+		return float64(math.Float32frombits(b.order.Uint32(data))), nil
+	default:
+		return math.Float64frombits(b.order.Uint64(data)), nil
+	}
+}
+
+// readPLYBody reads the elements of a PLY file, keeping the vertex and face ones, and builds the mesh of its faces
+func readPLYBody(values plyValues, elements []*plyElement) (*Mesh, error) {
+	var (
+		vertices, normals, uvs, colors []Vec3
+		faces                          [][]int
+		hasVertices                    bool
+	)
+	for _, element := range elements {
+		// position of the properties of the vertices, -1 if missing
+		var position, normal, uv, color [3]int
+		if element.name == "vertex" {
+			hasVertices = true
+			for _, attribute := range []struct {
+				index *[3]int
+				names [][]string
+			}{
+				{&position, [][]string{{"x"}, {"y"}, {"z"}}},
+				{&normal, [][]string{{"nx"}, {"ny"}, {"nz"}}},
+				{&uv, [][]string{{"u", "s", "texture_u", "texture_s"}, {"v", "t", "texture_v", "texture_t"}, {}}},
+				{&color, [][]string{{"red", "diffuse_red", "r"}, {"green", "diffuse_green", "g"}, {"blue", "diffuse_blue", "b"}}},
+			} {
+				for i, names := range attribute.names {
+					attribute.index[i] = findProperty(element, names)
+				}
+			}
+			if position[0] < 0 || position[1] < 0 || position[2] < 0 {
+				return nil, fmt.Errorf("vertices need x, y and z properties")
+			}
+		}
+		faceIndices := -1
+		if element.name == "face" {
+			if faceIndices = findProperty(element, []string{"vertex_indices", "vertex_index"}); faceIndices < 0 || !element.properties[faceIndices].list {
+				return nil, fmt.Errorf("faces need a vertex_indices list property")
+			}
+		}
+
+		row := make([]float64, len(element.properties))
+		for n := 0; n < element.count; n++ {
+			for i, p := range element.properties {
+				if !p.list {
+					value, err := values.value(p.kind)
+					if err != nil {
+						return nil, fmt.Errorf("%s %d: %v", element.name, n, err)
+					}
+					row[i] = value
+					continue
+				}
+				count, err := values.value(p.countKind)
+				if err != nil {
+					return nil, fmt.Errorf("%s %d: %v", element.name, n, err)
+				}
+				if count < 0 || count != math.Trunc(count) || count > plyMaxListLength {
+					return nil, fmt.Errorf("%s %d: invalid list length %v", element.name, n, count)
+				}
+				list := make([]int, int(count))
+				for j := range list {
+					value, err := values.value(p.kind)
+					if err != nil {
+						return nil, fmt.Errorf("%s %d: %v", element.name, n, err)
+					}
+					list[j] = int(value)
+				}
+				if i == faceIndices {
+					faces = append(faces, list)
+				}
+			}
+
+			if element.name == "vertex" {
+				vertices = append(vertices, Vec3{row[position[0]], row[position[1]], row[position[2]]})
+				if normal[0] >= 0 && normal[1] >= 0 && normal[2] >= 0 {
+					normals = append(normals, Vec3{row[normal[0]], row[normal[1]], row[normal[2]]})
+				}
+				if uv[0] >= 0 && uv[1] >= 0 {
+					uvs = append(uvs, Vec3{X: row[uv[0]], Y: row[uv[1]]})
+				}
+				if color[0] >= 0 && color[1] >= 0 && color[2] >= 0 {
+					c := Vec3{row[color[0]], row[color[1]], row[color[2]]}
+					for i, component := range []*float64{&c.X, &c.Y, &c.Z} {
+						if scale, ok := plyColorScales[element.properties[color[i]].kind]; ok {
+							*component /= scale
+						}
+					}
+					colors = append(colors, c)
+				}
+			}
+		}
+	}
+	if !hasVertices {
+		return nil, fmt.Errorf("no vertex element")
+	}
+
+	var indices []int
+	for n, face := range faces {
+		if len(face) < 3 {
+			return nil, fmt.Errorf("face %d has %d vertices, at least three are needed", n, len(face))
+		}
+		points := make([]Vec3, len(face))
+		for i, index := range face {
+			if index < 0 || index >= len(vertices) {
+				return nil, fmt.Errorf("face %d: vertex index %d out of range, the file has %d vertices", n, index, len(vertices))
+			}
+			points[i] = vertices[index]
+		}
+		for _, triangle := range triangulate(points) {
+			indices = append(indices, face[triangle[0]], face[triangle[1]], face[triangle[2]])
+		}
+	}
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("no faces")
+	}
+
+	mesh, err := NewMesh(vertices, indices, normals, uvs)
+	if err != nil {
+		return nil, err
+	}
+	if colors != nil {
+		if err := mesh.SetColors(colors); err != nil {
+			return nil, err
+		}
+	}
+	return mesh, nil
+}
+
+// findProperty returns the position of the first property of the element having one of the names, -1 if there is none
+func findProperty(element *plyElement, names []string) int {
+	for _, name := range names {
+		for i, p := range element.properties {
+			if p.name == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,141 @@
+package gotrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+const plyQuad = `ply
+format ascii 1.0
+comment a unit square in the z = 0 plane
+element vertex 4
+property float x
+property float y
+property float z
+property float u
+property float v
+property uchar red
+property uchar green
+property uchar blue
+element face 1
+property list uchar int vertex_indices
+end_header
+0 0 0 0 0 255 0 0
+1 0 0 1 0 0 255 0
+1 1 0 1 1 0 0 255
+0 1 0 0 1 255 255 255
+4 0 1 2 3
+`
+
+func TestLoadPLYText(t *testing.T) {
+	mesh, err := LoadPLY(writeTestFile(t, "quad.ply", []byte(plyQuad)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mesh.Triangles() != 2 {
+		t.Fatalf("got %d triangles, want 2", mesh.Triangles())
+	}
+	// the vertices of the quad are shared by its triangles, and keep the texture coordinates of the file
+	if len(mesh.vertices) != 4 || len(mesh.colors) != 4 || len(mesh.uvs) != 4 {
+		t.Fatalf("got %d vertices, %d colors and %d uvs, want 4 of each", len(mesh.vertices), len(mesh.colors), len(mesh.uvs))
+	}
+	if mesh.uvs[2] != (Vec3{1, 1, 0}) {
+		t.Errorf("got uv %v for the third vertex, want [1 1]", mesh.uvs[2])
+	}
+	if mesh.colors[0] != (Vec3{1, 0, 0}) {
+		t.Errorf("got color %v for the first vertex, want red", mesh.colors[0])
+	}
+
+	// colors are interpolated at the hits, next to the middle of the edge between the red and the green vertices
+	hit, record := mesh.Hit(Ray{Origin: Vec3{0.5, 0.01, 1}, Direction: Vec3{0, 0, -1}}, 0, math.Inf(1))
+	if !hit || !record.HasVertexColor {
+		t.Fatal("the mesh wasn't hit with a color")
+	}
+	if want := (Vec3{0.5, 0.5, 0}); record.VertexColor.Sub(want).Norm() > 0.02 {
+		t.Errorf("got color %v next to the edge, want %v", record.VertexColor, want)
+	}
+	if color := (VertexColors{}).HitValue(*record); color != record.VertexColor {
+		t.Errorf("VertexColors got %v, want %v", color, record.VertexColor)
+	}
+}
+
+// binaryPLY returns a binary PLY file of a triangle, whose colors are of the given type and value
+func binaryPLY(order binary.ByteOrder, format, colorKind string, color interface{}) []byte {
+	var b bytes.Buffer
+	b.WriteString("ply\nformat " + format + " 1.0\nelement vertex 3\n")
+	b.WriteString("property float x\nproperty float y\nproperty float z\n")
+	for _, c := range []string{"red", "green", "blue"} {
+		b.WriteString("property " + colorKind + " " + c + "\n")
+	}
+	b.WriteString("element face 1\nproperty list uchar uint vertex_indices\nend_header\n")
+	for _, vertex := range [][3]float32{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}} {
+		binary.Write(&b, order, vertex)
+		for i := 0; i < 3; i++ {
+			binary.Write(&b, order, color)
+		}
+	}
+	binary.Write(&b, order, uint8(3))
+	binary.Write(&b, order, []uint32{0, 1, 2})
+	return b.Bytes()
+}
+
+func TestLoadPLYBinaryColors(t *testing.T) {
+	tests := []struct {
+		name   string
+		order  binary.ByteOrder
+		format string
+		kind   string
+		color  interface{}
+		want   float64
+	}{
+		{"uchar", binary.LittleEndian, "binary_little_endian", "uchar", uint8(51), 0.2},
+		{"ushort", binary.BigEndian, "binary_big_endian", "ushort", uint16(65535), 1},
+		{"uint16", binary.LittleEndian, "binary_little_endian", "uint16", uint16(13107), 0.2},
+		{"short", binary.LittleEndian, "binary_little_endian", "short", int16(32767), 1},
+		{"float", binary.BigEndian, "binary_big_endian", "float", float32(0.5), 0.5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mesh, err := LoadPLY(writeTestFile(t, "triangle.ply", binaryPLY(test.order, test.format, test.kind, test.color)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := mesh.colors[1]; math.Abs(got.X-test.want) > 1e-6 || got.X != got.Y || got.Y != got.Z {
+				t.Errorf("got color %v, want gray %v", got, test.want)
+			}
+			if mesh.vertices[2] != (Vec3{0, 1, 0}) {
+				t.Errorf("got vertex %v, want [0 1 0]", mesh.vertices[2])
+			}
+		})
+	}
+}
+
+func TestLoadPLYErrors(t *testing.T) {
+	full := binaryPLY(binary.LittleEndian, "binary_little_endian", "uchar", uint8(0))
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"not a PLY file", "solid cube\n", "not a PLY file"},
+		{"unterminated header", "ply\nformat ascii 1.0\nelement vertex 3\n", "unterminated header"},
+		{"unknown type", "ply\nformat ascii 1.0\nelement vertex 1\nproperty quad x\nend_header\n", `unknown type "quad"`},
+		{"truncated text body", strings.SplitAfter(plyQuad, "end_header\n")[0] + "0 0 0 0 0 255 0 0\n1 0", "vertex 1: unexpected EOF"},
+		{"truncated binary body", string(full[:len(full)-2]), "face 0: unexpected EOF"},
+		{"huge text list", strings.Replace(plyQuad, "4 0 1 2 3", "4294967295 0 1 2 3", 1), "face 0: invalid list length 4.294967295e+09"},
+		{"huge binary list", strings.Replace(string(full[:len(full)-13]), "list uchar", "list uint", 1) + "\xff\xff\xff\xff", "face 0: invalid list length 4.294967295e+09"},
+		{"index out of range", strings.Replace(plyQuad, "4 0 1 2 3", "3 0 1 7", 1), "face 0: vertex index 7 out of range"},
+		{"no faces", strings.Replace(strings.Replace(plyQuad, "element face 1", "element face 0", 1), "4 0 1 2 3\n", "", 1), "no faces"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := LoadPLY(writeTestFile(t, "broken.ply", []byte(test.data)))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package gotrace
+
+import (
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// Progress is the state of a rendering
+type Progress struct {
+	// Done and Total are the number of rendered tiles, and the number of tiles of the image
+	Done, Total int
+	// Samples and TotalSamples are the number of traced camera rays, and the number of camera rays of the whole rendering
+	// Totals of adaptive renderings are upper bounds, which decrease as pixels converge.
+	Samples, TotalSamples int64
+	// Rays is the number of traced rays, including the scattered ones
+	Rays int64
+	// Elapsed is the duration since the start of the rendering
+	Elapsed time.Duration
+}
+
+// RaysPerSecond returns the mean number of rays traced per second since the start of the rendering
+func (p Progress) RaysPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Rays) / p.Elapsed.Seconds()
+}
+
+// ETA estimates the remaining duration of the rendering from the number of traced samples
+func (p Progress) ETA() time.Duration {
+	if p.Samples == 0 {
+		return 0
+	}
+	remaining := float64(p.TotalSamples-p.Samples) / float64(p.Samples)
+	return time.Duration(remaining * float64(p.Elapsed))
+}
+
+/*
+ProgressReporter is notified of the progress of a rendering
+
+Start is called once before rendering, Update every time some samples are traced, and Finish once the rendering
+is done or stopped. Calls are never concurrent, so implementations don't need to be safe for concurrent use.
+*/
+type ProgressReporter interface {
+	Start(p Progress)
+	Update(p Progress)
+	Finish(p Progress)
+}
+
+// NopReporter ignores the progress of the rendering
+type NopReporter struct{}
+
+// Start implements the ProgressReporter interface
+func (NopReporter) Start(p Progress) {}
+
+// Update implements the ProgressReporter interface
+func (NopReporter) Update(p Progress) {}
+
+// Finish implements the ProgressReporter interface
+func (NopReporter) Finish(p Progress) {}
+
+// BarReporter displays the progress of the rendering as a progress bar in the terminal
+type BarReporter struct {
+	// Output is where the bar is displayed, standard error if nil
+	Output io.Writer
+	bar    *pb.ProgressBar
+}
+
+// Start implements the ProgressReporter interface
+func (r *BarReporter) Start(p Progress) {
+	r.bar = pb.New64(p.TotalSamples)
+	if r.Output != nil {
+		r.bar.SetWriter(r.Output)
+	}
+	r.bar.Start()
+}
+
+// Update implements the ProgressReporter interface
+func (r *BarReporter) Update(p Progress) {
+	// adaptive renderings need less samples than expected at first
+	r.bar.SetTotal(p.TotalSamples)
+	r.bar.SetCurrent(p.Samples)
+}
+
+// Finish implements the ProgressReporter interface
+func (r *BarReporter) Finish(p Progress) {
+	r.bar.SetCurrent(p.Samples)
+	r.bar.Finish()
+}
+
+// LogReporter logs the progress of the rendering as key=value pairs
+type LogReporter struct {
+	// Logger receives the progress lines, the standard logger if nil
+	Logger *log.Logger
+	// Interval is the minimum duration between two logged updates
+	Interval time.Duration
+	last     time.Duration
+}
+
+func (r *LogReporter) log(event string, p Progress) {
+	printf := log.Printf
+	if r.Logger != nil {
+		printf = r.Logger.Printf
+	}
+	printf("render=%s tiles=%d/%d samples=%d/%d rays=%d rays_per_second=%.0f elapsed=%s eta=%s",
+		event, p.Done, p.Total, p.Samples, p.TotalSamples, p.Rays, p.RaysPerSecond(),
+		p.Elapsed.Round(time.Second), p.ETA().Round(time.Second))
+}
+
+// Start implements the ProgressReporter interface
+func (r *LogReporter) Start(p Progress) {
+	r.last = 0
+	r.log("start", p)
+}
+
+// Update implements the ProgressReporter interface
+func (r *LogReporter) Update(p Progress) {
+	if p.Elapsed-r.last >= r.Interval {
+		r.last = p.Elapsed
+		r.log("progress", p)
+	}
+}
+
+// Finish implements the ProgressReporter interface
+func (r *LogReporter) Finish(p Progress) {
+	r.log("finish", p)
+}
+
+// progressTracker accumulates the progress of the rendering workers and forwards it to the reporter
+type progressTracker struct {
+	mu       sync.Mutex
+	reporter ProgressReporter
+	start    time.Time
+	progress Progress
+}
+
+func newProgressTracker(reporter ProgressReporter, tiles int, totalSamples int64) *progressTracker {
+	if reporter == nil {
+		reporter = NopReporter{}
+	}
+	t := &progressTracker{
+		reporter: reporter,
+		start:    time.Now(),
+		progress: Progress{Total: tiles, TotalSamples: totalSamples},
+	}
+	reporter.Start(t.progress)
+	return t
+}
+
+// add records that workers have traced samples and rays, and rendered tiles
+func (t *progressTracker) add(tiles int, samples, rays int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress.Done += tiles
+	t.progress.Samples += samples
+	t.progress.Rays += rays
+	t.progress.Elapsed = time.Since(t.start)
+	t.reporter.Update(t.progress)
+}
+
+// expect changes the expected number of tile passes and samples of the rest of the rendering
+func (t *progressTracker) expect(tiles int, samples int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress.Total = t.progress.Done + tiles
+	t.progress.TotalSamples = t.progress.Samples + samples
+}
+
+func (t *progressTracker) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress.Elapsed = time.Since(t.start)
+	t.reporter.Finish(t.progress)
+}
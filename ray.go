@@ -1,13 +1,12 @@
 package gotrace
 
-import "math/rand"
-
 // Ray is a light ray
 type Ray struct {
-	Origin     Vec3
-	Direction  Vec3
-	Time       float64
-	RandSource *rand.Rand
+	Origin    Vec3
+	Direction Vec3
+	Time      float64
+	// Sampler provides the random values used to scatter the ray
+	Sampler Sampler
 }
 
 // At is the point of the ray having travelled t
@@ -0,0 +1,383 @@
+package gotrace
+
+import (
+	"context"
+	"errors"
+	"image"
+	"runtime"
+	"sync"
+)
+
+// RenderOptions are the parameters of a render
+type RenderOptions struct {
+	// Width and Height are the size of the image in pixels.
+	// The size given by the scene file is used if Width is not positive, and Height is deduced from the aspect ratio if it is not positive.
+	Width, Height int
+	// Samples is the number of rays cast per pixel, 50 if not positive
+	Samples int
+	// MaxScatter is the number of bounces after which a ray is considered absorbed, 50 if not positive
+	MaxScatter int
+	// RouletteDepth is the number of bounces after which paths are randomly terminated by Russian roulette, 3 if not positive.
+	// Roulette is disabled if it is at least MaxScatter.
+	RouletteDepth int
+	// Workers is the number of tiles rendered concurrently, the number of CPUs if not positive
+	Workers int
+	// TileSize is the side in pixels of the square tiles the image is split into, 32 if not positive
+	TileSize int
+	// TileOrder is the order in which tiles are rendered
+	TileOrder TileOrder
+	// Seed changes the random sources used for rendering
+	Seed int64
+	// Progress is notified of the progress of the rendering, which is not reported if nil
+	Progress ProgressReporter
+	// PassSamples is the number of samples per pixel of each pass of a progressive rendering, 16 if not positive
+	PassSamples int
+	// OnPass is called after each pass of a progressive rendering
+	OnPass PassFunc
+	// AOVs are the auxiliary images rendered along the image, see Accumulator.AOVFramebuffer
+	AOVs []AOV
+	// ToneMapper maps the rendered radiance to the colors of the image, the scene's tone mapper if nil
+	ToneMapper ToneMapper
+	// Filter reconstructs the pixels from the samples, a BoxFilter of radius 0.5 if nil
+	Filter Filter
+	// Sampler provides the random values of the samples, and is cloned for each tile. It is an IndependentSampler if nil.
+	Sampler Sampler
+	// TargetError enables adaptive sampling if positive: pixels stop being sampled once their relative error is below
+	// TargetError (see Accumulator.RelativeError), Samples being the maximum number of samples per pixel.
+	// Adaptive renderings are progressive, each pass sampling the pixels which are not converged yet.
+	TargetError float64
+	// MinSamples is the number of samples of every pixel before its error is trusted by adaptive sampling, 16 if not positive
+	MinSamples int
+}
+
+// withDefaults returns the options where unset values are replaced by their defaults for the scene
+func (s *Scene) withDefaults(opts RenderOptions) (RenderOptions, error) {
+	// set default value for max number of ray bounces before absorption
+	if opts.MaxScatter <= 0 {
+		opts.MaxScatter = 50
+	}
+
+	if opts.RouletteDepth <= 0 {
+		opts.RouletteDepth = 3
+	}
+
+	if opts.Samples <= 0 {
+		opts.Samples = 50
+	}
+
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+
+	if opts.TileSize <= 0 {
+		opts.TileSize = 32
+	}
+
+	// the mean of the samples of each pixel
+	if opts.Filter == nil {
+		opts.Filter = BoxFilter{0.5}
+	}
+
+	if opts.Sampler == nil {
+		opts.Sampler = NewIndependentSampler(opts.Seed)
+	}
+
+	if opts.MinSamples <= 0 {
+		opts.MinSamples = 16
+	}
+
+	// use the size of the scene file if none is given
+	if opts.Width <= 0 {
+		opts.Width, opts.Height = s.width, s.height
+	}
+	if opts.Width <= 0 {
+		opts.Width = 400
+	}
+
+	// deduce height from aspect ratio
+	if opts.Height <= 0 {
+		opts.Height = int(float64(opts.Width) / s.camera.AspectRatio)
+	}
+	if opts.Height <= 0 {
+		return opts, errors.New("image height is zero, the image is too small for the aspect ratio of the camera")
+	}
+	return opts, nil
+}
+
+// Render renders the scene with the given parameters
+// Use RenderContext to be able to stop the rendering.
+func (s *Scene) Render(width, height, pixelSamples, maxScatter int) *image.RGBA {
+	img, _, _ := s.RenderContext(context.Background(), RenderOptions{
+		Width:      width,
+		Height:     height,
+		Samples:    pixelSamples,
+		MaxScatter: maxScatter,
+	})
+	return img
+}
+
+/*
+RenderContext renders the scene with the given options, until the rendering is done or ctx is done.
+
+The image is split into tiles, which are rendered by a fixed pool of workers. Each worker renders the tiles of its own queue,
+and steals tiles from the other workers when its queue is empty, so that expensive parts of the image don't leave workers idle.
+A tile is rendered by successive passes of one sample per pixel, so that it is always uniformly converged. If ctx is done
+before the end of the rendering, the tiles being rendered are stopped after their current pass, the remaining tiles are not
+rendered, and ctx's error is returned along with the partially converged image.
+
+@out
+
+	*image.RGBA : the rendered image, where pixels having no samples are transparent
+	[]int : the number of samples received by every pixel of each line of the image, from top to bottom
+	error : ctx's error if it is done before the end of the rendering, or an error with the options
+*/
+func (s *Scene) RenderContext(ctx context.Context, opts RenderOptions) (*image.RGBA, []int, error) {
+	acc, err := s.render(ctx, opts)
+	if acc == nil {
+		return nil, nil, err
+	}
+	toneMapper := opts.ToneMapper
+	if toneMapper == nil {
+		toneMapper = s.ToneMapper()
+	}
+	return acc.ToneMap(toneMapper), acc.lineSamples(), err
+}
+
+// RenderHDR renders the scene as RenderContext, but returns the linear radiance of the pixels, which isn't clamped to 1
+func (s *Scene) RenderHDR(ctx context.Context, opts RenderOptions) (*Framebuffer, []int, error) {
+	acc, err := s.render(ctx, opts)
+	if acc == nil {
+		return nil, nil, err
+	}
+	return acc.Framebuffer(), acc.lineSamples(), err
+}
+
+// render renders all samples in a single pass, and returns a nil accumulator if the options are invalid
+func (s *Scene) render(ctx context.Context, opts RenderOptions) (*Accumulator, error) {
+	opts, err := s.withDefaults(opts)
+	if err != nil {
+		return nil, err
+	}
+	acc := NewAccumulator(opts.Width, opts.Height, opts.AOVs...)
+	if opts.TargetError > 0 {
+		return acc, s.RenderProgressive(ctx, acc, opts)
+	}
+	tiles := splitTiles(opts.Width, opts.Height, opts.TileSize, opts.TileOrder)
+	progress := newProgressTracker(opts.Progress, len(tiles), int64(opts.Width*opts.Height)*int64(opts.Samples))
+	err = s.renderPass(ctx, acc, tiles, acc.remainingSamples(opts), opts, progress)
+	progress.finish()
+	return acc, err
+}
+
+/*
+RenderProgressive renders the scene into acc by passes of opts.PassSamples samples per pixel, until all pixels of acc have
+opts.Samples samples, or are converged if opts.TargetError is set. The size of the image is the size of acc, unless acc is the zero Accumulator which is then sized from
+the options, and the AOVs are those of acc. The other options are used as in RenderContext.
+
+opts.OnPass is called after each pass, so that the converging image can be displayed or saved. The rendering can be stopped
+after any pass by returning an error from opts.OnPass or by cancelling ctx, and resumed by calling RenderProgressive again
+with the same accumulator, with the same or a higher number of samples.
+*/
+func (s *Scene) RenderProgressive(ctx context.Context, acc *Accumulator, opts RenderOptions) error {
+	if acc.width > 0 {
+		opts.Width, opts.Height = acc.Size()
+	}
+	opts, err := s.withDefaults(opts)
+	if err != nil {
+		return err
+	}
+	if acc.width == 0 {
+		*acc = *NewAccumulator(opts.Width, opts.Height, opts.AOVs...)
+	}
+	if opts.PassSamples <= 0 {
+		opts.PassSamples = 16
+	}
+	tiles := splitTiles(opts.Width, opts.Height, opts.TileSize, opts.TileOrder)
+
+	remaining := acc.remainingSamples(opts)
+	tilePasses, samples := estimateWork(tiles, remaining, opts)
+	if samples == 0 {
+		return nil
+	}
+	progress := newProgressTracker(opts.Progress, tilePasses, samples)
+	defer progress.finish()
+
+	for samples > 0 {
+		budget := make([]int, len(remaining))
+		for k, n := range remaining {
+			budget[k] = n
+			if n > opts.PassSamples {
+				budget[k] = opts.PassSamples
+			}
+		}
+		if err := s.renderPass(ctx, acc, tiles, budget, opts, progress); err != nil {
+			return err
+		}
+		if opts.OnPass != nil {
+			if err := opts.OnPass(acc); err != nil {
+				return err
+			}
+		}
+		remaining = acc.remainingSamples(opts)
+		// converged pixels of adaptive renderings make the work left smaller than expected
+		tilePasses, samples = estimateWork(tiles, remaining, opts)
+		progress.expect(tilePasses, samples)
+	}
+	return nil
+}
+
+// estimateWork returns the number of passes over tiles and the number of samples needed for the remaining samples of the
+// pixels, at most, as adaptive sampling may need less
+func estimateWork(tiles []tile, remaining []int, opts RenderOptions) (tilePasses int, samples int64) {
+	for _, t := range tiles {
+		max := 0
+		for y := t.bounds.Min.Y; y < t.bounds.Max.Y; y++ {
+			for x := t.bounds.Min.X; x < t.bounds.Max.X; x++ {
+				n := remaining[y*opts.Width+x]
+				samples += int64(n)
+				if n > max {
+					max = n
+				}
+			}
+		}
+		tilePasses += (max + opts.PassSamples - 1) / opts.PassSamples
+	}
+	return tilePasses, samples
+}
+
+// renderPass adds to each pixel of the accumulator the number of samples given by budget, in image order, and returns
+// ctx's error if the pass was stopped. Tiles whose pixels don't need samples are skipped.
+func (s *Scene) renderPass(ctx context.Context, acc *Accumulator, tiles []tile, budget []int, opts RenderOptions, progress *progressTracker) error {
+	sched := newScheduler(tiles, opts.Workers)
+	// guards the splats of the tiles into the accumulator
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				t, ok := sched.next(w)
+				if !ok {
+					return
+				}
+				film := s.renderTile(ctx, acc, t, budget, opts, progress)
+				if film == nil {
+					continue
+				}
+				mu.Lock()
+				film.splat(acc)
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	// stopped passes are counted too, so that resumed renderings don't reuse their random sources
+	acc.passes++
+	return ctx.Err()
+}
+
+// renderTile adds samples to the pixels of a tile of the accumulator, and returns the film the samples are splatted into,
+// or nil if no pixel of the tile needs samples
+func (s *Scene) renderTile(ctx context.Context, acc *Accumulator, t tile, budget []int, opts RenderOptions, progress *progressTracker) *film {
+	width, height := acc.Size()
+	samples := 0
+	for y := t.bounds.Min.Y; y < t.bounds.Max.Y; y++ {
+		for x := t.bounds.Min.X; x < t.bounds.Max.X; x++ {
+			if budget[y*width+x] > samples {
+				samples = budget[y*width+x]
+			}
+		}
+	}
+	if samples == 0 {
+		return nil
+	}
+	// random sources only depend on the position of the tile and on the pass, so that images don't depend on the scheduling of the tiles
+	sampler := opts.Sampler.Clone(mixSeed(opts.Seed, int64(acc.passes), int64(t.index)))
+	bounds := t.bounds
+	film := newFilm(bounds, opts.Filter, width, height)
+	npixels := bounds.Dx() * bounds.Dy()
+	var aovs [][]Vec3
+	if len(acc.aovs) > 0 {
+		aovs = make([][]Vec3, npixels)
+		for k := range aovs {
+			aovs[k] = make([]Vec3, len(acc.aovs))
+		}
+	}
+	kinds := acc.AOVs()
+	// AOVs of the first hit of each sample
+	values := make([]Vec3, len(kinds))
+	scratch := &pathScratch{}
+	// sums of the luminances of the samples of each pixel and of their squares
+	lum := make([]float64, npixels)
+	lumSquared := make([]float64, npixels)
+	n := 0
+	for ; n < samples && ctx.Err() == nil; n++ {
+		rays := int64(0)
+		sampled := 0
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if n >= budget[y*width+x] {
+					continue
+				}
+				sampled++
+				k := (y-bounds.Min.Y)*bounds.Dx() + x - bounds.Min.X
+				// samples of the pixel taken by previous passes are only counted once the tile is rendered
+				sampler.StartPixelSample(x, y, acc.samples[y*width+x]+n)
+				// position of the sample on the image, whose lines go from top to bottom while the camera's v
+				// coordinate goes upwards
+				jx, jy := sampler.Get2D()
+				fx, fy := float64(x)+jx, float64(y)+jy
+				ray := s.camera.RayTo(fx/float64(width), (float64(height)-fy)/float64(height), sampler)
+				for a := range values {
+					values[a] = Vec3{}
+				}
+				color := s.rayColor(ray, opts, kinds, values, scratch, &rays)
+				for a, value := range values {
+					if kinds[a] != AOVActorID {
+						aovs[k][a] = aovs[k][a].Add(value)
+					} else if n == 0 {
+						aovs[k][a] = value
+					}
+				}
+				l := luminance(color)
+				lum[k] += l
+				lumSquared[k] += l * l
+				film.add(fx, fy, color)
+			}
+		}
+		done := 0
+		if n == samples-1 {
+			done = 1
+		}
+		progress.add(done, int64(sampled), rays)
+	}
+	// tiles don't overlap, so there is no data race between workers
+	for k := 0; k < npixels; k++ {
+		var pixelAOVs []Vec3
+		if aovs != nil {
+			pixelAOVs = aovs[k]
+		}
+		x, y := bounds.Min.X+k%bounds.Dx(), bounds.Min.Y+k/bounds.Dx()
+		count := n
+		if count > budget[y*width+x] {
+			count = budget[y*width+x]
+		}
+		acc.add(x, y, count, lum[k], lumSquared[k], pixelAOVs)
+	}
+	return film
+}
+
+// mixSeed mixes values into the seed of a random source, so that close values give unrelated seeds
+func mixSeed(values ...int64) int64 {
+	h := uint64(0x9e3779b97f4a7c15)
+	for _, v := range values {
+		// splitmix64 finalizer
+		h ^= uint64(v)
+		h += 0x9e3779b97f4a7c15
+		h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+		h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+		h ^= h >> 31
+	}
+	return int64(h)
+}
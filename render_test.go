@@ -0,0 +1,157 @@
+package gotrace
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// renderWorkers renders the scene with the given number of workers, with a filter spreading samples over the neighbouring
+// tiles, AOVs and a sampler of the package
+func renderWorkers(t *testing.T, scene *Scene, workers int) *Accumulator {
+	t.Helper()
+	acc := &Accumulator{}
+	opts := RenderOptions{
+		Width:       40,
+		Height:      40,
+		Samples:     4,
+		PassSamples: 2,
+		Workers:     workers,
+		TileSize:    8,
+		Filter:      GaussianFilter{1.5, 0.5},
+		Sampler:     NewSobolSampler(7),
+		AOVs:        []AOV{AOVNormal, AOVDepth, AOVAlbedo, AOVActorID},
+	}
+	if err := scene.RenderProgressive(context.Background(), acc, opts); err != nil {
+		t.Fatal(err)
+	}
+	return acc
+}
+
+// TestRenderWorkers renders with concurrent workers, which is checked for data races by go test -race
+func TestRenderWorkers(t *testing.T) {
+	// both renderings are of the same scene, whose index is built once
+	scene := FoggyCornellBox()
+	want := renderWorkers(t, scene, 1)
+	got := renderWorkers(t, scene, 4)
+	// the samples don't depend on the workers, only the order in which tiles are splatted into the image does
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			if got.Samples(x, y) != 4 {
+				t.Fatalf("got %d samples for pixel (%d, %d), want 4", got.Samples(x, y), x, y)
+			}
+			if d := got.Average(x, y).Sub(want.Average(x, y)).Norm(); d > 1e-9 {
+				t.Fatalf("got %v for pixel (%d, %d) with 4 workers, want %v as with 1 worker", got.Average(x, y), x, y, want.Average(x, y))
+			}
+		}
+	}
+	for _, aov := range want.AOVs() {
+		if !reflect.DeepEqual(got.AOVFramebuffer(aov), want.AOVFramebuffer(aov)) {
+			t.Errorf("got a different %s AOV with 4 workers", aov)
+		}
+	}
+}
+
+// benchmarkWorkers are the numbers of workers of the benchmarks, up to the number of CPUs
+func benchmarkWorkers() []int {
+	var workers []int
+	for n := 1; n < runtime.NumCPU(); n *= 2 {
+		workers = append(workers, n)
+	}
+	return append(workers, runtime.NumCPU())
+}
+
+// reportSamples reports the number of samples rendered per second by the benchmark since start
+func reportSamples(b *testing.B, start time.Time, width, height, samples int) {
+	b.ReportMetric(float64(b.N*width*height*samples)/time.Since(start).Seconds(), "samples/s")
+}
+
+/*
+renderScanlines renders the scene as Render did before tiles: each line of the image is rendered by its own goroutine,
+at most workers of them running at the same time. It is the baseline of the benchmarks of the tile scheduler, which only
+differ by the way pixels are shared between workers.
+*/
+func renderScanlines(s *Scene, opts RenderOptions) []Vec3 {
+	pixels := make([]Vec3, opts.Width*opts.Height)
+	semaphore := make(chan struct{}, opts.Workers)
+	var wg sync.WaitGroup
+	for y := 0; y < opts.Height; y++ {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(y int) {
+			defer func() { <-semaphore; wg.Done() }()
+			sampler := opts.Sampler.Clone(int64(y))
+			scratch := &pathScratch{}
+			var rays int64
+			for x := 0; x < opts.Width; x++ {
+				var color Vec3
+				for n := 0; n < opts.Samples; n++ {
+					sampler.StartPixelSample(x, y, n)
+					jx, jy := sampler.Get2D()
+					u, v := (float64(x)+jx)/float64(opts.Width), (float64(opts.Height-y)-jy)/float64(opts.Height)
+					color = color.Add(s.rayColor(s.camera.RayTo(u, v, sampler), opts, nil, nil, scratch, &rays))
+				}
+				pixels[y*opts.Width+x] = color.Div(float64(opts.Samples))
+			}
+		}(y)
+	}
+	wg.Wait()
+	return pixels
+}
+
+// benchmarkRender renders the scene with the tile scheduler and with renderScanlines, for each number of workers, and
+// with each tile order using all CPUs
+func benchmarkRender(b *testing.B, newScene func() *Scene, width, samples int) {
+	scene := newScene()
+	opts, err := scene.withDefaults(RenderOptions{Width: width, Samples: samples})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, workers := range benchmarkWorkers() {
+		opts := opts
+		opts.Workers = workers
+		b.Run(fmt.Sprintf("scanlines/workers=%d", workers), func(b *testing.B) {
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				renderScanlines(scene, opts)
+			}
+			reportSamples(b, start, opts.Width, opts.Height, samples)
+		})
+		b.Run(fmt.Sprintf("tiles/workers=%d", workers), func(b *testing.B) {
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				if _, err := scene.render(context.Background(), opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+			reportSamples(b, start, opts.Width, opts.Height, samples)
+		})
+	}
+	for _, order := range []TileOrder{SpiralOrder, ScanlineOrder, HilbertOrder} {
+		opts := opts
+		opts.TileOrder = order
+		b.Run(fmt.Sprintf("tiles/order=%s", order), func(b *testing.B) {
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				if _, err := scene.render(context.Background(), opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+			reportSamples(b, start, opts.Width, opts.Height, samples)
+		})
+	}
+}
+
+// BenchmarkRenderCornellBox renders the cornell box, whose cost is spread evenly over the image
+func BenchmarkRenderCornellBox(b *testing.B) {
+	benchmarkRender(b, CornellBox, 100, 16)
+}
+
+// BenchmarkRenderFinalScene renders the final scene, whose glass and fog make a few parts of the image expensive
+func BenchmarkRenderFinalScene(b *testing.B) {
+	benchmarkRender(b, FinalScene, 100, 8)
+}
@@ -0,0 +1,490 @@
+package gotrace
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"sync"
+)
+
+/*
+Sampler provides the random values of the samples of the pixels, which are used in turn by the camera, the materials and
+the media to sample the paths of light.
+
+Each sample of a pixel is a point in a space of many dimensions, whose first two dimensions are the position of the sample
+in the pixel. Samplers other than the independent one spread the samples of a pixel evenly over their dimensions, so that
+images converge faster than with purely random samples.
+
+Samplers are not safe for concurrent use: each goroutine works with its own clone.
+*/
+type Sampler interface {
+	// StartPixelSample starts the sample of the given index of the pixel (x, y), at its first dimension
+	StartPixelSample(x, y, index int)
+	// Get1D returns the value of the next dimension of the sample, in [0, 1)
+	Get1D() float64
+	// Get2D returns the values of the next two dimensions of the sample, in [0, 1)
+	Get2D() (float64, float64)
+	// Clone returns a copy of the sampler, whose random values are drawn from a source seeded with seed if it uses one
+	Clone(seed int64) Sampler
+}
+
+// SamplerNames are the names of the samplers, as used on the command line
+var SamplerNames = []string{"independent", "stratified", "halton", "sobol", "bluenoise"}
+
+// NewSampler returns the sampler of the given name, for images of the given number of samples per pixel
+func NewSampler(name string, seed int64, samples int) (Sampler, error) {
+	switch name {
+	case "independent":
+		return NewIndependentSampler(seed), nil
+	case "stratified":
+		return NewStratifiedSampler(seed, samples), nil
+	case "halton":
+		return NewHaltonSampler(seed), nil
+	case "sobol":
+		return NewSobolSampler(seed), nil
+	case "bluenoise":
+		return NewBlueNoiseSampler(seed), nil
+	}
+	return nil, fmt.Errorf("unknown sampler %q", name)
+}
+
+// IndependentSampler draws all values from a random source, which is how the renderer used to sample paths
+type IndependentSampler struct {
+	rnd *rand.Rand
+}
+
+// NewIndependentSampler creates an independent sampler drawing from a source seeded with seed
+func NewIndependentSampler(seed int64) *IndependentSampler {
+	return &IndependentSampler{rand.New(rand.NewSource(seed))}
+}
+
+// StartPixelSample implements the Sampler interface
+func (s *IndependentSampler) StartPixelSample(x, y, index int) {}
+
+// Get1D implements the Sampler interface
+func (s *IndependentSampler) Get1D() float64 {
+	return s.rnd.Float64()
+}
+
+// Get2D implements the Sampler interface
+func (s *IndependentSampler) Get2D() (float64, float64) {
+	return s.rnd.Float64(), s.rnd.Float64()
+}
+
+// Clone implements the Sampler interface
+func (s *IndependentSampler) Clone(seed int64) Sampler {
+	return NewIndependentSampler(seed)
+}
+
+// pixelSample is the current sample of samplers whose values only depend on the pixel, the index of the sample and the
+// dimension, so that they don't depend on the order in which samples are taken
+type pixelSample struct {
+	x, y, index, dim int
+}
+
+// StartPixelSample implements the Sampler interface
+func (p *pixelSample) StartPixelSample(x, y, index int) {
+	*p = pixelSample{x: x, y: y, index: index}
+}
+
+// next returns the current dimension and moves to the following one
+func (p *pixelSample) next() int {
+	p.dim++
+	return p.dim - 1
+}
+
+// hash returns a hash of the pixel, of the dimension and of the given values
+func (p *pixelSample) hash(seed int64, dim int, values ...int64) uint32 {
+	h := mixSeed(seed, int64(p.x), int64(p.y), int64(dim))
+	for _, v := range values {
+		h = mixSeed(h, v)
+	}
+	return uint32(h)
+}
+
+/*
+StratifiedSampler splits each dimension of the samples of a pixel into as many strata as there are samples, and jitters a
+sample in each stratum. Pairs of dimensions are stratified on a grid when the number of samples is a square, and as latin
+hypercubes otherwise. Strata are assigned to samples in a random order for each pixel and dimension, so that the
+dimensions are not correlated.
+
+Samples beyond the number of samples the sampler is created for are stratified again, by groups of this number.
+*/
+type StratifiedSampler struct {
+	pixelSample
+	seed    int64
+	samples int
+}
+
+// NewStratifiedSampler creates a stratified sampler for the given number of samples per pixel
+func NewStratifiedSampler(seed int64, samples int) *StratifiedSampler {
+	if samples < 1 {
+		samples = 1
+	}
+	return &StratifiedSampler{seed: seed, samples: samples}
+}
+
+// Get1D implements the Sampler interface
+func (s *StratifiedSampler) Get1D() float64 {
+	dim := s.next()
+	n := s.samples
+	stratum := permute(uint32(s.index%n), uint32(n), s.hash(s.seed, dim, int64(s.index/n)))
+	return (float64(stratum) + toUnit(s.hash(s.seed, dim, int64(s.index)))) / float64(n)
+}
+
+// Get2D implements the Sampler interface
+func (s *StratifiedSampler) Get2D() (float64, float64) {
+	dim := s.next()
+	s.next()
+	n := s.samples
+	i, group := uint32(s.index%n), int64(s.index/n)
+	jx := toUnit(s.hash(s.seed, dim, int64(s.index), 0))
+	jy := toUnit(s.hash(s.seed, dim, int64(s.index), 1))
+	if m := int(math.Sqrt(float64(n)) + 0.5); m*m == n {
+		stratum := int(permute(i, uint32(n), s.hash(s.seed, dim, group)))
+		return (float64(stratum%m) + jx) / float64(m), (float64(stratum/m) + jy) / float64(m)
+	}
+	sx := permute(i, uint32(n), s.hash(s.seed, dim, group, 0))
+	sy := permute(i, uint32(n), s.hash(s.seed, dim, group, 1))
+	return (float64(sx) + jx) / float64(n), (float64(sy) + jy) / float64(n)
+}
+
+// Clone implements the Sampler interface
+func (s *StratifiedSampler) Clone(seed int64) Sampler {
+	return &StratifiedSampler{seed: s.seed, samples: s.samples}
+}
+
+// primes are the bases of the dimensions of Halton sequences
+var primes = []int{
+	2, 3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71, 73, 79, 83, 89, 97, 101, 103, 107, 109,
+	113, 127, 131, 137, 139, 149, 151, 157, 163, 167, 173, 179, 181, 191, 193, 197, 199, 211, 223, 227, 229, 233, 239,
+	241, 251, 257, 263, 269, 271, 277, 281, 283, 293, 307, 311,
+}
+
+/*
+HaltonSampler takes the samples of a pixel from the Halton sequence, whose dimension d is the radical inverse of the index of
+the sample in the d-th prime base. The digits of the radical inverses are scrambled by random permutations, each depending
+on the digits before it, which breaks the correlations between the dimensions of large bases. Permutations depend on the
+pixel, so that neighbouring pixels don't share the same error.
+
+Halton sequences of large bases are poorly distributed, so dimensions beyond the 64th are random.
+*/
+type HaltonSampler struct {
+	pixelSample
+	seed int64
+}
+
+// NewHaltonSampler creates a Halton sampler
+func NewHaltonSampler(seed int64) *HaltonSampler {
+	return &HaltonSampler{seed: seed}
+}
+
+// Get1D implements the Sampler interface
+func (s *HaltonSampler) Get1D() float64 {
+	dim := s.next()
+	if dim >= len(primes) {
+		return toUnit(s.hash(s.seed, dim, int64(s.index)))
+	}
+	return scrambledRadicalInverse(primes[dim], s.index, s.hash(s.seed, dim))
+}
+
+// Get2D implements the Sampler interface
+func (s *HaltonSampler) Get2D() (float64, float64) {
+	return s.Get1D(), s.Get1D()
+}
+
+// Clone implements the Sampler interface
+func (s *HaltonSampler) Clone(seed int64) Sampler {
+	return NewHaltonSampler(s.seed)
+}
+
+// scrambledRadicalInverse mirrors the digits of i in the given base around the decimal point, each digit being permuted
+// depending on the seed and on the digits before it
+func scrambledRadicalInverse(base, i int, seed uint32) float64 {
+	inv := 1 / float64(base)
+	v, scale := 0.0, inv
+	h := int64(seed)
+	// the leading zeros of i are scrambled too, up to the precision of the result
+	for ; scale > 1e-12; scale *= inv {
+		digit := i % base
+		v += float64(permute(uint32(digit), uint32(base), uint32(h))) * scale
+		h = mixSeed(h, int64(digit))
+		i /= base
+	}
+	// rounding can give 1
+	return math.Min(v, math.Nextafter(1, 0))
+}
+
+/*
+SobolSampler takes the samples of a pixel from the first two dimensions of the Sobol sequence, scrambled by Owen's nested
+uniform scrambling, as described by Brent Burley in Practical Hash-based Owen Scrambling (2020).
+
+Each pair of dimensions of the samples is scrambled with its own seed, and the indices of the samples are shuffled for each
+pair, so that pairs are not correlated. Scrambling depends on the pixel, so that neighbouring pixels don't share the same
+error.
+*/
+type SobolSampler struct {
+	pixelSample
+	seed int64
+}
+
+// NewSobolSampler creates an Owen-scrambled Sobol sampler
+func NewSobolSampler(seed int64) *SobolSampler {
+	return &SobolSampler{seed: seed}
+}
+
+// Get1D implements the Sampler interface
+func (s *SobolSampler) Get1D() float64 {
+	dim := s.next()
+	i := nestedUniformScramble(uint32(s.index), s.hash(s.seed, dim))
+	return toUnit(nestedUniformScramble(bits.Reverse32(i), s.hash(s.seed, dim, 0)))
+}
+
+// Get2D implements the Sampler interface
+func (s *SobolSampler) Get2D() (float64, float64) {
+	dim := s.next()
+	s.next()
+	i := nestedUniformScramble(uint32(s.index), s.hash(s.seed, dim))
+	return toUnit(nestedUniformScramble(bits.Reverse32(i), s.hash(s.seed, dim, 0))),
+		toUnit(nestedUniformScramble(sobol1(i), s.hash(s.seed, dim, 1)))
+}
+
+// Clone implements the Sampler interface
+func (s *SobolSampler) Clone(seed int64) Sampler {
+	return NewSobolSampler(s.seed)
+}
+
+// sobol1 returns the second dimension of the point of index i of the Sobol sequence, as a fixed point fraction
+func sobol1(i uint32) uint32 {
+	var v uint32
+	for d := uint32(1 << 31); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			v ^= d
+		}
+		d ^= d >> 1
+	}
+	return v
+}
+
+// nestedUniformScramble scrambles the bits of a fixed point fraction, each bit being flipped depending on the bits of
+// higher order, as Laine and Karras's hash
+func nestedUniformScramble(x, seed uint32) uint32 {
+	x = bits.Reverse32(x)
+	x += seed
+	x ^= x * 0x6c50b47c
+	x ^= x * 0xb82f1e52
+	x ^= x * 0xc7afe638
+	x ^= x * 0x8d22f6e6
+	return bits.Reverse32(x)
+}
+
+/*
+BlueNoiseSampler takes the samples of all pixels from the same Owen-scrambled Sobol sequence, each pixel shifting it by
+offsets modulo 1 read from a blue noise mask. The error of neighbouring pixels is then as different as possible, and
+noise looks like fine grain rather than blotches, especially at low sample counts. Each dimension reads the mask at a
+different position.
+*/
+type BlueNoiseSampler struct {
+	pixelSample
+	seed int64
+	mask []float64
+}
+
+// NewBlueNoiseSampler creates a blue noise sampler
+func NewBlueNoiseSampler(seed int64) *BlueNoiseSampler {
+	return &BlueNoiseSampler{seed: seed, mask: blueNoise()}
+}
+
+// Get1D implements the Sampler interface
+func (s *BlueNoiseSampler) Get1D() float64 {
+	dim := s.next()
+	i := nestedUniformScramble(uint32(s.index), uint32(mixSeed(s.seed, int64(dim))))
+	v := toUnit(nestedUniformScramble(bits.Reverse32(i), uint32(mixSeed(s.seed, int64(dim), 0))))
+	return s.shift(v, dim, 0)
+}
+
+// Get2D implements the Sampler interface
+func (s *BlueNoiseSampler) Get2D() (float64, float64) {
+	dim := s.next()
+	s.next()
+	i := nestedUniformScramble(uint32(s.index), uint32(mixSeed(s.seed, int64(dim))))
+	u := toUnit(nestedUniformScramble(bits.Reverse32(i), uint32(mixSeed(s.seed, int64(dim), 0))))
+	v := toUnit(nestedUniformScramble(sobol1(i), uint32(mixSeed(s.seed, int64(dim), 1))))
+	return s.shift(u, dim, 0), s.shift(v, dim, 1)
+}
+
+// shift shifts a value by the blue noise of the pixel, read at a position depending on the dimension
+func (s *BlueNoiseSampler) shift(v float64, dim int, axis int64) float64 {
+	offset := uint64(mixSeed(s.seed, int64(dim), axis, -1))
+	x := (s.x + int(offset%blueNoiseSize)) % blueNoiseSize
+	y := (s.y + int(offset/blueNoiseSize%blueNoiseSize)) % blueNoiseSize
+	v += s.mask[y*blueNoiseSize+x]
+	return v - math.Floor(v)
+}
+
+// Clone implements the Sampler interface
+func (s *BlueNoiseSampler) Clone(seed int64) Sampler {
+	return NewBlueNoiseSampler(s.seed)
+}
+
+// blueNoiseSize is the side of the blue noise mask, which is tiled over the image
+const blueNoiseSize = 64
+
+var (
+	blueNoiseOnce sync.Once
+	blueNoiseMask []float64
+)
+
+// blueNoise returns the blue noise mask, which is generated the first time it is needed
+func blueNoise() []float64 {
+	blueNoiseOnce.Do(func() {
+		blueNoiseMask = voidAndCluster(blueNoiseSize, 1.5, rand.New(rand.NewSource(1)))
+	})
+	return blueNoiseMask
+}
+
+/*
+voidAndCluster generates a square blue noise mask of the given side with Ulichney's void-and-cluster method, each value of
+[0, 1) appearing once. The mask tiles seamlessly.
+
+Pixels are ranked by turning them on one after the other, in the largest void of the pixels already on, the voids and the
+clusters being measured by the sum of gaussians of the given standard deviation centered on the pixels which are on.
+*/
+func voidAndCluster(size int, sigma float64, rnd *rand.Rand) []float64 {
+	n := size * size
+	// gaussian of the offsets between pixels, which wrap around the edges
+	kernel := make([]float64, n)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			x, y := float64(wrapDistance(dx, size)), float64(wrapDistance(dy, size))
+			kernel[dy*size+dx] = math.Exp(-(x*x + y*y) / (2 * sigma * sigma))
+		}
+	}
+	on := make([]bool, n)
+	energy := make([]float64, n)
+	toggle := func(p int) {
+		on[p] = !on[p]
+		sign := 1.0
+		if !on[p] {
+			sign = -1
+		}
+		px, py := p%size, p/size
+		for q := range energy {
+			dx, dy := (q%size-px+size)%size, (q/size-py+size)%size
+			energy[q] += sign * kernel[dy*size+dx]
+		}
+	}
+	// tightestCluster returns the pixel on with the highest energy, largestVoid the pixel off with the lowest energy
+	tightestCluster := func() int {
+		best := -1
+		for p := range energy {
+			if on[p] && (best < 0 || energy[p] > energy[best]) {
+				best = p
+			}
+		}
+		return best
+	}
+	largestVoid := func() int {
+		best := -1
+		for p := range energy {
+			if !on[p] && (best < 0 || energy[p] < energy[best]) {
+				best = p
+			}
+		}
+		return best
+	}
+
+	// a tenth of the pixels are turned on at random, and moved from clusters to voids until they are evenly spread
+	initial := n / 10
+	for count := 0; count < initial; {
+		if p := rnd.Intn(n); !on[p] {
+			toggle(p)
+			count++
+		}
+	}
+	for {
+		cluster := tightestCluster()
+		toggle(cluster)
+		void := largestVoid()
+		toggle(void)
+		if void == cluster {
+			break
+		}
+	}
+	initialOn := append([]bool(nil), on...)
+	initialEnergy := append([]float64(nil), energy...)
+
+	rank := make([]int, n)
+	// the pixels of the initial pattern are ranked by turning them off, tightest clusters first
+	for r := initial - 1; r >= 0; r-- {
+		p := tightestCluster()
+		toggle(p)
+		rank[p] = r
+	}
+	// the other pixels are ranked by turning them on, largest voids first
+	copy(on, initialOn)
+	copy(energy, initialEnergy)
+	for r := initial; r < n; r++ {
+		p := largestVoid()
+		toggle(p)
+		rank[p] = r
+	}
+
+	mask := make([]float64, n)
+	for p, r := range rank {
+		mask[p] = (float64(r) + 0.5) / float64(n)
+	}
+	return mask
+}
+
+// wrapDistance returns the distance of an offset to the closest multiple of size
+func wrapDistance(d, size int) int {
+	if d > size/2 {
+		return size - d
+	}
+	return d
+}
+
+/*
+permute returns the element of index i of a random permutation of [0, n) chosen by seed, without building the
+permutation. It is Andrew Kensler's hash in Correlated Multi-Jittered Sampling (2013).
+*/
+func permute(i, n, seed uint32) uint32 {
+	w := n - 1
+	w |= w >> 1
+	w |= w >> 2
+	w |= w >> 4
+	w |= w >> 8
+	w |= w >> 16
+	for {
+		i ^= seed
+		i *= 0xe170893d
+		i ^= seed >> 16
+		i ^= (i & w) >> 4
+		i ^= seed >> 8
+		i *= 0x0929eb3f
+		i ^= seed >> 23
+		i ^= (i & w) >> 1
+		i *= 1 | seed>>27
+		i *= 0x6935fa69
+		i ^= (i & w) >> 11
+		i *= 0x74dcb303
+		i ^= (i & w) >> 2
+		i *= 0x9e501cc3
+		i ^= (i & w) >> 2
+		i *= 0xc860a3df
+		i &= w
+		i ^= i >> 5
+		// values beyond n are permuted again until they fall into [0, n)
+		if i < n {
+			break
+		}
+	}
+	return (i + seed) % n
+}
+
+// toUnit returns the value of [0, 1) of a 32-bit fixed point fraction
+func toUnit(x uint32) float64 {
+	return float64(x) / (1 << 32)
+}
@@ -0,0 +1,87 @@
+package gotrace
+
+import (
+	"math"
+	"testing"
+)
+
+// pixelValues returns the values of the first dimensions of a sample of a pixel
+func pixelValues(s Sampler, x, y, index, dims int) []float64 {
+	s.StartPixelSample(x, y, index)
+	values := make([]float64, 0, dims)
+	for len(values) < dims {
+		u, v := s.Get2D()
+		values = append(values, u, v)
+	}
+	return append(values, s.Get1D())
+}
+
+func TestSamplers(t *testing.T) {
+	for _, name := range SamplerNames {
+		t.Run(name, func(t *testing.T) {
+			sampler, err := NewSampler(name, 3, 16)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, b := sampler.Clone(5), sampler.Clone(5)
+			// the values of samplers other than the independent one only depend on the seed they are created with
+			other, _ := NewSampler(name, 4, 16)
+			other = other.Clone(6)
+			differ := false
+			for index := 0; index < 16; index++ {
+				// samples are drawn in a different order by the clones, as the tiles of renderings
+				want := pixelValues(a, 3, 4, index, 8)
+				pixelValues(b, 4, 3, 15-index, 8)
+				got := pixelValues(b, 3, 4, index, 8)
+				for d, v := range want {
+					if v < 0 || v >= 1 {
+						t.Fatalf("got value %v in dimension %d of sample %d, want a value in [0, 1)", v, d, index)
+					}
+					if name != "independent" && got[d] != v {
+						t.Fatalf("got value %v in dimension %d of sample %d, want %v as given by another clone", got[d], d, index, v)
+					}
+				}
+				if pixelValues(other, 3, 4, index, 8)[2] != want[2] {
+					differ = true
+				}
+			}
+			if !differ {
+				t.Error("samplers of different seeds gave the same samples")
+			}
+		})
+	}
+
+	if _, err := NewSampler("random", 0, 16); err == nil {
+		t.Error("got no error for an unknown sampler")
+	}
+}
+
+// integrationError returns the root mean square error of the estimates of the integral of x * y over the unit square by
+// 16 samples of each of 256 pixels, x and y being given by the dimensions first and first + 1 of the samples
+func integrationError(s Sampler, first int) float64 {
+	squares := 0.0
+	for p := 0; p < 256; p++ {
+		sum := 0.0
+		for index := 0; index < 16; index++ {
+			values := pixelValues(s, p%16, p/16, index, first+2)
+			sum += values[first] * values[first+1]
+		}
+		e := sum/16 - 0.25
+		squares += e * e
+	}
+	return math.Sqrt(squares / 256)
+}
+
+func TestSamplersConvergence(t *testing.T) {
+	independent := NewIndependentSampler(3)
+	for _, first := range []int{0, 4} {
+		reference := integrationError(independent, first)
+		for _, name := range SamplerNames[1:] {
+			sampler, _ := NewSampler(name, 3, 16)
+			// samplers spreading samples evenly estimate integrals better than random samples
+			if e := integrationError(sampler.Clone(5), first); e > 0.7*reference {
+				t.Errorf("%s: got an error of %.4f in dimensions %d and %d, want less than 0.7 times the %.4f of random samples", name, e, first, first+1, reference)
+			}
+		}
+	}
+}
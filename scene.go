@@ -1,104 +1,140 @@
 package gotrace
 
 import (
-	"context"
-	"image"
 	"math"
 	"math/rand"
-	"runtime"
 
-	"github.com/cheggaaa/pb/v3"
-	"github.com/ojrac/opensimplex-go"
 	"github.com/teobouvard/gotrace/util"
-	"golang.org/x/sync/semaphore"
 )
 
 // Scene is the whole scene to be rendered
 type Scene struct {
-	world      *Index
+	world      *FlatIndex
+	objects    Collection
 	camera     Camera
 	background Vec3
+	// actors sampled as lights, and whether each actor is one of them, by ID
+	lights  []Actor
+	isLight []bool
+	// default image size and tone mapper, as given by scene files
+	width, height int
+	toneMapper    ToneMapper
 }
 
 // NewScene creates a scene that can be rendered. It contains all actors in the world collection, and is viewed from the camera.
+// Actors are given IDs from 1 in the order of the collection, which are reported in the hit records.
 func NewScene(camera Camera, world Collection, background Vec3) *Scene {
+	// actors are identified by their position in the world, starting at 1
+	objects := make(Collection, len(world))
+	for i, actor := range world {
+		actor.id = i + 1
+		objects[i] = actor
+	}
+	lights, isLight := lights(objects)
 	return &Scene{
-		world:      NewIndex(world, 0, len(world)-1, camera.tStart, camera.tStop),
+		world:      NewFlatIndex(objects, camera.tStart, camera.tStop, SAHIndex),
+		objects:    objects,
 		camera:     camera,
 		background: background,
+		lights:     lights,
+		isLight:    isLight,
 	}
 
 }
 
-func (s *Scene) rayColor(ray Ray, depth int) Vec3 {
-	if depth <= 0 {
-		// too many scattered bounces, assume absorption
-		return BLACK
-	}
-
-	if hit, record := s.world.Hit(ray, 0.001, math.MaxFloat64); hit {
-		emitted := record.Material.Emit(record.U, record.V, record.Position)
-		if scatters, attenuation, scattered := record.Material.Scatter(ray, *record); scatters {
-			return emitted.Add(attenuation.Mul(s.rayColor(scattered, depth-1)))
-		}
-		return emitted
-	}
-
-	return s.background
+// Reindex rebuilds the bounding volume hierarchy of the actors of the scene with the given strategy
+func (s *Scene) Reindex(strategy IndexStrategy) {
+	s.world = NewFlatIndex(s.objects, s.camera.tStart, s.camera.tStop, strategy)
 }
 
-// Render renders the scene with the given parameters
-func (s *Scene) Render(width, height, pixelSamples, maxScatter int) *image.RGBA {
-	// set default value for max number of ray bounces before absorption
-	if maxScatter <= 0 {
-		maxScatter = 50
+// ToneMapper returns the tone mapper given by the scene file, or the default SRGB tone mapper
+func (s *Scene) ToneMapper() ToneMapper {
+	if s.toneMapper == nil {
+		return SRGB{}
 	}
+	return s.toneMapper
+}
 
-	if pixelSamples <= 0 {
-		pixelSamples = 50
-	}
+/*
+rayColor returns the light coming along a camera ray, and counts the traced rays in rays.
+
+The path of the ray is traced bounce after bounce, throughput being the attenuation by the previous bounces of the light
+coming along the current ray, and radiance the light gathered so far. Lights are sampled at each bounce, and the light
+they emit is weighted against this sampling when scattered rays hit them, unless the rays were scattered in a single
+direction. After opts.RouletteDepth bounces, paths are terminated by Russian roulette.
+
+The values of the AOVs at the first hit are stored into values, which are left untouched if the ray misses the scene.
+The hits and the BSDFs of the bounces are stored into scratch, so that tracing the path doesn't allocate.
+*/
+func (s *Scene) rayColor(ray Ray, opts RenderOptions, aovs []AOV, values []Vec3, scratch *pathScratch, rays *int64) Vec3 {
+	radiance, throughput := BLACK, WHITE
+	// density of the direction of the current ray, 0 for camera rays and rays scattered in a single direction
+	scatterPdf := 0.0
+	for bounce := 0; bounce < opts.MaxScatter; bounce++ {
+		*rays++
+		record := &scratch.hit
+		if !s.world.hitRecord(ray, 0.001, math.MaxFloat64, record) {
+			return radiance.Add(throughput.Mul(s.background))
+		}
+		if bounce == 0 && len(aovs) > 0 {
+			s.aovValues(ray, record, aovs, values)
+		}
 
-	// deduce height from aspect ratio
-	if height == -1 {
-		height = int(float64(width) / s.camera.AspectRatio)
-	}
+		emitted := record.Material.Emit(record.U, record.V, record.Position)
+		if scatterPdf > 0 && s.isLight[record.ActorID] {
+			emitted = emitted.Scale(powerHeuristic(scatterPdf, s.lightPdf(ray, record)))
+		}
+		radiance = radiance.Add(throughput.Mul(emitted))
+		bsdf := scratch.bsdfs.bsdf(ray, record)
+		if bsdf == nil {
+			break
+		}
+		// lights are sampled at each bounce, unless the scattered ray couldn't reach them anyway
+		if len(s.lights) > 0 && bounce < opts.MaxScatter-1 {
+			radiance = radiance.Add(throughput.Mul(s.sampleLight(ray, record, bsdf, &scratch.shadow, rays)))
+		}
 
-	// create image
-	upLeft := image.Point{0, 0}
-	lowRight := image.Point{width, height}
-	img := image.NewRGBA(image.Rectangle{upLeft, lowRight})
-
-	// create workgroup to render one line per available thread
-	ctx := context.TODO()
-	nWorkers := int64(runtime.NumCPU())
-	sem := semaphore.NewWeighted(nWorkers)
-	bar := pb.StartNew(height)
-	for j := 0; j < height; j++ {
-		sem.Acquire(ctx, 1) // why not in goroutine ?
-		go func(j int) {
-			defer sem.Release(1)
-			rnd := rand.New(rand.NewSource(int64(42 * j)))
-			for i := 0; i < width; i++ {
-				pixel := BLACK
-				for k := 0; k < pixelSamples; k++ {
-					u := (float64(i) + rnd.Float64()) / float64(width)
-					v := (float64(j) + rnd.Float64()) / float64(height)
-					ray := s.camera.RayTo(u, v, rnd)
-					pixel = pixel.Add(s.rayColor(ray, maxScatter))
-				}
-				// set image color
-				imgColor := pixel.GetColor(pixelSamples)
-				// why isn't there a data race if access to the image is not protected ?
-				img.Set(i, height-j-1, imgColor)
+		wi, f, pdf, flags := bsdf.Sample(ray.Direction.Unit().Neg(), ray.Sampler)
+		if flags == 0 || pdf == 0 {
+			break
+		}
+		throughput = throughput.Mul(f.Scale(1 / pdf))
+		scatterPdf = pdf
+		if flags.IsSpecular() {
+			// lights can't be sampled in the direction of specular lobes, the light they reflect is fully counted
+			scatterPdf = 0
+		}
+		if bounce >= opts.RouletteDepth {
+			// the path goes on with a probability given by its throughput, and is weighted by the inverse of this probability,
+			// so that dark paths stop early without biasing the image
+			survival := math.Min(1, throughput.MaxComponent())
+			if ray.Sampler.Get1D() >= survival {
+				break
 			}
-			bar.Increment()
-		}(j)
+			throughput = throughput.Scale(1 / survival)
+		}
+		ray = Ray{record.Position, wi, ray.Time, ray.Sampler}
 	}
+	// the path was absorbed, or made too many bounces
+	return radiance
+}
 
-	// wait for all workers to exit
-	sem.Acquire(ctx, nWorkers)
-	bar.Finish()
-	return img
+// pathScratch holds the hits and the BSDFs of the bounces of the paths traced by a worker, which reuse them
+type pathScratch struct {
+	hit, shadow HitRecord
+	bsdfs       bsdfStorage
+}
+
+// Scenes are the built-in scenes, by name
+var Scenes = map[string]func() *Scene{
+	"book":          BookScene,
+	"moving":        MovingSpheres,
+	"marble":        MarbleScene,
+	"earth":         EarthScene,
+	"light_marble":  LightMarbleScene,
+	"cornell":       CornellBox,
+	"foggy_cornell": FoggyCornellBox,
+	"final":         FinalScene,
 }
 
 // BookScene creates the scene on the cover of the first book
@@ -129,8 +165,8 @@ func BookScene() *Scene {
 
 	for a := -11; a < 11; a++ {
 		for b := -11; b < 11; b++ {
-			center := Vec3{float64(a) + 0.9*rand.Float64(), 0.2, float64(b) + 0.9*rand.Float64()}
-			randMaterial := rand.Float64()
+			center := Vec3{float64(a) + 0.9*rnd.Float64(), 0.2, float64(b) + 0.9*rnd.Float64()}
+			randMaterial := rnd.Float64()
 			noBalls := Vec3{4, 0.2, 0}
 			if center.Sub(noBalls).Norm() > 0.9 {
 				if randMaterial < 0.8 {
@@ -149,7 +185,7 @@ func BookScene() *Scene {
 				} else if randMaterial < 0.95 {
 					//metal
 					albedo := RandVecInterval(0.5, 1.0, rnd)
-					fuzz := rand.Float64() / 2
+					fuzz := rnd.Float64() / 2
 					actor := Actor{
 						shape: Sphere{
 							Center: center,
@@ -246,8 +282,8 @@ func MovingSpheres() *Scene {
 
 	for a := -10; a < 10; a++ {
 		for b := -10; b < 10; b++ {
-			center := Vec3{float64(a) + 0.9*rand.Float64(), 0.2, float64(b) + 0.9*rand.Float64()}
-			randMaterial := rand.Float64()
+			center := Vec3{float64(a) + 0.9*rnd.Float64(), 0.2, float64(b) + 0.9*rnd.Float64()}
+			randMaterial := rnd.Float64()
 			noBalls := Vec3{4, 0.2, 0}
 			if center.Sub(noBalls).Norm() > 0.9 {
 				if randMaterial < 0.8 {
@@ -256,7 +292,7 @@ func MovingSpheres() *Scene {
 					actor := Actor{
 						shape: MovingSphere{
 							CenterStart: center,
-							CenterStop:  center.Add(Vec3{Y: rand.Float64() / 2.0}),
+							CenterStop:  center.Add(Vec3{Y: rnd.Float64() / 2.0}),
 							tStart:      startTime,
 							tStop:       endTime,
 							Radius:      0.2,
@@ -269,7 +305,7 @@ func MovingSpheres() *Scene {
 				} else if randMaterial < 0.95 {
 					//metal
 					albedo := RandVecInterval(0.5, 1.0, rnd)
-					fuzz := rand.Float64() / 2
+					fuzz := rnd.Float64() / 2
 					actor := Actor{
 						shape: Sphere{
 							Center: center,
@@ -350,12 +386,7 @@ func MarbleScene() *Scene {
 				Radius: 2,
 			},
 			material: Lambertian{
-				Marble{
-					noise:      opensimplex.New(51),
-					depth:      7,
-					turbulence: 5,
-					scale:      4,
-				},
+				NewMarble(51, 7, 5, 4),
 			},
 		},
 	}
@@ -427,12 +458,7 @@ func LightMarbleScene() *Scene {
 				Radius: 2,
 			},
 			material: Lambertian{
-				Marble{
-					noise:      opensimplex.New(51),
-					depth:      7,
-					turbulence: 5,
-					scale:      4,
-				},
+				NewMarble(51, 7, 5, 4),
 			},
 		},
 		Actor{
@@ -678,12 +704,7 @@ func FinalScene() *Scene {
 		Actor{
 			shape: Sphere{Vec3{220, 280, 300}, 80},
 			material: Lambertian{
-				Marble{
-					noise:      opensimplex.New(42),
-					depth:      7,
-					turbulence: 11,
-					scale:      0.005,
-				},
+				NewMarble(42, 7, 11, 0.005),
 			},
 		},
 		// earth
@@ -697,6 +718,7 @@ func FinalScene() *Scene {
 	groundMaterial := Lambertian{ConstantTexture{Vec3{0, 0.2, 0.2}}}
 	//groundMaterial := Lambertian{ConstantTexture{WHITE.Scale(0.2)}}
 	const boxesPerSide int = 20
+	rnd := rand.New(rand.NewSource(42))
 	for i := 0; i < boxesPerSide; i++ {
 		for j := 0; j < boxesPerSide; j++ {
 			w := 100.0
@@ -705,9 +727,9 @@ func FinalScene() *Scene {
 			z0 := -1000.0 + float64(j)*w
 			z1 := z0 + w
 			y0 := 0.0
-			y1 := util.Map(rand.Float64(), 0, 1, 1, 100)
+			y1 := util.Map(rnd.Float64(), 0, 1, 1, 100)
 			box := NewBox(Vec3{x0, y0, z0}, Vec3{x1, y1, z1})
-			objects.Add(Actor{box, groundMaterial})
+			objects.Add(Actor{shape: box, material: groundMaterial})
 		}
 	}
 
@@ -722,7 +744,7 @@ func FinalScene() *Scene {
 		center.X = center.X*math.Cos(rotation) - center.Z*math.Sin(rotation)
 		center.Z = center.Z*math.Cos(rotation) + center.X*math.Sin(rotation)
 		sphere := Sphere{center.Add(offset), 10}
-		objects.Add(Actor{sphere, whitish})
+		objects.Add(Actor{shape: sphere, material: whitish})
 	}
 
 	return NewScene(camera, objects, BLACK)
@@ -0,0 +1,54 @@
+package gotrace
+
+import (
+	_ "image/jpeg"
+	_ "image/png"
+	"testing"
+)
+
+// sampleTracer returns a function tracing the paths of n samples of the scene, one per pixel of a 32x32 image, with the
+// default options
+func sampleTracer(tb testing.TB, name string) func(n int) {
+	scene := Scenes[name]()
+	opts, err := scene.withDefaults(RenderOptions{Width: 32, Height: 32})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	sampler := opts.Sampler.Clone(1)
+	scratch := &pathScratch{}
+	var rays int64
+	sample := 0
+	return func(n int) {
+		for i := 0; i < n; i++ {
+			x, y := sample%32, sample/32%32
+			sampler.StartPixelSample(x, y, sample/1024)
+			ray := scene.camera.RayTo((float64(x)+0.5)/32, (32-float64(y)-0.5)/32, sampler)
+			scene.rayColor(ray, opts, nil, nil, scratch, &rays)
+			sample++
+		}
+	}
+}
+
+// BenchmarkRayColor traces the paths of samples of the built-in scenes, an operation being a sample
+func BenchmarkRayColor(b *testing.B) {
+	for _, name := range []string{"cornell", "foggy_cornell", "final"} {
+		b.Run(name, func(b *testing.B) {
+			trace := sampleTracer(b, name)
+			b.ReportAllocs()
+			b.ResetTimer()
+			trace(b.N)
+		})
+	}
+}
+
+func TestRayColorAllocations(t *testing.T) {
+	// paths going through boxes, fog, meshes of triangles and every material of the package
+	for _, name := range []string{"cornell", "foggy_cornell", "final"} {
+		t.Run(name, func(t *testing.T) {
+			trace := sampleTracer(t, name)
+			if allocs := testing.AllocsPerRun(20, func() { trace(1024) }); allocs > 0 {
+				t.Errorf("got %v allocations for 1024 samples, want none", allocs)
+			}
+		})
+	}
+}
@@ -0,0 +1,950 @@
+package gotrace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+LoadScene reads a scene description from r and builds the corresponding Scene.
+
+The description is a YAML document with the following sections
+
+	image:        width and height of the rendered image, 400 pixels wide by default
+	camera:       look_from, look_at, up, fov, aspect_ratio, focus_distance, aperture, start, end
+	background:   color of rays escaping the scene
+	tone_mapping: srgb, reinhard, aces or hable, with an exposure and the white point of reinhard and hable
+	objects:      list of actors, each one having a shape and a material
+
+Vectors are given either as a mapping of their x, y, z coordinates (missing ones are zero) or as a list of three numbers.
+Shapes, materials and textures are mappings with a single key naming their type, for example
+
+	objects:
+	  - sphere: {center: [0, 1, 0], radius: 1}
+	    material:
+	      lambertian:
+	        albedo: {checker: {odd: [0, 0, 0], even: [1, 1, 1], frequency: 10}}
+
+See example_scenes/scene.yaml for all available types.
+*/
+func LoadScene(r io.Reader) (*Scene, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("empty scene description")
+		}
+		return nil, err
+	}
+	return decodeScene(doc.Content[0])
+}
+
+// SceneError is an error in a scene description, located by its line number
+type SceneError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SceneError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+func errorAt(n *yaml.Node, format string, args ...interface{}) error {
+	return &SceneError{Line: n.Line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// resolve follows YAML aliases, so that anchors can be used to share materials between objects
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	return n
+}
+
+// fields checks that n is a mapping containing only known keys, and returns its values by key
+func fields(n *yaml.Node, known ...string) (map[string]*yaml.Node, error) {
+	n = resolve(n)
+	if n.Kind != yaml.MappingNode {
+		return nil, errorAt(n, "expected a mapping")
+	}
+	values := make(map[string]*yaml.Node, len(n.Content)/2)
+	for i := 0; i < len(n.Content); i += 2 {
+		key := n.Content[i]
+		if !contains(known, key.Value) {
+			return nil, errorAt(key, "unknown key %q", key.Value)
+		}
+		if _, exists := values[key.Value]; exists {
+			return nil, errorAt(key, "duplicate key %q", key.Value)
+		}
+		values[key.Value] = resolve(n.Content[i+1])
+	}
+	return values, nil
+}
+
+// variant decodes a mapping having a single key, which names the type of the value
+func variant(n *yaml.Node, known ...string) (string, *yaml.Node, error) {
+	values, err := fields(n, known...)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(values) != 1 {
+		return "", nil, errorAt(n, "expected exactly one of %v", known)
+	}
+	for kind, value := range values {
+		return kind, value, nil
+	}
+	panic("unreachable")
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeFloat(n *yaml.Node) (float64, error) {
+	var f float64
+	if n.Kind != yaml.ScalarNode {
+		return 0, errorAt(n, "expected a number")
+	}
+	// plain numbers are parsed directly, as YAML reads -0 as the integer 0
+	if f, err := strconv.ParseFloat(n.Value, 64); err == nil && n.ShortTag() != "!!str" {
+		return f, nil
+	}
+	if n.Decode(&f) != nil {
+		return 0, errorAt(n, "expected a number, got %q", n.Value)
+	}
+	return f, nil
+}
+
+func decodeInt(n *yaml.Node) (int, error) {
+	var i int
+	if n.Kind != yaml.ScalarNode || n.Decode(&i) != nil {
+		return 0, errorAt(n, "expected an integer, got %q", n.Value)
+	}
+	return i, nil
+}
+
+func decodeString(n *yaml.Node) (string, error) {
+	if n.Kind != yaml.ScalarNode || n.ShortTag() != "!!str" {
+		return "", errorAt(n, "expected a string, got %q", n.Value)
+	}
+	return n.Value, nil
+}
+
+func decodeVec(n *yaml.Node) (Vec3, error) {
+	var (
+		coords [3]float64
+		err    error
+	)
+	if n.Kind == yaml.SequenceNode {
+		if len(n.Content) != 3 {
+			return Vec3{}, errorAt(n, "expected 3 coordinates, got %d", len(n.Content))
+		}
+		for i, c := range n.Content {
+			if coords[i], err = decodeFloat(resolve(c)); err != nil {
+				return Vec3{}, err
+			}
+		}
+		return Vec3{coords[0], coords[1], coords[2]}, nil
+	}
+	if n.Kind != yaml.MappingNode {
+		return Vec3{}, errorAt(n, "expected a vector")
+	}
+	values, err := fields(n, "x", "y", "z")
+	if err != nil {
+		return Vec3{}, err
+	}
+	for i, key := range []string{"x", "y", "z"} {
+		if value, ok := values[key]; ok {
+			if coords[i], err = decodeFloat(value); err != nil {
+				return Vec3{}, err
+			}
+		}
+	}
+	return Vec3{coords[0], coords[1], coords[2]}, nil
+}
+
+// decoding helpers for optional and required keys of a mapping
+
+func optFloat(values map[string]*yaml.Node, key string, def float64) (float64, error) {
+	if n, ok := values[key]; ok {
+		return decodeFloat(n)
+	}
+	return def, nil
+}
+
+func optInt(values map[string]*yaml.Node, key string, def int) (int, error) {
+	if n, ok := values[key]; ok {
+		return decodeInt(n)
+	}
+	return def, nil
+}
+
+func optVec(values map[string]*yaml.Node, key string, def Vec3) (Vec3, error) {
+	if n, ok := values[key]; ok {
+		return decodeVec(n)
+	}
+	return def, nil
+}
+
+func needKey(parent *yaml.Node, values map[string]*yaml.Node, key string) (*yaml.Node, error) {
+	if n, ok := values[key]; ok {
+		return n, nil
+	}
+	return nil, errorAt(parent, "missing key %q", key)
+}
+
+func needFloat(parent *yaml.Node, values map[string]*yaml.Node, key string) (float64, error) {
+	n, err := needKey(parent, values, key)
+	if err != nil {
+		return 0, err
+	}
+	return decodeFloat(n)
+}
+
+func needVec(parent *yaml.Node, values map[string]*yaml.Node, key string) (Vec3, error) {
+	n, err := needKey(parent, values, key)
+	if err != nil {
+		return Vec3{}, err
+	}
+	return decodeVec(n)
+}
+
+// sceneDecoder holds the state needed while decoding the objects of a scene
+type sceneDecoder struct {
+	tStart, tStop float64
+}
+
+func decodeScene(n *yaml.Node) (*Scene, error) {
+	values, err := fields(n, "image", "camera", "background", "tone_mapping", "objects")
+	if err != nil {
+		return nil, err
+	}
+
+	var width, height int
+	if image, ok := values["image"]; ok {
+		if width, height, err = decodeImage(image); err != nil {
+			return nil, err
+		}
+	}
+
+	cameraNode, err := needKey(n, values, "camera")
+	if err != nil {
+		return nil, err
+	}
+	camera, err := decodeCamera(cameraNode, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	background, err := optVec(values, "background", BLACK)
+	if err != nil {
+		return nil, err
+	}
+
+	var toneMapper ToneMapper
+	if toneMapping, ok := values["tone_mapping"]; ok {
+		if toneMapper, err = decodeToneMapper(toneMapping); err != nil {
+			return nil, err
+		}
+	}
+
+	objectsNode, err := needKey(n, values, "objects")
+	if err != nil {
+		return nil, err
+	}
+	if objectsNode.Kind != yaml.SequenceNode || len(objectsNode.Content) == 0 {
+		return nil, errorAt(objectsNode, "expected a non-empty list of objects")
+	}
+	d := sceneDecoder{camera.tStart, camera.tStop}
+	world := Collection{}
+	for _, objectNode := range objectsNode.Content {
+		actor, err := d.decodeActor(resolve(objectNode))
+		if err != nil {
+			return nil, err
+		}
+		world.Add(actor)
+	}
+
+	scene := NewScene(camera, world, background)
+	scene.width = width
+	scene.height = height
+	scene.toneMapper = toneMapper
+	return scene, nil
+}
+
+func decodeImage(n *yaml.Node) (width, height int, err error) {
+	values, err := fields(n, "width", "height")
+	if err != nil {
+		return 0, 0, err
+	}
+	for key, dim := range map[string]*int{"width": &width, "height": &height} {
+		if value, ok := values[key]; ok {
+			if *dim, err = decodeInt(value); err != nil {
+				return 0, 0, err
+			}
+			if *dim <= 0 {
+				return 0, 0, errorAt(value, "image %s must be positive", key)
+			}
+		}
+	}
+	return width, height, nil
+}
+
+func decodeToneMapper(n *yaml.Node) (ToneMapper, error) {
+	// a plain name is a shorthand for the default settings
+	if n.Kind == yaml.ScalarNode {
+		if !contains(ToneMapperNames, n.Value) {
+			return nil, errorAt(n, "expected one of %v", ToneMapperNames)
+		}
+		return NewToneMapper(n.Value, 0, 0)
+	}
+	kind, n, err := variant(n, ToneMapperNames...)
+	if err != nil {
+		return nil, err
+	}
+	known := []string{"exposure"}
+	if kind == "reinhard" || kind == "hable" {
+		known = append(known, "white")
+	}
+	values, err := fields(n, known...)
+	if err != nil {
+		return nil, err
+	}
+	exposure, err := optFloat(values, "exposure", 0)
+	if err != nil {
+		return nil, err
+	}
+	white, err := optFloat(values, "white", 0)
+	if err != nil {
+		return nil, err
+	}
+	return NewToneMapper(kind, exposure, white)
+}
+
+func decodeCamera(n *yaml.Node, width, height int) (Camera, error) {
+	values, err := fields(n, "look_from", "look_at", "up", "fov", "aspect_ratio", "focus_distance", "aperture", "start", "end")
+	if err != nil {
+		return Camera{}, err
+	}
+	lookFrom, err := needVec(n, values, "look_from")
+	if err != nil {
+		return Camera{}, err
+	}
+	lookAt, err := needVec(n, values, "look_at")
+	if err != nil {
+		return Camera{}, err
+	}
+	if lookFrom == lookAt {
+		return Camera{}, errorAt(n, "look_from and look_at must be different points")
+	}
+	up, err := optVec(values, "up", Vec3{Y: 1})
+	if err != nil {
+		return Camera{}, err
+	}
+	if up.Cross(lookFrom.Sub(lookAt)) == BLACK {
+		return Camera{}, errorAt(n, "up must not be aligned with the viewing direction")
+	}
+	fov, err := needFloat(n, values, "fov")
+	if err != nil {
+		return Camera{}, err
+	}
+	if fov <= 0 || fov >= 180 {
+		return Camera{}, errorAt(values["fov"], "fov must be between 0 and 180 degrees")
+	}
+
+	// the aspect ratio is deduced from the image size if not given
+	aspectRatio := 0.0
+	if width > 0 && height > 0 {
+		aspectRatio = float64(width) / float64(height)
+	}
+	if aspectRatio, err = optFloat(values, "aspect_ratio", aspectRatio); err != nil {
+		return Camera{}, err
+	}
+	if aspectRatio <= 0 {
+		return Camera{}, errorAt(n, "missing aspect_ratio, or image width and height")
+	}
+
+	focusDist, err := optFloat(values, "focus_distance", 10)
+	if err != nil {
+		return Camera{}, err
+	}
+	aperture, err := optFloat(values, "aperture", 0)
+	if err != nil {
+		return Camera{}, err
+	}
+	start, err := optFloat(values, "start", 0)
+	if err != nil {
+		return Camera{}, err
+	}
+	end, err := optFloat(values, "end", 1)
+	if err != nil {
+		return Camera{}, err
+	}
+	if end < start {
+		return Camera{}, errorAt(n, "camera end must not be before start")
+	}
+	return NewCamera(lookFrom, lookAt, up, fov, aspectRatio, aperture, focusDist, start, end), nil
+}
+
+var shapeKinds = []string{"sphere", "moving_sphere", "rect_xy", "rect_xz", "rect_yz", "box", "triangle", "mesh", "ply", "stl", "flip_face", "translate", "rotate_y", "fog"}
+
+func (d sceneDecoder) decodeActor(n *yaml.Node) (Actor, error) {
+	values, err := fields(n, append(shapeKinds[:len(shapeKinds):len(shapeKinds)], "material")...)
+	if err != nil {
+		return Actor{}, err
+	}
+	materialNode, err := needKey(n, values, "material")
+	if err != nil {
+		return Actor{}, err
+	}
+	delete(values, "material")
+	if len(values) != 1 {
+		return Actor{}, errorAt(n, "expected exactly one shape among %v", shapeKinds)
+	}
+	var shape Geometry
+	for kind, value := range values {
+		if shape, err = d.decodeShapeKind(kind, value); err != nil {
+			return Actor{}, err
+		}
+	}
+	material, err := decodeMaterial(materialNode)
+	if err != nil {
+		return Actor{}, err
+	}
+	return Actor{shape: shape, material: material}, nil
+}
+
+func (d sceneDecoder) decodeShape(n *yaml.Node) (Geometry, error) {
+	kind, value, err := variant(n, shapeKinds...)
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeShapeKind(kind, value)
+}
+
+func (d sceneDecoder) decodeShapeKind(kind string, n *yaml.Node) (Geometry, error) {
+	switch kind {
+	case "sphere":
+		values, err := fields(n, "center", "radius")
+		if err != nil {
+			return nil, err
+		}
+		center, err := needVec(n, values, "center")
+		if err != nil {
+			return nil, err
+		}
+		radius, err := needFloat(n, values, "radius")
+		if err != nil {
+			return nil, err
+		}
+		return Sphere{center, radius}, nil
+
+	case "moving_sphere":
+		values, err := fields(n, "center_start", "center_stop", "radius", "start", "stop")
+		if err != nil {
+			return nil, err
+		}
+		centerStart, err := needVec(n, values, "center_start")
+		if err != nil {
+			return nil, err
+		}
+		centerStop, err := needVec(n, values, "center_stop")
+		if err != nil {
+			return nil, err
+		}
+		radius, err := needFloat(n, values, "radius")
+		if err != nil {
+			return nil, err
+		}
+		// the sphere moves during the camera's exposure by default
+		start, err := optFloat(values, "start", d.tStart)
+		if err != nil {
+			return nil, err
+		}
+		stop, err := optFloat(values, "stop", d.tStop)
+		if err != nil {
+			return nil, err
+		}
+		if stop <= start {
+			return nil, errorAt(n, "moving sphere must stop after it starts")
+		}
+		return MovingSphere{centerStart, centerStop, radius, start, stop}, nil
+
+	case "rect_xy", "rect_xz", "rect_yz":
+		// names of the bounds depend on the plane of the rectangle
+		a, b := string(kind[5]), string(kind[6])
+		keys := []string{a + "0", a + "1", b + "0", b + "1", "k"}
+		values, err := fields(n, keys...)
+		if err != nil {
+			return nil, err
+		}
+		var bounds [5]float64
+		for i, key := range keys {
+			if bounds[i], err = needFloat(n, values, key); err != nil {
+				return nil, err
+			}
+		}
+		if bounds[0] >= bounds[1] || bounds[2] >= bounds[3] {
+			return nil, errorAt(n, "rectangle lower bounds must be smaller than upper bounds")
+		}
+		switch kind {
+		case "rect_xy":
+			return RectXY{bounds[0], bounds[1], bounds[2], bounds[3], bounds[4]}, nil
+		case "rect_xz":
+			return RectXZ{bounds[0], bounds[1], bounds[2], bounds[3], bounds[4]}, nil
+		default:
+			return RectYZ{bounds[0], bounds[1], bounds[2], bounds[3], bounds[4]}, nil
+		}
+
+	case "box":
+		values, err := fields(n, "min", "max")
+		if err != nil {
+			return nil, err
+		}
+		min, err := needVec(n, values, "min")
+		if err != nil {
+			return nil, err
+		}
+		max, err := needVec(n, values, "max")
+		if err != nil {
+			return nil, err
+		}
+		if min.X >= max.X || min.Y >= max.Y || min.Z >= max.Z {
+			return nil, errorAt(n, "box min must be smaller than max on all axes")
+		}
+		return NewBox(min, max), nil
+
+	case "triangle":
+		values, err := fields(n, "vertices", "normals", "uvs")
+		if err != nil {
+			return nil, err
+		}
+		verticesNode, err := needKey(n, values, "vertices")
+		if err != nil {
+			return nil, err
+		}
+		vertices, err := decodeVecs(verticesNode, 3)
+		if err != nil {
+			return nil, err
+		}
+		normals, uvs, err := decodeVertexAttributes(values, 3)
+		if err != nil {
+			return nil, err
+		}
+		return Triangle{[3]Vec3{vertices[0], vertices[1], vertices[2]}, normals, uvs}, nil
+
+	case "mesh":
+		values, err := fields(n, "vertices", "indices", "normals", "uvs", "colors")
+		if err != nil {
+			return nil, err
+		}
+		verticesNode, err := needKey(n, values, "vertices")
+		if err != nil {
+			return nil, err
+		}
+		vertices, err := decodeVecs(verticesNode, -1)
+		if err != nil {
+			return nil, err
+		}
+		indicesNode, err := needKey(n, values, "indices")
+		if err != nil {
+			return nil, err
+		}
+		indices, err := decodeInts(indicesNode)
+		if err != nil {
+			return nil, err
+		}
+		normals, uvs, err := decodeVertexAttributes(values, len(vertices))
+		if err != nil {
+			return nil, err
+		}
+		mesh, err := NewMesh(vertices, indices, normals, uvs)
+		if err != nil {
+			return nil, errorAt(n, "%v", err)
+		}
+		if colorsNode, ok := values["colors"]; ok {
+			colors, err := decodeVecs(colorsNode, len(vertices))
+			if err != nil {
+				return nil, err
+			}
+			if err := mesh.SetColors(colors); err != nil {
+				return nil, errorAt(colorsNode, "%v", err)
+			}
+		}
+		return mesh, nil
+
+	case "ply", "stl":
+		values, err := fields(n, "file")
+		if err != nil {
+			return nil, err
+		}
+		fileNode, err := needKey(n, values, "file")
+		if err != nil {
+			return nil, err
+		}
+		file, err := decodeString(fileNode)
+		if err != nil {
+			return nil, err
+		}
+		load := LoadPLY
+		if kind == "stl" {
+			load = LoadSTL
+		}
+		mesh, err := load(file)
+		if err != nil {
+			return nil, errorAt(fileNode, "%v", err)
+		}
+		return mesh, nil
+
+	case "flip_face":
+		shape, err := d.decodeShape(n)
+		if err != nil {
+			return nil, err
+		}
+		return FlipFace{shape}, nil
+
+	case "translate":
+		values, err := fields(n, "offset", "shape")
+		if err != nil {
+			return nil, err
+		}
+		offset, err := needVec(n, values, "offset")
+		if err != nil {
+			return nil, err
+		}
+		shape, err := d.decodeWrapped(n, values)
+		if err != nil {
+			return nil, err
+		}
+		return Translate{shape, offset}, nil
+
+	case "rotate_y":
+		values, err := fields(n, "angle", "shape")
+		if err != nil {
+			return nil, err
+		}
+		angle, err := needFloat(n, values, "angle")
+		if err != nil {
+			return nil, err
+		}
+		shape, err := d.decodeWrapped(n, values)
+		if err != nil {
+			return nil, err
+		}
+		if bounded, _ := shape.Bound(d.tStart, d.tStop); !bounded {
+			return nil, errorAt(n, "rotated shape must be bounded")
+		}
+		return NewRotateY(shape, angle), nil
+
+	case "fog":
+		values, err := fields(n, "density", "boundary")
+		if err != nil {
+			return nil, err
+		}
+		density, err := needFloat(n, values, "density")
+		if err != nil {
+			return nil, err
+		}
+		if density <= 0 {
+			return nil, errorAt(values["density"], "fog density must be positive")
+		}
+		boundaryNode, err := needKey(n, values, "boundary")
+		if err != nil {
+			return nil, err
+		}
+		boundary, err := d.decodeShape(boundaryNode)
+		if err != nil {
+			return nil, err
+		}
+		return Fog{boundary, density}, nil
+	}
+	return nil, errorAt(n, "unknown shape %q", kind)
+}
+
+// decodeVecs decodes a list of vectors, which must have the given length unless it is negative
+func decodeVecs(n *yaml.Node, length int) ([]Vec3, error) {
+	n = resolve(n)
+	if n.Kind != yaml.SequenceNode {
+		return nil, errorAt(n, "expected a list of vectors")
+	}
+	if length >= 0 && len(n.Content) != length {
+		return nil, errorAt(n, "expected %d vectors, got %d", length, len(n.Content))
+	}
+	vecs := make([]Vec3, len(n.Content))
+	for i, c := range n.Content {
+		var err error
+		if vecs[i], err = decodeVec(resolve(c)); err != nil {
+			return nil, err
+		}
+	}
+	return vecs, nil
+}
+
+// decodeUVs decodes a list of texture coordinates, given as [u, v] pairs
+func decodeUVs(n *yaml.Node, length int) ([]Vec3, error) {
+	n = resolve(n)
+	if n.Kind != yaml.SequenceNode {
+		return nil, errorAt(n, "expected a list of texture coordinates")
+	}
+	if len(n.Content) != length {
+		return nil, errorAt(n, "expected %d texture coordinates, got %d", length, len(n.Content))
+	}
+	uvs := make([]Vec3, len(n.Content))
+	for i, c := range n.Content {
+		c = resolve(c)
+		if c.Kind != yaml.SequenceNode || len(c.Content) != 2 {
+			return nil, errorAt(c, "expected texture coordinates [u, v]")
+		}
+		var err error
+		if uvs[i].X, err = decodeFloat(resolve(c.Content[0])); err != nil {
+			return nil, err
+		}
+		if uvs[i].Y, err = decodeFloat(resolve(c.Content[1])); err != nil {
+			return nil, err
+		}
+	}
+	return uvs, nil
+}
+
+// decodeInts decodes a list of integers
+func decodeInts(n *yaml.Node) ([]int, error) {
+	n = resolve(n)
+	if n.Kind != yaml.SequenceNode {
+		return nil, errorAt(n, "expected a list of integers")
+	}
+	ints := make([]int, len(n.Content))
+	for i, c := range n.Content {
+		var err error
+		if ints[i], err = decodeInt(resolve(c)); err != nil {
+			return nil, err
+		}
+	}
+	return ints, nil
+}
+
+// decodeVertexAttributes decodes the optional normals and texture coordinates of the vertices of triangles
+func decodeVertexAttributes(values map[string]*yaml.Node, vertices int) (normals, uvs []Vec3, err error) {
+	if n, ok := values["normals"]; ok {
+		if normals, err = decodeVecs(n, vertices); err != nil {
+			return nil, nil, err
+		}
+	}
+	if n, ok := values["uvs"]; ok {
+		if uvs, err = decodeUVs(n, vertices); err != nil {
+			return nil, nil, err
+		}
+	}
+	return normals, uvs, nil
+}
+
+// decodeWrapped decodes the shape wrapped by a transformation
+func (d sceneDecoder) decodeWrapped(parent *yaml.Node, values map[string]*yaml.Node) (Geometry, error) {
+	n, err := needKey(parent, values, "shape")
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeShape(n)
+}
+
+var materialKinds = []string{"lambertian", "metal", "dielectric", "diffuse_light", "isotropic"}
+
+func decodeMaterial(n *yaml.Node) (Material, error) {
+	kind, n, err := variant(n, materialKinds...)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "lambertian", "isotropic":
+		values, err := fields(n, "albedo")
+		if err != nil {
+			return nil, err
+		}
+		albedo, err := needTexture(n, values, "albedo")
+		if err != nil {
+			return nil, err
+		}
+		if kind == "isotropic" {
+			return Isotropic{albedo}, nil
+		}
+		return Lambertian{albedo}, nil
+
+	case "metal":
+		values, err := fields(n, "albedo", "fuzz")
+		if err != nil {
+			return nil, err
+		}
+		albedo, err := needVec(n, values, "albedo")
+		if err != nil {
+			return nil, err
+		}
+		fuzz, err := optFloat(values, "fuzz", 0)
+		if err != nil {
+			return nil, err
+		}
+		if fuzz < 0 {
+			return nil, errorAt(values["fuzz"], "fuzz must not be negative")
+		}
+		// the fuzz is kept as given, fuzzier than 1 as some built-in scenes, so that marshaled scenes read back the same
+		return Metal{albedo, fuzz}, nil
+
+	case "dielectric":
+		values, err := fields(n, "index")
+		if err != nil {
+			return nil, err
+		}
+		index, err := needFloat(n, values, "index")
+		if err != nil {
+			return nil, err
+		}
+		if index <= 0 {
+			return nil, errorAt(values["index"], "refraction index must be positive")
+		}
+		return Dielectric{index}, nil
+
+	case "diffuse_light":
+		values, err := fields(n, "emit")
+		if err != nil {
+			return nil, err
+		}
+		emit, err := needTexture(n, values, "emit")
+		if err != nil {
+			return nil, err
+		}
+		return DiffuseLight{emit}, nil
+	}
+	return nil, errorAt(n, "unknown material %q", kind)
+}
+
+var textureKinds = []string{"constant", "checker", "noise", "marble", "image", "vertex_colors"}
+
+func needTexture(parent *yaml.Node, values map[string]*yaml.Node, key string) (Texture, error) {
+	n, err := needKey(parent, values, key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTexture(n)
+}
+
+func decodeTexture(n *yaml.Node) (Texture, error) {
+	// a plain color is a shorthand for a constant texture
+	if n.Kind == yaml.SequenceNode {
+		color, err := decodeVec(n)
+		if err != nil {
+			return nil, err
+		}
+		return ConstantTexture{color}, nil
+	}
+
+	kind, n, err := variant(n, textureKinds...)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "constant":
+		color, err := decodeVec(n)
+		if err != nil {
+			return nil, err
+		}
+		return ConstantTexture{color}, nil
+
+	case "checker":
+		values, err := fields(n, "odd", "even", "frequency")
+		if err != nil {
+			return nil, err
+		}
+		odd, err := needTexture(n, values, "odd")
+		if err != nil {
+			return nil, err
+		}
+		even, err := needTexture(n, values, "even")
+		if err != nil {
+			return nil, err
+		}
+		freq, err := needFloat(n, values, "frequency")
+		if err != nil {
+			return nil, err
+		}
+		return CheckerTexture{odd, even, freq}, nil
+
+	case "noise":
+		values, err := fields(n, "seed", "frequency")
+		if err != nil {
+			return nil, err
+		}
+		seed, err := optInt(values, "seed", 0)
+		if err != nil {
+			return nil, err
+		}
+		freq, err := needFloat(n, values, "frequency")
+		if err != nil {
+			return nil, err
+		}
+		return NewNoise(int64(seed), freq), nil
+
+	case "marble":
+		values, err := fields(n, "seed", "depth", "turbulence", "scale")
+		if err != nil {
+			return nil, err
+		}
+		seed, err := optInt(values, "seed", 0)
+		if err != nil {
+			return nil, err
+		}
+		depth, err := optInt(values, "depth", 7)
+		if err != nil {
+			return nil, err
+		}
+		turbulence, err := needFloat(n, values, "turbulence")
+		if err != nil {
+			return nil, err
+		}
+		scale, err := needFloat(n, values, "scale")
+		if err != nil {
+			return nil, err
+		}
+		return NewMarble(int64(seed), depth, turbulence, scale), nil
+
+	case "image":
+		values, err := fields(n, "file", "x_offset", "y_offset")
+		if err != nil {
+			return nil, err
+		}
+		fileNode, err := needKey(n, values, "file")
+		if err != nil {
+			return nil, err
+		}
+		file, err := decodeString(fileNode)
+		if err != nil {
+			return nil, err
+		}
+		xoffset, err := optFloat(values, "x_offset", 0)
+		if err != nil {
+			return nil, err
+		}
+		yoffset, err := optFloat(values, "y_offset", 0)
+		if err != nil {
+			return nil, err
+		}
+		img, err := LoadImage(file, xoffset, yoffset)
+		if err != nil {
+			return nil, errorAt(fileNode, "%v", err)
+		}
+		return img, nil
+
+	case "vertex_colors":
+		// the colors are given by the vertices of the meshes
+		if _, err := fields(n); err != nil {
+			return nil, err
+		}
+		return VertexColors{}, nil
+	}
+	return nil, errorAt(n, "unknown texture %q", kind)
+}
@@ -0,0 +1,106 @@
+package gotrace
+
+import (
+	"errors"
+	_ "image/jpeg"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadSceneExample(t *testing.T) {
+	file, err := os.Open("example_scenes/scene.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scene, err := LoadScene(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scene.width != 400 || scene.height != 200 {
+		t.Errorf("got a %dx%d image, want 400x200", scene.width, scene.height)
+	}
+	if scene.background != (Vec3{0.7, 0.8, 1}) {
+		t.Errorf("got background %v, want [0.7 0.8 1]", scene.background)
+	}
+	if _, ok := scene.toneMapper.(Reinhard); !ok {
+		t.Errorf("got tone mapper %T, want Reinhard", scene.toneMapper)
+	}
+	if len(scene.objects) != 13 || len(scene.lights) != 2 {
+		t.Fatalf("got %d objects and %d lights, want 13 objects and 2 lights", len(scene.objects), len(scene.lights))
+	}
+	// a few objects of each kind, in the order of the file
+	if material, ok := scene.objects[1].material.(Dielectric); !ok || material.n != 1.5 {
+		t.Errorf("got material %#v for the glass sphere, want a dielectric of index 1.5", scene.objects[1].material)
+	}
+	if _, ok := scene.objects[6].shape.(Translate); !ok {
+		t.Errorf("got shape %T for the rotated box, want Translate", scene.objects[6].shape)
+	}
+	// materials can be shared by anchors
+	if scene.objects[6].material != scene.objects[10].material {
+		t.Errorf("got materials %v and %v for the objects sharing the white material", scene.objects[6].material, scene.objects[10].material)
+	}
+	if mesh, ok := scene.objects[7].shape.(*Mesh); !ok || mesh.Triangles() != 4 {
+		t.Errorf("got shape %v for the tetrahedron, want a mesh of 4 triangles", scene.objects[7].shape)
+	}
+	if _, ok := scene.objects[9].shape.(Fog); !ok {
+		t.Errorf("got shape %T for the fog, want Fog", scene.objects[9].shape)
+	}
+}
+
+// sceneHeader is the camera of the scenes of the tests, whose objects start at line 6
+const sceneHeader = `camera:
+  look_from: [0, 0, 5]
+  look_at: [0, 0, 0]
+  fov: 40
+  aspect_ratio: 1
+`
+
+const sceneSphere = sceneHeader + `objects:
+  - sphere: {center: [0, 0, 0], radius: 1}
+    material:
+      lambertian:
+        albedo: [0.5, 0.5, 0.5]
+`
+
+func TestLoadSceneErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		scene string
+		line  int
+		want  string
+	}{
+		{"unknown section", sceneSphere + "lights: []\n", 11, `unknown key "lights"`},
+		{"missing camera", strings.TrimPrefix(sceneSphere, sceneHeader), 1, `missing key "camera"`},
+		{"duplicate key", strings.Replace(sceneSphere, "  fov: 40\n", "  fov: 40\n  fov: 50\n", 1), 5, `duplicate key "fov"`},
+		{"not a number", strings.Replace(sceneSphere, "fov: 40", "fov: wide", 1), 4, `expected a number, got "wide"`},
+		{"fov out of range", strings.Replace(sceneSphere, "fov: 40", "fov: 180", 1), 4, "fov must be between 0 and 180 degrees"},
+		{"short vector", strings.Replace(sceneSphere, "look_at: [0, 0, 0]", "look_at: [0, 0]", 1), 3, "expected 3 coordinates, got 2"},
+		{"same points", strings.Replace(sceneSphere, "look_at: [0, 0, 0]", "look_at: [0, 0, 5]", 1), 2, "look_from and look_at must be different points"},
+		{"no objects", sceneHeader + "objects: []\n", 6, "expected a non-empty list of objects"},
+		{"unknown shape", strings.Replace(sceneSphere, "sphere:", "cube:", 1), 7, `unknown key "cube"`},
+		{"missing radius", strings.Replace(sceneSphere, ", radius: 1", "", 1), 7, `missing key "radius"`},
+		{"missing material", strings.SplitAfter(sceneSphere, "radius: 1}\n")[0], 7, `missing key "material"`},
+		{"unknown material", strings.Replace(sceneSphere, "lambertian:", "plastic:", 1), 9, `unknown key "plastic"`},
+		{"unknown texture", strings.Replace(sceneSphere, "albedo: [0.5, 0.5, 0.5]", "albedo: {wood: {}}", 1), 10, `unknown key "wood"`},
+		{"bad refraction index", strings.Replace(sceneSphere, "lambertian:\n        albedo: [0.5, 0.5, 0.5]", "dielectric: {index: 0}", 1), 9, "refraction index must be positive"},
+		{"missing mesh file", strings.Replace(sceneSphere, "sphere: {center: [0, 0, 0], radius: 1}", "ply: {file: missing.ply}", 1), 7, "missing.ply"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := LoadScene(strings.NewReader(test.scene))
+			var sceneErr *SceneError
+			if !errors.As(err, &sceneErr) {
+				t.Fatalf("got error %v, want a SceneError", err)
+			}
+			if sceneErr.Line != test.line || !strings.Contains(sceneErr.Msg, test.want) {
+				t.Errorf("got error %q, want %q at line %d", err, test.want, test.line)
+			}
+		})
+	}
+
+	if _, err := LoadScene(strings.NewReader("")); err == nil || err.Error() != "empty scene description" {
+		t.Errorf("got error %v for an empty description, want empty scene description", err)
+	}
+}
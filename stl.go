@@ -0,0 +1,114 @@
+package gotrace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+)
+
+/*
+LoadSTL reads a triangle mesh from an STL file, in the binary or ASCII format. The normals of the facets are ignored, their
+vertices being counter-clockwise seen from outside as required by the format, and vertices at the same position are shared
+by the triangles of the mesh.
+*/
+func LoadSTL(file string) (*Mesh, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var triangles []Vec3
+	// binary files may also start with "solid", so their size tells them apart
+	if len(data) >= 84 && len(data) == 84+50*int(binary.LittleEndian.Uint32(data[80:84])) {
+		triangles = readBinarySTL(data)
+	} else if bytes.HasPrefix(bytes.TrimSpace(data), []byte("solid")) {
+		if triangles, err = readTextSTL(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	} else {
+		return nil, fmt.Errorf("%s: not an STL file", file)
+	}
+	if len(triangles) == 0 {
+		// binary files whose size doesn't match their count of facets are read as ASCII files
+		if len(data) >= 84 {
+			if count := int(binary.LittleEndian.Uint32(data[80:84])); count > 0 {
+				return nil, fmt.Errorf("%s: truncated binary file of %d bytes, %d facets need %d bytes", file, len(data), count, 84+50*count)
+			}
+		}
+		return nil, fmt.Errorf("%s: no facets", file)
+	}
+
+	var vertices []Vec3
+	indices := make([]int, len(triangles))
+	shared := map[Vec3]int{}
+	for i, vertex := range triangles {
+		index, ok := shared[vertex]
+		if !ok {
+			index = len(vertices)
+			shared[vertex] = index
+			vertices = append(vertices, vertex)
+		}
+		indices[i] = index
+	}
+	return NewMesh(vertices, indices, nil, nil)
+}
+
+// readBinarySTL returns the vertices of the facets of a binary STL file, whose header and size were checked
+func readBinarySTL(data []byte) []Vec3 {
+	count := int(binary.LittleEndian.Uint32(data[80:84]))
+	vertices := make([]Vec3, 0, 3*count)
+	for i := 0; i < count; i++ {
+		// each facet is made of its normal, its three vertices and an attribute byte count
+		facet := data[84+50*i : 84+50*(i+1)]
+		for v := 1; v <= 3; v++ {
+			var coords [3]float64
+			for c := range coords {
+				bits := binary.LittleEndian.Uint32(facet[12*v+4*c:])
+				coords[c] = float64(math.Float32frombits(bits))
+			}
+			vertices = append(vertices, Vec3{coords[0], coords[1], coords[2]})
+		}
+	}
+	return vertices
+}
+
+// readTextSTL returns the vertices of the facets of an ASCII STL file
+func readTextSTL(data []byte) ([]Vec3, error) {
+	var vertices []Vec3
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line, facetLine, facetVertices := 0, 0, 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "facet":
+			facetLine, facetVertices = line, 0
+		case "vertex":
+			values, err := parseFloats(fields[1:], 3, 3)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			vertices = append(vertices, Vec3{values[0], values[1], values[2]})
+			facetVertices++
+		case "endfacet":
+			if facetVertices != 3 {
+				return nil, fmt.Errorf("line %d: facet has %d vertices, expected 3", facetLine, facetVertices)
+			}
+		}
+		// solid, outer loop, endloop and endsolid lines only delimit the facets
+	}
+	if err := scanner.Err(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(vertices)%3 != 0 {
+		return nil, fmt.Errorf("line %d: unterminated facet", line)
+	}
+	return vertices, nil
+}
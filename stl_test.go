@@ -0,0 +1,103 @@
+package gotrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+const stlTetrahedron = `solid tetrahedron
+facet normal 0 0 -1
+  outer loop
+    vertex 0 0 0
+    vertex 0 1 0
+    vertex 1 0 0
+  endloop
+endfacet
+facet normal 0 -1 0
+  outer loop
+    vertex 0 0 0
+    vertex 1 0 0
+    vertex 0 0 1
+  endloop
+endfacet
+facet normal -1 0 0
+  outer loop
+    vertex 0 0 0
+    vertex 0 0 1
+    vertex 0 1 0
+  endloop
+endfacet
+facet normal 1 1 1
+  outer loop
+    vertex 1 0 0
+    vertex 0 1 0
+    vertex 0 0 1
+  endloop
+endfacet
+endsolid tetrahedron
+`
+
+// binarySTL returns a binary STL file of the facets, whose header starts with "solid" as some exporters do
+func binarySTL(facets [][3][3]float32) []byte {
+	var b bytes.Buffer
+	header := make([]byte, 80)
+	copy(header, "solid binary")
+	b.Write(header)
+	binary.Write(&b, binary.LittleEndian, uint32(len(facets)))
+	for _, facet := range facets {
+		binary.Write(&b, binary.LittleEndian, [3]float32{})
+		binary.Write(&b, binary.LittleEndian, facet)
+		binary.Write(&b, binary.LittleEndian, uint16(0))
+	}
+	return b.Bytes()
+}
+
+func TestLoadSTL(t *testing.T) {
+	tetrahedron := [][3][3]float32{
+		{{0, 0, 0}, {0, 1, 0}, {1, 0, 0}},
+		{{0, 0, 0}, {1, 0, 0}, {0, 0, 1}},
+		{{0, 0, 0}, {0, 0, 1}, {0, 1, 0}},
+		{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}},
+	}
+	for name, data := range map[string][]byte{
+		"ascii":  []byte(stlTetrahedron),
+		"binary": binarySTL(tetrahedron),
+	} {
+		t.Run(name, func(t *testing.T) {
+			mesh, err := LoadSTL(writeTestFile(t, "tetrahedron.stl", data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mesh.Triangles() != 4 || len(mesh.vertices) != 4 {
+				t.Errorf("got %d triangles and %d vertices, want 4 shared vertices", mesh.Triangles(), len(mesh.vertices))
+			}
+		})
+	}
+}
+
+func TestLoadSTLErrors(t *testing.T) {
+	binary := binarySTL([][3][3]float32{{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}}})
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		// truncated binary files whose header starts with "solid" are read as ASCII files without facets
+		{"truncated binary", binary[:len(binary)-10], "truncated binary file of 124 bytes, 1 facets need 134 bytes"},
+		{"truncated header", binary[:40], "no facets"},
+		{"truncated ascii", []byte(strings.Split(stlTetrahedron, "    vertex 0 1 0")[0]), "unterminated facet"},
+		{"facet with two vertices", []byte(strings.Replace(stlTetrahedron, "    vertex 0 1 0\n    vertex 1 0 0\n", "    vertex 0 1 0\n", 1)), "line 2: facet has 2 vertices, expected 3"},
+		{"invalid vertex", []byte(strings.Replace(stlTetrahedron, "vertex 0 0 1", "vertex 0 zero 1", 1)), "line 13"},
+		{"no facets", []byte("solid empty\nendsolid empty\n"), "no facets"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := LoadSTL(writeTestFile(t, "broken.stl", test.data))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"os"
 
 	"github.com/ojrac/opensimplex-go"
-	"github.com/teobouvard/gotrace/util"
 )
 
 /*
@@ -28,11 +27,50 @@ type Texture interface {
 	Value(u, v float64, pos Vec3) Vec3
 }
 
+// HitTexture is a texture whose value at a hit also depends on other properties of the hit than its coordinates
+type HitTexture interface {
+	Texture
+	HitValue(hit HitRecord) Vec3
+}
+
+// textureValue returns the value of the texture at the hit
+func textureValue(t Texture, hit HitRecord) Vec3 {
+	if t, ok := t.(HitTexture); ok {
+		return t.HitValue(hit)
+	}
+	return t.Value(hit.U, hit.V, hit.Position)
+}
+
+/*
+VertexColors is the texture of the colors of the vertices of meshes, interpolated over their triangles by the barycentric
+coordinates of the hits. Shapes without vertex colors are white, as are the emitted colors of lights, which aren't given the
+hit.
+*/
+type VertexColors struct{}
+
+// Value implements the texture interface for VertexColors, which is white as the hit isn't known
+func (t VertexColors) Value(u, v float64, pos Vec3) Vec3 {
+	return WHITE
+}
+
+// HitValue implements the HitTexture interface for VertexColors
+func (t VertexColors) HitValue(hit HitRecord) Vec3 {
+	if !hit.HasVertexColor {
+		return WHITE
+	}
+	return hit.VertexColor
+}
+
 // ConstantTexture is a uniform texture with a single color
 type ConstantTexture struct {
 	color Vec3
 }
 
+// NewConstantTexture returns a texture of a single color
+func NewConstantTexture(color Vec3) ConstantTexture {
+	return ConstantTexture{color}
+}
+
 // Value implements the texture interface for a ConstantTexture
 func (t ConstantTexture) Value(u, v float64, pos Vec3) Vec3 {
 	return t.color
@@ -57,9 +95,19 @@ func (t CheckerTexture) Value(u, v float64, pos Vec3) Vec3 {
 // Noise is an opensimplex noise
 type Noise struct {
 	noise     opensimplex.Noise
+	seed      int64
 	frequency float64
 }
 
+// NewNoise creates a noise texture from the seed of the noise generator
+func NewNoise(seed int64, frequency float64) Noise {
+	return Noise{
+		noise:     opensimplex.New(seed),
+		seed:      seed,
+		frequency: frequency,
+	}
+}
+
 // Value implements the texture interface for a Noise Texture
 func (t Noise) Value(u, v float64, pos Vec3) Vec3 {
 	scaled := pos.Scale(t.frequency)
@@ -70,11 +118,23 @@ func (t Noise) Value(u, v float64, pos Vec3) Vec3 {
 // Marble is a marble-like texture
 type Marble struct {
 	noise      opensimplex.Noise
+	seed       int64
 	depth      int
 	turbulence float64
 	scale      float64
 }
 
+// NewMarble creates a marble texture from the seed of the noise generator
+func NewMarble(seed int64, depth int, turbulence, scale float64) Marble {
+	return Marble{
+		noise:      opensimplex.New(seed),
+		seed:       seed,
+		depth:      depth,
+		turbulence: turbulence,
+		scale:      scale,
+	}
+}
+
 // genTurbulence creates a turbulence effect by summing noise at different frequencies
 func (t Marble) genTurbulence(pos Vec3) float64 {
 	sum := 0.0
@@ -96,34 +156,62 @@ func (t Marble) Value(u, v float64, pos Vec3) Vec3 {
 
 // Image is a texture mapped to an image file
 type Image struct {
-	data    image.Image
-	xoffset float64
-	yoffset float64
+	data    *image.RGBA
+	file    string
+	xoffset float64 // percentage of the width
+	yoffset float64 // percentage of the height
 }
 
 // NewImage creates an image texture from the path to the image, and an offset on the x axis
 // The offset is given as a percentage of the width
 func NewImage(file string, xoffset, yoffset float64) Image {
+	img, err := LoadImage(file, xoffset, yoffset)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return img
+}
+
+// LoadImage is like NewImage, but returns an error instead of exiting if the image can't be decoded
+func LoadImage(file string, xoffset, yoffset float64) (Image, error) {
 	f, err := os.Open(file)
+	if err != nil {
+		return Image{}, err
+	}
+	defer f.Close()
 	src, _, err := image.Decode(f)
 	if err != nil {
-		log.Fatal(err)
+		return Image{}, err
 	}
 	bounds := src.Bounds()
 	img := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
 	draw.Draw(img, img.Bounds(), src, bounds.Min, draw.Src)
-	return Image{img, xoffset / 100.0, yoffset / 100.0}
+	return Image{img, file, xoffset, yoffset}, nil
 }
 
 // Value implements the texture interface for an Image texture
+// Texture coordinates outside of [0, 1) wrap around, so that images are tiled.
 func (t Image) Value(u, v float64, pos Vec3) Vec3 {
-	width := t.data.Bounds().Max.X - 1
-	height := t.data.Bounds().Max.Y - 1
-	x := int(util.Map(u, 0, 1, 0, float64(width)))
-	y := int(util.Map(v, 0, 1, 0, float64(height)))
-	x = int(float64(x)+t.xoffset*float64(width)) % width
-	y = int(float64(y)+t.yoffset*float64(height)) % height
-	color := t.data.At(x, height-y)
-	r, g, b, _ := color.RGBA()
-	return Vec3{float64(r) / 65535, float64(g) / 65535, float64(b) / 65535}
+	if t.data == nil {
+		return BLACK
+	}
+	width, height := t.data.Bounds().Dx(), t.data.Bounds().Dy()
+	if width == 0 || height == 0 {
+		return BLACK
+	}
+	x := int(wrap(u+t.xoffset/100.0) * float64(width))
+	y := int(wrap(v+t.yoffset/100.0) * float64(height))
+	// RGBAAt doesn't allocate the color interface returned by At, and lines of the image go downwards
+	color := t.data.RGBAAt(x, height-1-y)
+	return Vec3{float64(color.R) / 255, float64(color.G) / 255, float64(color.B) / 255}
+}
+
+// wrap returns the fractional part of x, in [0, 1) even for negative numbers
+func wrap(x float64) float64 {
+	x -= math.Floor(x)
+	// tiny negative numbers are rounded up to 1
+	if x >= 1 {
+		return 0
+	}
+	return x
 }
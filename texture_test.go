@@ -0,0 +1,45 @@
+package gotrace
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestImageValue(t *testing.T) {
+	// a 4x2 image whose pixels have distinct colors, the first line being the top of the texture
+	data := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			data.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	pixel := func(x, y int) Vec3 {
+		return Vec3{float64(x) / 255, float64(y) / 255, 0}
+	}
+	tests := []struct {
+		name    string
+		texture Image
+		u, v    float64
+		want    Vec3
+	}{
+		{"bottom left", Image{data: data}, 0, 0, pixel(0, 1)},
+		{"top right", Image{data: data}, 0.99, 0.99, pixel(3, 0)},
+		{"middle", Image{data: data}, 0.6, 0.6, pixel(2, 0)},
+		// coordinates outside of [0, 1) repeat the image
+		{"right edge", Image{data: data}, 1, 0.25, pixel(0, 1)},
+		{"next tile", Image{data: data}, 1.6, 2.6, pixel(2, 0)},
+		{"negative", Image{data: data}, -0.4, -0.4, pixel(2, 0)},
+		{"tiny negative", Image{data: data}, -1e-17, 0, pixel(0, 1)},
+		{"offset", Image{data: data, xoffset: 50}, 0.6, 0.25, pixel(0, 1)},
+		{"empty", Image{data: image.NewRGBA(image.Rect(0, 0, 0, 3))}, 0.5, 0.5, BLACK},
+		{"no image", Image{}, 0.5, 0.5, BLACK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.texture.Value(test.u, test.v, Vec3{}); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package gotrace
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"sync"
+)
+
+// TileOrder is the order in which the tiles of an image are rendered
+type TileOrder int
+
+const (
+	// SpiralOrder renders tiles from the center of the image towards its borders
+	SpiralOrder TileOrder = iota
+	// ScanlineOrder renders tiles from left to right, and from top to bottom
+	ScanlineOrder
+	// HilbertOrder renders tiles along a Hilbert curve, which keeps consecutive tiles close to each other
+	HilbertOrder
+)
+
+var tileOrderNames = map[TileOrder]string{
+	SpiralOrder:   "spiral",
+	ScanlineOrder: "scanline",
+	HilbertOrder:  "hilbert",
+}
+
+func (o TileOrder) String() string {
+	if name, ok := tileOrderNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("TileOrder(%d)", int(o))
+}
+
+// ParseTileOrder returns the tile order of the given name
+func ParseTileOrder(name string) (TileOrder, error) {
+	for order, orderName := range tileOrderNames {
+		if orderName == name {
+			return order, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown tile order %q", name)
+}
+
+// tile is a rectangular part of the image, which is rendered by a single worker
+type tile struct {
+	// index of the tile in scanline order, used to seed its random source
+	index int
+	// bounds of the tile in image coordinates, the origin being the top left corner
+	bounds image.Rectangle
+}
+
+// splitTiles splits the image into tiles of the given size, sorted in the given order
+func splitTiles(width, height, size int, order TileOrder) []tile {
+	nx := (width + size - 1) / size
+	ny := (height + size - 1) / size
+	tiles := make([]tile, 0, nx*ny)
+	for ty := 0; ty < ny; ty++ {
+		for tx := 0; tx < nx; tx++ {
+			bounds := image.Rect(tx*size, ty*size, (tx+1)*size, (ty+1)*size)
+			tiles = append(tiles, tile{
+				index:  len(tiles),
+				bounds: bounds.Intersect(image.Rect(0, 0, width, height)),
+			})
+		}
+	}
+
+	var key func(t tile) [2]float64
+	switch order {
+	case SpiralOrder:
+		// rings of tiles around the center, each ring being walked around by angle
+		cx, cy := float64(nx-1)/2, float64(ny-1)/2
+		key = func(t tile) [2]float64 {
+			dx := float64(t.index%nx) - cx
+			dy := float64(t.index/nx) - cy
+			ring := math.Max(math.Abs(dx), math.Abs(dy))
+			return [2]float64{ring, math.Atan2(dy, dx)}
+		}
+	case HilbertOrder:
+		n := 1
+		for n < nx || n < ny {
+			n *= 2
+		}
+		key = func(t tile) [2]float64 {
+			return [2]float64{float64(hilbertIndex(n, t.index%nx, t.index/nx))}
+		}
+	default:
+		return tiles
+	}
+	sort.SliceStable(tiles, func(i, j int) bool {
+		ki, kj := key(tiles[i]), key(tiles[j])
+		return ki[0] < kj[0] || (ki[0] == kj[0] && ki[1] < kj[1])
+	})
+	return tiles
+}
+
+// hilbertIndex returns the distance along the Hilbert curve filling a n*n grid (n being a power of 2) of the cell (x, y)
+func hilbertIndex(n, x, y int) int {
+	d := 0
+	for s := n / 2; s > 0; s /= 2 {
+		rx, ry := 0, 0
+		if x&s > 0 {
+			rx = 1
+		}
+		if y&s > 0 {
+			ry = 1
+		}
+		d += s * s * ((3 * rx) ^ ry)
+		// rotate the quadrant so that the curve is continuous
+		if ry == 0 {
+			if rx == 1 {
+				x = n - 1 - x
+				y = n - 1 - y
+			}
+			x, y = y, x
+		}
+	}
+	return d
+}
+
+// tileQueue is the queue of tiles of a worker. The worker takes tiles at its front, and idle workers steal tiles at its back.
+type tileQueue struct {
+	mu    sync.Mutex
+	tiles []tile
+}
+
+func (q *tileQueue) pop() (tile, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.tiles) == 0 {
+		return tile{}, false
+	}
+	t := q.tiles[0]
+	q.tiles = q.tiles[1:]
+	return t, true
+}
+
+func (q *tileQueue) steal() (tile, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.tiles) == 0 {
+		return tile{}, false
+	}
+	t := q.tiles[len(q.tiles)-1]
+	q.tiles = q.tiles[:len(q.tiles)-1]
+	return t, true
+}
+
+// scheduler distributes tiles among workers, each worker stealing the tiles of the others once its own queue is empty
+type scheduler struct {
+	queues []tileQueue
+}
+
+// newScheduler deals the ordered tiles to the workers, so that all workers start with the first tiles of the order
+func newScheduler(tiles []tile, workers int) *scheduler {
+	s := &scheduler{queues: make([]tileQueue, workers)}
+	for i, t := range tiles {
+		q := &s.queues[i%workers]
+		q.tiles = append(q.tiles, t)
+	}
+	return s
+}
+
+// next returns the next tile to be rendered by the worker, or false if all tiles have been taken
+func (s *scheduler) next(worker int) (tile, bool) {
+	if t, ok := s.queues[worker].pop(); ok {
+		return t, true
+	}
+	for i := 1; i < len(s.queues); i++ {
+		victim := (worker + i) % len(s.queues)
+		if t, ok := s.queues[victim].steal(); ok {
+			return t, true
+		}
+	}
+	return tile{}, false
+}
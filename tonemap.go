@@ -0,0 +1,153 @@
+package gotrace
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+/*
+ToneMapper maps the linear radiance of pixels to displayable colors.
+
+Map returns the linear color displayed for the radiance, whose components are in [0, 1]. Images are then encoded with
+the sRGB transfer function, so tone mappers don't have to take care of it.
+*/
+type ToneMapper interface {
+	Map(radiance Vec3) Vec3
+}
+
+// SRGB scales the radiance by 2^Exposure, and clamps it to [0, 1]
+type SRGB struct {
+	Exposure float64
+}
+
+// Map implements the ToneMapper interface
+func (m SRGB) Map(radiance Vec3) Vec3 {
+	return clamp01(exposed(radiance, m.Exposure))
+}
+
+/*
+Reinhard compresses the luminance L of the radiance scaled by 2^Exposure as L * (1 + L / White²) / (1 + L), which maps
+White to 1. A White of 0 or less means an infinite white point, which never reaches 1.
+*/
+type Reinhard struct {
+	Exposure float64
+	White    float64
+}
+
+// Map implements the ToneMapper interface
+func (m Reinhard) Map(radiance Vec3) Vec3 {
+	c := exposed(radiance, m.Exposure)
+	l := luminance(c)
+	if l <= 0 {
+		return BLACK
+	}
+	mapped := l / (1 + l)
+	if m.White > 0 {
+		mapped = l * (1 + l/(m.White*m.White)) / (1 + l)
+	}
+	return clamp01(c.Scale(mapped / l))
+}
+
+// ACES is Krzysztof Narkowicz's fit of the ACES filmic curve, applied to the radiance scaled by 2^Exposure
+type ACES struct {
+	Exposure float64
+}
+
+// Map implements the ToneMapper interface
+func (m ACES) Map(radiance Vec3) Vec3 {
+	// the fit is made for radiances scaled by 0.6, so that an exposure of 0 matches the other tone mappers
+	c := exposed(radiance, m.Exposure).Scale(0.6)
+	curve := func(x float64) float64 {
+		return x * (2.51*x + 0.03) / (x*(2.43*x+0.59) + 0.14)
+	}
+	return clamp01(Vec3{curve(c.X), curve(c.Y), curve(c.Z)})
+}
+
+// Hable is John Hable's filmic curve of Uncharted 2, applied to the radiance scaled by 2^Exposure. The White radiance,
+// 11.2 if 0 or less, is mapped to 1.
+type Hable struct {
+	Exposure float64
+	White    float64
+}
+
+// Map implements the ToneMapper interface
+func (m Hable) Map(radiance Vec3) Vec3 {
+	curve := func(x float64) float64 {
+		const a, b, c, d, e, f = 0.15, 0.50, 0.10, 0.20, 0.02, 0.30
+		return (x*(a*x+c*b)+d*e)/(x*(a*x+b)+d*f) - e/f
+	}
+	white := m.White
+	if white <= 0 {
+		white = 11.2
+	}
+	// the curve is made for radiances scaled by 2
+	c := exposed(radiance, m.Exposure).Scale(2)
+	scale := 1 / curve(white)
+	return clamp01(Vec3{curve(c.X) * scale, curve(c.Y) * scale, curve(c.Z) * scale})
+}
+
+// ToneMapperNames are the names of the tone mappers, as used in scene files and on the command line
+var ToneMapperNames = []string{"srgb", "reinhard", "aces", "hable"}
+
+// NewToneMapper returns the tone mapper of the given name, with the given exposure and white point if it has one
+func NewToneMapper(name string, exposure, white float64) (ToneMapper, error) {
+	switch name {
+	case "srgb":
+		return SRGB{exposure}, nil
+	case "reinhard":
+		return Reinhard{exposure, white}, nil
+	case "aces":
+		return ACES{exposure}, nil
+	case "hable":
+		return Hable{exposure, white}, nil
+	}
+	return nil, fmt.Errorf("unknown tone mapper %q", name)
+}
+
+// exposed scales the radiance by 2^exposure
+func exposed(radiance Vec3, exposure float64) Vec3 {
+	return radiance.Scale(math.Exp2(exposure))
+}
+
+// luminance returns the relative luminance of a linear sRGB color
+func luminance(c Vec3) float64 {
+	return 0.2126*c.X + 0.7152*c.Y + 0.0722*c.Z
+}
+
+func clamp01(c Vec3) Vec3 {
+	return MaxCoord(MinCoord(c, WHITE), BLACK)
+}
+
+// srgbEncode applies the sRGB transfer function to a linear value of [0, 1]
+func srgbEncode(x float64) float64 {
+	if x <= 0.0031308 {
+		return 12.92 * x
+	}
+	return 1.055*math.Pow(x, 1/2.4) - 0.055
+}
+
+// toneMap returns the sRGB encoded 8-bit color displayed for the radiance
+func toneMap(m ToneMapper, radiance Vec3) color.RGBA {
+	c := m.Map(radiance)
+	// NaN radiances are mapped to black
+	channel := func(x float64) uint8 {
+		if !(x > 0) {
+			return 0
+		}
+		return uint8(math.Round(srgbEncode(math.Min(x, 1)) * 255))
+	}
+	return color.RGBA{channel(c.X), channel(c.Y), channel(c.Z), 255}
+}
+
+// ToneMap returns the 8-bit sRGB image of the framebuffer, mapped by the tone mapper
+func (f *Framebuffer) ToneMap(m ToneMapper) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, f.Width, f.Height))
+	for y := 0; y < f.Height; y++ {
+		for x := 0; x < f.Width; x++ {
+			img.SetRGBA(x, y, toneMap(m, f.Pixel(x, y)))
+		}
+	}
+	return img
+}
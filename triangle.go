@@ -0,0 +1,245 @@
+package gotrace
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Triangle geometry, whose normal is given by the right-hand rule on its vertices
+type Triangle struct {
+	Vertices [3]Vec3
+	// Normals are the normals of the three vertices, interpolated over the triangle for smooth shading.
+	// The normal of the plane of the triangle is used if nil.
+	Normals []Vec3
+	// UVs are the texture coordinates of the three vertices, U and V being their X and Y coordinates.
+	// The barycentric coordinates of the hits are used if nil.
+	UVs []Vec3
+}
+
+// NewTriangle constructs a flat triangle from its vertices
+func NewTriangle(a, b, c Vec3) Triangle {
+	return Triangle{Vertices: [3]Vec3{a, b, c}}
+}
+
+// Hit implements the geometry interface for a Triangle
+func (t Triangle) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	var record HitRecord
+	if !t.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
+	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for a Triangle
+func (t Triangle) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
+	distance, u, v, hit := hitTriangle(ray, tMin, tMax, t.Vertices[0], t.Vertices[1], t.Vertices[2])
+	if !hit {
+		return false
+	}
+	*record = HitRecord{Distance: distance, Position: ray.At(distance)}
+	record.Normal, record.U, record.V = shadeTriangle(t.Vertices, t.Normals, t.UVs, u, v)
+	return true
+}
+
+// Bound returns the bounding box of the Triangle
+func (t Triangle) Bound(startTime float64, endTime float64) (bool, *Bbox) {
+	return true, boundTriangle(t.Vertices[0], t.Vertices[1], t.Vertices[2])
+}
+
+/*
+hitTriangle computes the intersection of a ray with the triangle abc by the Möller–Trumbore algorithm, which solves
+origin + t * direction = a + u * (b - a) + v * (c - a) for the distance t and the barycentric coordinates u and v of the hit.
+*/
+func hitTriangle(ray Ray, tMin, tMax float64, a, b, c Vec3) (t, u, v float64, hit bool) {
+	edge1 := b.Sub(a)
+	edge2 := c.Sub(a)
+	p := ray.Direction.Cross(edge2)
+	det := edge1.Dot(p)
+	if det == 0 {
+		// the ray is parallel to the triangle
+		return 0, 0, 0, false
+	}
+	inv := 1 / det
+	s := ray.Origin.Sub(a)
+	u = s.Dot(p) * inv
+	if u < 0 || u > 1 {
+		return 0, 0, 0, false
+	}
+	q := s.Cross(edge1)
+	v = ray.Direction.Dot(q) * inv
+	if v < 0 || u+v > 1 {
+		return 0, 0, 0, false
+	}
+	t = edge2.Dot(q) * inv
+	if t <= tMin || t >= tMax {
+		return 0, 0, 0, false
+	}
+	return t, u, v, true
+}
+
+// shadeTriangle returns the normal and the texture coordinates of the hit of barycentric coordinates u and v, which are
+// interpolated from the normals and the texture coordinates of the vertices when they are given
+func shadeTriangle(vertices [3]Vec3, normals, uvs []Vec3, u, v float64) (Vec3, float64, float64) {
+	w := 1 - u - v
+	var normal Vec3
+	if normals != nil {
+		normal = normals[0].Scale(w).Add(normals[1].Scale(u)).Add(normals[2].Scale(v))
+	}
+	if normal == (Vec3{}) {
+		normal = vertices[1].Sub(vertices[0]).Cross(vertices[2].Sub(vertices[0]))
+	}
+	if uvs != nil {
+		uv := uvs[0].Scale(w).Add(uvs[1].Scale(u)).Add(uvs[2].Scale(v))
+		u, v = uv.X, uv.Y
+	}
+	return normal.Unit(), u, v
+}
+
+// boundTriangle returns the bounding box of the triangle abc, padded so that it isn't flat
+func boundTriangle(a, b, c Vec3) *Bbox {
+	padding := Vec3{1e-4, 1e-4, 1e-4}
+	return &Bbox{
+		Min: MinCoord(a, MinCoord(b, c)).Sub(padding),
+		Max: MaxCoord(a, MaxCoord(b, c)).Add(padding),
+	}
+}
+
+/*
+Mesh is a triangle mesh. Its triangles are given by the indices of their vertices in buffers shared by the whole mesh,
+and are indexed by a bounding volume hierarchy of their own, so that large meshes are quick to hit.
+*/
+type Mesh struct {
+	vertices []Vec3
+	normals  []Vec3
+	uvs      []Vec3
+	// colors of the vertices, optional, see VertexColors
+	colors []Vec3
+	// indices of the vertices of the triangles, three per triangle
+	indices []int
+	index   *FlatIndex
+}
+
+/*
+NewMesh constructs a mesh from the indices of the vertices of its triangles, three per triangle, which are counter-clockwise
+seen from the side of their normal. The normals and the texture coordinates of the vertices are optional, and are nil or
+given for each vertex.
+*/
+func NewMesh(vertices []Vec3, indices []int, normals []Vec3, uvs []Vec3) (*Mesh, error) {
+	if len(indices) == 0 || len(indices)%3 != 0 {
+		return nil, fmt.Errorf("a mesh needs three indices per triangle, got %d indices", len(indices))
+	}
+	for _, i := range indices {
+		if i < 0 || i >= len(vertices) {
+			return nil, fmt.Errorf("vertex index %d out of range, the mesh has %d vertices", i, len(vertices))
+		}
+	}
+	if normals != nil && len(normals) != len(vertices) {
+		return nil, errors.New("a mesh needs as many normals as vertices")
+	}
+	if uvs != nil && len(uvs) != len(vertices) {
+		return nil, errors.New("a mesh needs as many texture coordinates as vertices")
+	}
+	m := &Mesh{vertices: vertices, normals: normals, uvs: uvs, indices: indices}
+	triangles := make(Collection, len(indices)/3)
+	for i := range triangles {
+		triangles[i] = Actor{shape: meshTriangle{m, 3 * i}}
+	}
+	// meshes don't move
+	m.index = NewFlatIndex(triangles, 0, 0, SAHIndex)
+	return m, nil
+}
+
+// SetColors gives a color to each vertex of the mesh, which is interpolated over its triangles by the VertexColors texture
+func (m *Mesh) SetColors(colors []Vec3) error {
+	if len(colors) != len(m.vertices) {
+		return errors.New("a mesh needs as many colors as vertices")
+	}
+	m.colors = colors
+	return nil
+}
+
+// Triangles returns the number of triangles of the mesh
+func (m *Mesh) Triangles() int {
+	return len(m.indices) / 3
+}
+
+// Triangle returns the i-th triangle of the mesh
+func (m *Mesh) Triangle(i int) Triangle {
+	a, b, c := m.indices[3*i], m.indices[3*i+1], m.indices[3*i+2]
+	t := NewTriangle(m.vertices[a], m.vertices[b], m.vertices[c])
+	if m.normals != nil {
+		t.Normals = []Vec3{m.normals[a], m.normals[b], m.normals[c]}
+	}
+	if m.uvs != nil {
+		t.UVs = []Vec3{m.uvs[a], m.uvs[b], m.uvs[c]}
+	}
+	return t
+}
+
+// Hit implements the geometry interface for a Mesh
+func (m *Mesh) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	return m.index.Hit(ray, tMin, tMax)
+}
+
+// hitRecord implements the recordHitter interface for a Mesh
+func (m *Mesh) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
+	return m.index.hitRecord(ray, tMin, tMax, record)
+}
+
+// Bound returns the bounding box of the Mesh
+func (m *Mesh) Bound(startTime float64, endTime float64) (bool, *Bbox) {
+	return m.index.Bound(startTime, endTime)
+}
+
+// meshTriangle is a triangle of a mesh, whose vertices are given by the indices from first
+type meshTriangle struct {
+	mesh  *Mesh
+	first int
+}
+
+// vertices returns the indices of the vertices of the triangle and their positions
+func (t meshTriangle) vertices() ([3]int, [3]Vec3) {
+	indices := [3]int{t.mesh.indices[t.first], t.mesh.indices[t.first+1], t.mesh.indices[t.first+2]}
+	v := t.mesh.vertices
+	return indices, [3]Vec3{v[indices[0]], v[indices[1]], v[indices[2]]}
+}
+
+// Hit implements the geometry interface for a triangle of a mesh
+func (t meshTriangle) Hit(ray Ray, tMin float64, tMax float64) (bool, *HitRecord) {
+	var record HitRecord
+	if !t.hitRecord(ray, tMin, tMax, &record) {
+		return false, nil
+	}
+	hit := record
+	return true, &hit
+}
+
+// hitRecord implements the recordHitter interface for a triangle of a mesh
+func (t meshTriangle) hitRecord(ray Ray, tMin float64, tMax float64, record *HitRecord) bool {
+	indices, vertices := t.vertices()
+	distance, u, v, hit := hitTriangle(ray, tMin, tMax, vertices[0], vertices[1], vertices[2])
+	if !hit {
+		return false
+	}
+	var normals, uvs []Vec3
+	if t.mesh.normals != nil {
+		normals = []Vec3{t.mesh.normals[indices[0]], t.mesh.normals[indices[1]], t.mesh.normals[indices[2]]}
+	}
+	if t.mesh.uvs != nil {
+		uvs = []Vec3{t.mesh.uvs[indices[0]], t.mesh.uvs[indices[1]], t.mesh.uvs[indices[2]]}
+	}
+	*record = HitRecord{Distance: distance, Position: ray.At(distance)}
+	record.Normal, record.U, record.V = shadeTriangle(vertices, normals, uvs, u, v)
+	if colors := t.mesh.colors; colors != nil {
+		record.VertexColor = colors[indices[0]].Scale(1 - u - v).Add(colors[indices[1]].Scale(u)).Add(colors[indices[2]].Scale(v))
+		record.HasVertexColor = true
+	}
+	return true
+}
+
+// Bound returns the bounding box of a triangle of a mesh
+func (t meshTriangle) Bound(startTime float64, endTime float64) (bool, *Bbox) {
+	_, vertices := t.vertices()
+	return true, boundTriangle(vertices[0], vertices[1], vertices[2])
+}
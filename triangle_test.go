@@ -0,0 +1,184 @@
+package gotrace
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestHitTriangle(t *testing.T) {
+	a, b, c := Vec3{0, 0, 0}, Vec3{1, 0, 0}, Vec3{0, 1, 0}
+	down, up := Vec3{0, 0, -1}, Vec3{0, 0, 1}
+	tests := []struct {
+		name       string
+		origin     Vec3
+		direction  Vec3
+		tMin, tMax float64
+		hit        bool
+		// distance and barycentric coordinates of the hit
+		t, u, v float64
+	}{
+		{"inside", Vec3{0.25, 0.5, 1}, down, 0, math.Inf(1), true, 1, 0.25, 0.5},
+		{"back face", Vec3{0.25, 0.5, -2}, up, 0, math.Inf(1), true, 2, 0.25, 0.5},
+		{"oblique", Vec3{-0.75, 0.5, 1}, Vec3{1, 0, -1}, 0, math.Inf(1), true, 1, 0.25, 0.5},
+		{"vertex", Vec3{0, 0, 1}, down, 0, math.Inf(1), true, 1, 0, 0},
+		{"edge", Vec3{0.5, 0, 1}, down, 0, math.Inf(1), true, 1, 0.5, 0},
+		{"hypotenuse", Vec3{0.5, 0.5, 1}, down, 0, math.Inf(1), true, 1, 0.5, 0.5},
+		{"beyond the hypotenuse", Vec3{0.6, 0.5, 1}, down, 0, math.Inf(1), false, 0, 0, 0},
+		{"negative u", Vec3{-0.1, 0.5, 1}, down, 0, math.Inf(1), false, 0, 0, 0},
+		{"negative v", Vec3{0.5, -0.1, 1}, down, 0, math.Inf(1), false, 0, 0, 0},
+		{"parallel", Vec3{0.25, 0.25, 1}, Vec3{1, 0, 0}, 0, math.Inf(1), false, 0, 0, 0},
+		{"in the plane", Vec3{-1, 0.25, 0}, Vec3{1, 0, 0}, 0, math.Inf(1), false, 0, 0, 0},
+		{"behind the origin", Vec3{0.25, 0.5, -1}, down, 0, math.Inf(1), false, 0, 0, 0},
+		{"at tMax", Vec3{0.25, 0.5, 1}, down, 0, 1, false, 0, 0, 0},
+		{"at tMin", Vec3{0.25, 0.5, 1}, down, 1, math.Inf(1), false, 0, 0, 0},
+		{"between tMin and tMax", Vec3{0.25, 0.5, 1}, down, 0.999, 1.001, true, 1, 0.25, 0.5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ray := Ray{Origin: test.origin, Direction: test.direction}
+			distance, u, v, hit := hitTriangle(ray, test.tMin, test.tMax, a, b, c)
+			if hit != test.hit {
+				t.Fatalf("got hit %v, want %v", hit, test.hit)
+			}
+			if hit && (math.Abs(distance-test.t) > 1e-12 || math.Abs(u-test.u) > 1e-12 || math.Abs(v-test.v) > 1e-12) {
+				t.Errorf("got t = %v, u = %v, v = %v, want %v, %v, %v", distance, u, v, test.t, test.u, test.v)
+			}
+		})
+	}
+
+	// degenerate triangles are never hit
+	ray := Ray{Origin: Vec3{0.5, 0, 1}, Direction: down}
+	if _, _, _, hit := hitTriangle(ray, 0, math.Inf(1), a, b, Vec3{2, 0, 0}); hit {
+		t.Error("a flat triangle was hit")
+	}
+}
+
+func TestTriangleShading(t *testing.T) {
+	triangle := NewTriangle(Vec3{0, 0, 0}, Vec3{1, 0, 0}, Vec3{0, 1, 0})
+	ray := Ray{Origin: Vec3{0.25, 0.5, 1}, Direction: Vec3{0, 0, -1}}
+
+	// the normal follows the right-hand rule, whichever side the triangle is hit from, and u and v are barycentric
+	hit, record := triangle.Hit(ray, 0, math.Inf(1))
+	if !hit || record.Normal != (Vec3{0, 0, 1}) || record.U != 0.25 || record.V != 0.5 || record.Position != (Vec3{0.25, 0.5, 0}) {
+		t.Fatalf("got hit %+v, want the flat triangle hit at [0.25 0.5 0]", record)
+	}
+
+	// normals and texture coordinates of the vertices are interpolated
+	triangle.Normals = []Vec3{{0, 0, 1}, {1, 0, 1}, {0, 1, 1}}
+	triangle.UVs = []Vec3{{0, 0, 0}, {0, 1, 0}, {1, 1, 0}}
+	_, record = triangle.Hit(ray, 0, math.Inf(1))
+	if want := (Vec3{0.25, 0.5, 1}).Unit(); record.Normal.Sub(want).Norm() > 1e-12 {
+		t.Errorf("got normal %v, want %v", record.Normal, want)
+	}
+	if math.Abs(record.U-0.5) > 1e-12 || math.Abs(record.V-0.75) > 1e-12 {
+		t.Errorf("got u = %v and v = %v, want 0.5 and 0.75", record.U, record.V)
+	}
+
+	// normals cancelling each other fall back to the normal of the plane
+	triangle.Normals = []Vec3{{0, 0, 1}, {0, 0, -1}, {0, 0, 0}}
+	ray.Origin = Vec3{0.5, 0, 1}
+	if _, record = triangle.Hit(ray, 0, math.Inf(1)); record.Normal != (Vec3{0, 0, 1}) {
+		t.Errorf("got normal %v, want the normal of the plane", record.Normal)
+	}
+}
+
+// quad returns a mesh of the unit square in the z = 0 plane, split in two triangles
+func quad(t *testing.T) *Mesh {
+	t.Helper()
+	vertices := []Vec3{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}}
+	uvs := []Vec3{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}}
+	mesh, err := NewMesh(vertices, []int{0, 1, 2, 0, 2, 3}, nil, uvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mesh
+}
+
+func TestMesh(t *testing.T) {
+	mesh := quad(t)
+	if mesh.Triangles() != 2 {
+		t.Fatalf("got %d triangles, want 2", mesh.Triangles())
+	}
+	if _, box := mesh.Bound(0, 1); box.Min.Sub(Vec3{0, 0, 0}).Norm() > 1e-3 || box.Max.Sub(Vec3{1, 1, 0}).Norm() > 1e-3 {
+		t.Errorf("got bounding box %v, want the unit square", box)
+	}
+
+	// hits of the mesh are the hits of its triangles
+	for _, p := range [][2]float64{{0.25, 0.1}, {0.1, 0.25}, {0.9, 0.9}} {
+		ray := Ray{Origin: Vec3{p[0], p[1], 1}, Direction: Vec3{0, 0, -1}}
+		hit, record := mesh.Hit(ray, 0, math.Inf(1))
+		if !hit {
+			t.Fatalf("the mesh wasn't hit at %v", p)
+		}
+		if math.Abs(record.U-p[0]) > 1e-12 || math.Abs(record.V-p[1]) > 1e-12 {
+			t.Errorf("got u = %v and v = %v at %v, want the coordinates of the hit", record.U, record.V, p)
+		}
+		var triangleHit bool
+		for i := 0; i < mesh.Triangles(); i++ {
+			if hit, r := mesh.Triangle(i).Hit(ray, 0, math.Inf(1)); hit && r.Position == record.Position && r.Normal == record.Normal {
+				triangleHit = true
+			}
+		}
+		if !triangleHit {
+			t.Errorf("no triangle of the mesh was hit as the mesh at %v", p)
+		}
+	}
+	if hit, _ := mesh.Hit(Ray{Origin: Vec3{1.5, 0.5, 1}, Direction: Vec3{0, 0, -1}}, 0, math.Inf(1)); hit {
+		t.Error("the mesh was hit outside of its triangles")
+	}
+
+	// colors of the vertices are interpolated at the hit
+	if err := mesh.SetColors([]Vec3{{1, 0, 0}}); err == nil {
+		t.Error("got no error for a color of each vertex missing")
+	}
+	if err := mesh.SetColors([]Vec3{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}}); err != nil {
+		t.Fatal(err)
+	}
+	ray := Ray{Origin: Vec3{0.25, 0.75, 1}, Direction: Vec3{0, 0, -1}}
+	if _, record := mesh.Hit(ray, 0, math.Inf(1)); !record.HasVertexColor || record.VertexColor.Sub(Vec3{0.25, 0.75, 0}).Norm() > 1e-12 {
+		t.Errorf("got vertex color %v (%v), want [0.25 0.75 0]", record.VertexColor, record.HasVertexColor)
+	}
+
+	// meshes are indexed as a single actor
+	idx := NewIndex(Collection{Actor{shape: mesh, material: Lambertian{ConstantTexture{Vec3{1, 1, 1}}}}}, 0, 0, 0, 1)
+	if hit, record := idx.Hit(ray, 0, math.Inf(1)); !hit || record.Material == nil || record.Distance != 1 {
+		t.Errorf("got hit %+v, want the mesh actor hit at distance 1", record)
+	}
+
+	// meshes can be moved and turned like the other shapes
+	moved := Translate{NewRotateY(mesh, 90), Vec3{0, 0, 5}}
+	hit, record := moved.Hit(Ray{Origin: Vec3{-1, 0.5, 4.5}, Direction: Vec3{1, 0, 0}}, 0, math.Inf(1))
+	if !hit || record.Position.Sub(Vec3{0, 0.5, 4.5}).Norm() > 1e-9 || record.Normal.Sub(Vec3{1, 0, 0}).Norm() > 1e-9 {
+		t.Errorf("got hit %+v, want the turned mesh hit at [0 0.5 4.5] with normal [1 0 0]", record)
+	}
+	if math.Abs(record.U-0.5) > 1e-9 || math.Abs(record.V-0.5) > 1e-9 {
+		t.Errorf("got u = %v and v = %v, want the texture coordinates 0.5 and 0.5 of the middle of the quad", record.U, record.V)
+	}
+}
+
+func TestNewMeshErrors(t *testing.T) {
+	vertices := []Vec3{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}}
+	tests := []struct {
+		name    string
+		indices []int
+		normals []Vec3
+		uvs     []Vec3
+		want    string
+	}{
+		{"no triangles", nil, nil, nil, "got 0 indices"},
+		{"incomplete triangle", []int{0, 1, 2, 0}, nil, nil, "got 4 indices"},
+		{"index out of range", []int{0, 1, 3}, nil, nil, "vertex index 3 out of range"},
+		{"negative index", []int{0, -1, 2}, nil, nil, "vertex index -1 out of range"},
+		{"normals", []int{0, 1, 2}, []Vec3{{0, 0, 1}}, nil, "as many normals as vertices"},
+		{"texture coordinates", []int{0, 1, 2}, nil, []Vec3{{}, {}}, "as many texture coordinates as vertices"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewMesh(vertices, test.indices, test.normals, test.uvs)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
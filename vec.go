@@ -122,6 +122,11 @@ func MaxCoord(u Vec3, v Vec3) Vec3 {
 	return Vec3{math.Max(u.X, v.X), math.Max(u.Y, v.Y), math.Max(u.Z, v.Z)}
 }
 
+// MaxComponent returns the largest coordinate of u
+func (u Vec3) MaxComponent() float64 {
+	return math.Max(u.X, math.Max(u.Y, u.Z))
+}
+
 // Norm returns the euclidean norm of u
 func (u Vec3) Norm() float64 {
 	return math.Sqrt(u.SquareNorm())
@@ -137,8 +142,13 @@ func RandSphere(rnd *rand.Rand) Vec3 {
 	if rnd == nil {
 		panic("No random source")
 	}
-	a := 2.0 * rnd.Float64() * math.Pi
-	z := 2.0 * (rnd.Float64() - 0.5)
+	return SampleSphere(rnd.Float64(), rnd.Float64())
+}
+
+// SampleSphere maps two values of [0, 1) to the unit sphere, uniformly distributed values giving uniformly distributed vectors
+func SampleSphere(u, v float64) Vec3 {
+	a := 2.0 * u * math.Pi
+	z := 2.0 * (v - 0.5)
 	r := math.Sqrt(1 - z*z)
 	return Vec3{
 		r * math.Cos(a),
@@ -152,8 +162,13 @@ func RandDisk(rnd *rand.Rand) Vec3 {
 	if rnd == nil {
 		panic("No random source")
 	}
-	theta := 2 * math.Pi * rnd.Float64()
-	r := rnd.Float64()
+	return SampleDisk(rnd.Float64(), rnd.Float64())
+}
+
+// SampleDisk maps two values of [0, 1) to the unit disk, as the angle and the distance to the center
+func SampleDisk(u, v float64) Vec3 {
+	theta := 2 * math.Pi * u
+	r := v
 	return Vec3{X: r * math.Cos(theta), Y: r * math.Sin(theta)}
 }
 